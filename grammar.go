@@ -0,0 +1,160 @@
+package textlexer
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+)
+
+// Grammar is a set of rules that can be used to lex many inputs.
+type Grammar struct {
+	mu    sync.RWMutex
+	rules RuleSet
+
+	// rules of a lexer along with their options, for grammars returned by
+	// SwitchGrammar
+	saved *ruleState
+}
+
+// ruleState holds the rules of a lexer and the options they were added with.
+type ruleState struct {
+	rules       []LexemeType
+	rulesMap    map[LexemeType]Rule
+	classifiers map[LexemeType]func(text []rune) LexemeType
+	splitters   map[LexemeType]splitter
+	contextual  map[LexemeType]bool
+	firstRunes  map[LexemeType]map[rune]bool
+	priorities  map[LexemeType]int
+}
+
+func NewGrammar() *Grammar {
+	return &Grammar{}
+}
+
+func (g *Grammar) AddRule(lexType LexemeType, lexRule Rule) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, def := range g.rules {
+		if def.Type == lexType {
+			return fmt.Errorf("rule %q already exists", lexType)
+		}
+	}
+
+	g.rules = append(g.rules, RuleDef{Type: lexType, Rule: lexRule})
+	if g.saved != nil {
+		// the options of the saved rules are kept, the new rule has none
+		g.saved.rules = append(g.saved.rules, lexType)
+		g.saved.rulesMap[lexType] = lexRule
+	}
+	return nil
+}
+
+func (g *Grammar) MustAddRule(lexType LexemeType, lexRule Rule) {
+	if err := g.AddRule(lexType, lexRule); err != nil {
+		panic(fmt.Sprintf("MustAddRule: %v", err))
+	}
+}
+
+// NewLexer creates a lexer for r with the rules of the grammar. Rules added to
+// the grammar afterwards are not seen by the lexer.
+func (g *Grammar) NewLexer(r Reader, opts ...Option) (*TextLexer, error) {
+	lx := New(r, opts...)
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if err := lx.AddRules(g.rules); err != nil {
+		return nil, err
+	}
+
+	return lx, nil
+}
+
+// SwitchGrammar replaces the rules of the lexer with the rules of g, as when
+// going into a part of the input written in another language, such as the
+// contents of a <script> tag. Rules added to the lexer by other means are
+// dropped. The new rules are used from the next lexeme on, a lexeme already
+// being matched is matched with the rules it started with.
+//
+// SwitchGrammar returns a grammar with the rules the lexer had before, along
+// with the options they were added with, such as priorities and classifiers.
+// Give it back to SwitchGrammar to restore them. Rules added with
+// AddRuleWithContext or AddSymbolRule refer to the lexer they were added to,
+// so the returned grammar is only meant for that lexer.
+func (lx *TextLexer) SwitchGrammar(g *Grammar) (*Grammar, error) {
+	g.mu.RLock()
+	rs := append(RuleSet(nil), g.rules...)
+	var st *ruleState
+	if g.saved != nil {
+		st = g.saved.clone()
+	}
+	g.mu.RUnlock()
+
+	lx.rulesMu.Lock()
+	defer lx.rulesMu.Unlock()
+
+	if lx.maxRules > 0 && len(rs) > lx.maxRules {
+		return nil, fmt.Errorf("grammar exceeds the limit of %d rules", lx.maxRules)
+	}
+
+	prev := &Grammar{saved: lx.ruleState()}
+	for _, lexType := range prev.saved.rules {
+		prev.rules = append(prev.rules, RuleDef{Type: lexType, Rule: prev.saved.rulesMap[lexType]})
+	}
+
+	if st == nil {
+		st = &ruleState{
+			rules:       make([]LexemeType, 0, len(rs)),
+			rulesMap:    make(map[LexemeType]Rule, len(rs)),
+			classifiers: map[LexemeType]func(text []rune) LexemeType{},
+			splitters:   map[LexemeType]splitter{},
+			contextual:  map[LexemeType]bool{},
+			firstRunes:  map[LexemeType]map[rune]bool{},
+			priorities:  map[LexemeType]int{},
+		}
+		for _, def := range rs {
+			st.rules = append(st.rules, def.Type)
+			st.rulesMap[def.Type] = def.Rule
+		}
+	}
+
+	lx.rules = st.rules
+	lx.rulesMap = st.rulesMap
+	lx.classifiers = st.classifiers
+	lx.splitters = st.splitters
+	lx.contextual = st.contextual
+	lx.firstRunes = st.firstRunes
+	lx.priorities = st.priorities
+
+	lx.resetDispatch()
+
+	return prev, nil
+}
+
+// ruleState returns a copy of the rules of the lexer and their options.
+func (lx *TextLexer) ruleState() *ruleState {
+	st := &ruleState{
+		rules:       lx.rules,
+		rulesMap:    lx.rulesMap,
+		classifiers: lx.classifiers,
+		splitters:   lx.splitters,
+		contextual:  lx.contextual,
+		firstRunes:  lx.firstRunes,
+		priorities:  lx.priorities,
+	}
+	return st.clone()
+}
+
+// clone returns a copy of st that can be changed without changing st.
+func (st *ruleState) clone() *ruleState {
+	return &ruleState{
+		rules:       append([]LexemeType(nil), st.rules...),
+		rulesMap:    maps.Clone(st.rulesMap),
+		classifiers: maps.Clone(st.classifiers),
+		splitters:   maps.Clone(st.splitters),
+		contextual:  maps.Clone(st.contextual),
+		firstRunes:  maps.Clone(st.firstRunes),
+		priorities:  maps.Clone(st.priorities),
+	}
+}
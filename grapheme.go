@@ -0,0 +1,61 @@
+package textlexer
+
+import (
+	"unicode"
+)
+
+const (
+	zeroWidthJoiner = '\u200d'
+)
+
+// graphemeLen counts the grapheme clusters in text. This is an approximation
+// of the Unicode segmentation rules that covers combining marks, variation
+// selectors, emoji modifiers, zero width joiner sequences, regional indicator
+// pairs and "\r\n".
+func graphemeLen(text []rune) int {
+	n := 0
+	regionalIndicators := 0
+
+	for i, r := range text {
+		if i > 0 && extendsGrapheme(text[i-1], r) {
+			continue
+		}
+
+		if isRegionalIndicator(r) {
+			regionalIndicators++
+			if regionalIndicators%2 == 0 {
+				// second half of a flag
+				continue
+			}
+		} else {
+			regionalIndicators = 0
+		}
+
+		n++
+	}
+
+	return n
+}
+
+func extendsGrapheme(prev, r rune) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return true
+	case prev == zeroWidthJoiner:
+		return true
+	case r == zeroWidthJoiner:
+		return true
+	case r >= 0xfe00 && r <= 0xfe0f:
+		// variation selectors
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff:
+		// emoji skin tone modifiers
+		return true
+	}
+
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
@@ -0,0 +1,144 @@
+package textlexer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	LexemeTypeIndent LexemeType = "INDENT"
+	LexemeTypeDedent LexemeType = "DEDENT"
+)
+
+// defaultIndentTabWidth is the number of columns a tab indents to, unless
+// WithTabWidth is used.
+const defaultIndentTabWidth = 8
+
+// ErrInconsistentDedent is returned by Next with WithIndentation when a line
+// is indented less than the line before but does not go back to any of the
+// enclosing indentation levels.
+var ErrInconsistentDedent = errors.New("inconsistent dedent")
+
+// indent handles the indentation of the line that starts at the current
+// position, with WithIndentation. It returns the first INDENT or DEDENT
+// lexeme when the indentation changes, and keeps any other DEDENT lexemes
+// pending. It returns nil if the indentation did not change, or if the line
+// is blank, in which case it is left to the rules.
+func (lx *TextLexer) indent() (*Lexeme, error) {
+	for lx.col == 0 {
+		width, text, sizes, next, err := lx.readIndentation()
+		if err != nil {
+			return nil, err
+		}
+
+		if next == RuneEOF {
+			return nil, nil
+		}
+
+		if next == '\n' || next == '\r' {
+			if !lx.skipWhitespace {
+				return nil, nil
+			}
+
+			// blank lines are skipped along with whitespace
+			if err := lx.skipLeadingWhitespace(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		top := 0
+		if n := len(lx.indents); n > 0 {
+			top = lx.indents[n-1]
+		}
+
+		// levels that are kept
+		n := len(lx.indents)
+		for n > 0 && lx.indents[n-1] > width {
+			n--
+		}
+
+		if width < top {
+			level := 0
+			if n > 0 {
+				level = lx.indents[n-1]
+			}
+			if level != width {
+				return nil, fmt.Errorf("line %d: %w", lx.line, ErrInconsistentDedent)
+			}
+		}
+
+		lx.move(text, sizes)
+		if err := lx.seek(int64(lx.byteOffset), io.SeekStart); err != nil {
+			return nil, fmt.Errorf("seek: %v", err)
+		}
+
+		switch {
+		case width > top:
+			lx.indents = append(lx.indents[:len(lx.indents):len(lx.indents)], width)
+			return lx.newLexeme(LexemeTypeIndent, nil), nil
+		case width < top:
+			return lx.dedent(n), nil
+		}
+
+		return nil, nil
+	}
+
+	return nil, nil
+}
+
+// readIndentation reads the spaces and tabs at the start of a line and tells
+// how many columns they take, along with the rune that follows them. The
+// input is left where it was.
+func (lx *TextLexer) readIndentation() (width int, text []rune, sizes []int, next rune, err error) {
+	tabWidth := lx.tabWidth
+	if tabWidth < 1 {
+		tabWidth = defaultIndentTabWidth
+	}
+
+	for {
+		r, size, err := lx.readRune()
+		if err != nil && err != io.EOF {
+			return 0, nil, nil, 0, fmt.Errorf("read error: %w", err)
+		}
+
+		if err == io.EOF {
+			next = RuneEOF
+			break
+		}
+		if r != ' ' && r != '\t' {
+			next = r
+			break
+		}
+
+		if r == '\t' {
+			width += tabWidth - width%tabWidth
+		} else {
+			width++
+		}
+
+		text = append(text, r)
+		sizes = append(sizes, size)
+	}
+
+	if err := lx.seek(int64(lx.byteOffset), io.SeekStart); err != nil {
+		return 0, nil, nil, 0, fmt.Errorf("seek: %v", err)
+	}
+
+	return width, text, sizes, next, nil
+}
+
+// dedent drops the indentation levels past the first n, it returns a DEDENT
+// lexeme for the first level dropped and keeps the others pending.
+func (lx *TextLexer) dedent(n int) *Lexeme {
+	var lexemes []*Lexeme
+	for range lx.indents[n:] {
+		lexemes = append(lexemes, lx.newLexeme(LexemeTypeDedent, nil))
+	}
+
+	lx.indents = lx.indents[:n:n]
+	lx.pending = append(lx.pending, lexemes[1:]...)
+
+	return lexemes[0]
+}
@@ -1,20 +1,265 @@
 package textlexer
 
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
 type LexemeType string
 
 const LexemeTypeUnknown LexemeType = "UNKNOWN"
 
+// UnknownReason tells why an UNKNOWN lexeme was produced.
+type UnknownReason uint8
+
+const (
+	UnknownReasonNone UnknownReason = iota
+
+	// no rule matched the first rune of the lexeme
+	UnknownReasonNoRule
+	// some rules started matching the lexeme but none of them accepted it
+	UnknownReasonNoMatch
+)
+
 type Lexeme struct {
 	Type LexemeType
 
 	text   []rune
 	offset int
+
+	line, col int
+
+	unknownReason UnknownReason
+
+	// input the lexeme was read from and its byte range, see WithZeroCopy
+	source     string
+	start, end int
+	hasSource  bool
+
+	// text returned instead of the input, see WithTextTransform
+	transformed    []rune
+	hasTransformed bool
+}
+
+type lexemeJSON struct {
+	Type   LexemeType `json:"type"`
+	Text   string     `json:"text"`
+	Offset int        `json:"offset"`
+	Len    int        `json:"len"`
+	Line   int        `json:"line"`
+	Col    int        `json:"col"`
 }
 
+// Text returns the text of the lexeme. For lexers created with WithZeroCopy
+// it is a substring of the input and does not allocate.
 func (t *Lexeme) Text() string {
+	if t.hasTransformed {
+		return string(t.transformed)
+	}
+
+	return t.inputText()
+}
+
+// inputText returns the text of the lexeme as it is in the input, before any
+// WithTextTransform.
+func (t *Lexeme) inputText() string {
+	if t.hasSource {
+		return t.source[t.start:t.end]
+	}
+
 	return string(t.text)
 }
 
+// Runes returns a copy of the runes of the lexeme.
+func (t *Lexeme) Runes() []rune {
+	return append([]rune(nil), t.runes()...)
+}
+
+// runes returns the runes of the lexeme, transformed if they were.
+func (t *Lexeme) runes() []rune {
+	if t.hasTransformed {
+		return t.transformed
+	}
+
+	return t.text
+}
+
+// Offset returns the position of the first rune of the lexeme, counted in
+// runes from the start of the input, including the runes skipped by
+// WithLineContinuation.
+func (t *Lexeme) Offset() int {
+	return t.offset
+}
+
+// Line returns the zero-based line the lexeme starts at.
+func (t *Lexeme) Line() int {
+	return t.line
+}
+
+// Col returns the zero-based column the lexeme starts at.
+func (t *Lexeme) Col() int {
+	return t.col
+}
+
+// Len returns the length of the lexeme in runes, as it is in the input. Runes
+// skipped by WithLineContinuation are not counted.
+func (t *Lexeme) Len() int {
+	return len(t.text)
+}
+
+// GraphemeLen returns the length of the lexeme in grapheme clusters, which is
+// what a user sees as characters.
+func (t *Lexeme) GraphemeLen() int {
+	return graphemeLen(t.text)
+}
+
+// String returns the type, the quoted text, the offset and the length of the
+// lexeme, as in INT("123")@0+3.
+func (t *Lexeme) String() string {
+	return fmt.Sprintf("%s(%q)@%d+%d", t.Type, t.Text(), t.offset, len(t.text))
+}
+
+// Equal tells whether both lexemes have the same type, text and offset. Their
+// line and column follow from the offset and are not compared.
+func (t *Lexeme) Equal(other *Lexeme) bool {
+	if t == nil || other == nil {
+		return t == other
+	}
+
+	return t.offset == other.offset && t.EqualIgnoreOffset(other)
+}
+
+// EqualIgnoreOffset tells whether both lexemes have the same type and text,
+// wherever they are in the input.
+func (t *Lexeme) EqualIgnoreOffset(other *Lexeme) bool {
+	if t == nil || other == nil {
+		return t == other
+	}
+
+	return t.Type == other.Type && slices.Equal(t.runes(), other.runes())
+}
+
+// UnknownReason returns UnknownReasonNone for lexemes matched by a rule.
+func (t *Lexeme) UnknownReason() UnknownReason {
+	return t.unknownReason
+}
+
+// Int parses the text of the lexeme as a base 10 integer.
+func (t *Lexeme) Int() (int64, error) {
+	return strconv.ParseInt(t.Text(), 10, 64)
+}
+
+// Float parses the text of the lexeme as a floating point number.
+func (t *Lexeme) Float() (float64, error) {
+	return strconv.ParseFloat(t.Text(), 64)
+}
+
+// Unquote removes the quotes around the text of the lexeme and replaces its
+// escape sequences, as in Go. Text can be quoted with double quotes, single
+// quotes or backquotes, single quotes are not limited to one character.
+func (t *Lexeme) Unquote() (string, error) {
+	text := t.Text()
+
+	if len(text) < 2 || text[0] != text[len(text)-1] {
+		return "", fmt.Errorf("lexeme %q is not quoted", text)
+	}
+
+	quote := text[0]
+	switch quote {
+	case '`':
+		return strconv.Unquote(text)
+	case '"', '\'':
+	default:
+		return "", fmt.Errorf("lexeme %q is not quoted", text)
+	}
+
+	var b strings.Builder
+
+	s := text[1 : len(text)-1]
+	for len(s) > 0 {
+		r, multibyte, tail, err := strconv.UnquoteChar(s, quote)
+		if err != nil {
+			return "", fmt.Errorf("lexeme %q: %w", text, err)
+		}
+
+		if multibyte {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte(byte(r))
+		}
+		s = tail
+	}
+
+	return b.String(), nil
+}
+
+func (t *Lexeme) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lexemeJSON{
+		Type:   t.Type,
+		Text:   t.Text(),
+		Offset: t.offset,
+		Len:    len(t.runes()),
+		Line:   t.line,
+		Col:    t.col,
+	})
+}
+
+func (t *Lexeme) UnmarshalJSON(data []byte) error {
+	var v lexemeJSON
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	text := []rune(v.Text)
+	if v.Len != len(text) {
+		return fmt.Errorf("lexeme length %d does not match text %q", v.Len, v.Text)
+	}
+
+	t.Type = v.Type
+	t.text = text
+	t.offset = v.Offset
+	t.line, t.col = v.Line, v.Col
+	t.source, t.hasSource = "", false
+	t.transformed, t.hasTransformed = nil, false
+
+	return nil
+}
+
+// SubLex tokenizes the text of the lexeme with the given rules, the offsets of
+// the returned lexemes are relative to the start of the parent input.
+func (t *Lexeme) SubLex(rs RuleSet) ([]*Lexeme, error) {
+	lx := NewFromString(t.inputText())
+
+	if err := lx.AddRules(rs); err != nil {
+		return nil, err
+	}
+
+	var lexemes []*Lexeme
+	for {
+		lex, err := lx.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		if lex.line == 0 {
+			lex.col += t.col
+		}
+		lex.line += t.line
+		lex.offset += t.offset
+		lexemes = append(lexemes, lex)
+	}
+
+	return lexemes, nil
+}
+
 func NewLexeme(typ LexemeType, text string) *Lexeme {
 	return &Lexeme{
 		Type: typ,
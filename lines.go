@@ -0,0 +1,69 @@
+package textlexer
+
+import (
+	"io"
+)
+
+// Lines reads the rest of the input and calls fn with the lexemes that start
+// on each line, along with the line number. A lexeme that spans several lines
+// belongs to the line it starts on, lines on which no lexeme starts are
+// skipped. Reading stops when fn returns false, the lexer then goes back to
+// where it was before reading the lexeme that starts the next line, so the
+// next call to Next returns it again. As with Restore, that part of the input
+// is read again.
+func (lx *TextLexer) Lines(fn func(line int, lexemes []*Lexeme) bool) error {
+	var lexemes []*Lexeme
+
+	for {
+		mark := lx.markLine()
+
+		lex, err := lx.Next()
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+
+			if len(lexemes) > 0 {
+				fn(lexemes[0].line, lexemes)
+			}
+			return nil
+		}
+
+		if len(lexemes) > 0 && lex.line != lexemes[0].line {
+			if !fn(lexemes[0].line, lexemes) {
+				return lx.unread(mark, lex)
+			}
+			lexemes = nil
+		}
+
+		lexemes = append(lexemes, lex)
+	}
+}
+
+// lineMark is the state of the lexer before a lexeme is read, so it can be
+// read again.
+type lineMark struct {
+	snapshot Snapshot
+	stats    map[LexemeType]*RuleStats
+}
+
+func (lx *TextLexer) markLine() lineMark {
+	snapshot := lx.Snapshot()
+
+	lx.mu.Lock()
+	defer lx.mu.Unlock()
+
+	return lineMark{snapshot: snapshot, stats: lx.copyStats()}
+}
+
+// unread moves the lexer back to mark, as if lex, which was read from there,
+// had not been read, so Next returns it again.
+func (lx *TextLexer) unread(mark lineMark, lex *Lexeme) error {
+	lx.mu.Lock()
+	lx.dropBoundaries(mark.snapshot.offset, lx.offset)
+	lx.stats = mark.stats
+	lx.typeCounts[lex.Type]--
+	lx.mu.Unlock()
+
+	return lx.Restore(mark.snapshot)
+}
@@ -0,0 +1,58 @@
+package textlexer
+
+import (
+	"fmt"
+	"io"
+)
+
+// MatchLongest feeds the runes read from rr to rule and returns the match the
+// rule accepts and its length in runes. The reader is consumed past the end of
+// the match, as rules need lookahead to decide.
+func MatchLongest(rr io.RuneReader, rule Rule) (string, int, bool, error) {
+	var buf []rune
+
+	pushBacks := 0
+
+	for {
+		r, _, err := rr.ReadRune()
+		if err != nil {
+			if err != io.EOF {
+				return "", 0, false, fmt.Errorf("read error: %w", err)
+			}
+			r = RuneEOF
+		}
+
+		if len(buf) == 0 && IsEOF(r) {
+			return "", 0, false, nil
+		}
+
+		next, state := rule(r)
+		for state == StatePushBack && next != nil {
+			pushBacks++
+			next, state = next(r)
+		}
+
+		switch state {
+		case StateAccept:
+			if len(buf) == 0 && pushBacks == 0 {
+				return string(r), 1, true, nil
+			}
+
+			n := len(buf) - pushBacks
+			if n < 1 {
+				return "", 0, false, nil
+			}
+
+			return string(buf[:n]), n, true, nil
+		case StateContinue:
+			if IsEOF(r) || next == nil {
+				return "", 0, false, nil
+			}
+		default:
+			return "", 0, false, nil
+		}
+
+		buf = append(buf, r)
+		rule = next
+	}
+}
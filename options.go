@@ -0,0 +1,226 @@
+package textlexer
+
+import (
+	"errors"
+	"io"
+)
+
+type Option func(lx *TextLexer)
+
+// AcceptInconclusiveAtEOF makes rules that are still expecting more input
+// when EOF is reached accept the runes they have consumed so far, instead of
+// discarding them.
+func AcceptInconclusiveAtEOF(accept bool) Option {
+	return func(lx *TextLexer) {
+		lx.acceptInconclusiveAtEOF = accept
+	}
+}
+
+// WithSkipLeadingWhitespace discards the whitespace found before each lexeme,
+// so no rule is needed to match it. Whitespace is skipped before any rule is
+// tried, which means rules never see leading whitespace.
+func WithSkipLeadingWhitespace() Option {
+	return func(lx *TextLexer) {
+		lx.skipWhitespace = true
+	}
+}
+
+// NormalizeNewlines replaces "\r\n" and "\r" with a single "\n" before rules
+// see the input. Offsets are counted over the normalized input, so a "\r\n"
+// counts as one rune and offsets no longer match the raw input.
+func NormalizeNewlines(normalize bool) Option {
+	return func(lx *TextLexer) {
+		lx.normalizeNewlines = normalize
+	}
+}
+
+// WithLineContinuation joins lines that end with escape, as in shell scripts
+// and C macros: the escape and the line break that follows are skipped, so
+// rules see the lines as one. The line break can be "\n" or "\r\n". Offsets,
+// lines and columns still follow the input, but the text of a lexeme that
+// spans joined lines is shorter than the input it covers.
+func WithLineContinuation(escape rune) Option {
+	return func(lx *TextLexer) {
+		lx.lineContinuation = escape
+	}
+}
+
+// WithIndentation makes the lexer produce INDENT and DEDENT lexemes, with no
+// text, where the indentation of a line changes, as in Python. A line indented
+// more than the one before opens a block with an INDENT, a line indented less
+// closes each block it leaves with a DEDENT, and blocks still open are closed
+// at the end of the input. A dedent that does not go back to the indentation
+// of an enclosing block makes Next return ErrInconsistentDedent.
+//
+// Indentation is made of spaces and tabs, a tab moves to the next multiple of
+// 8 columns, or of the width set with WithTabWidth. It is skipped rather than
+// given to rules. Blank lines do not change the indentation. Line breaks must
+// not be matched along with the indentation that follows, so use rules such
+// as rules.Newline and rules.HorizontalWhitespace, or
+// WithSkipLeadingWhitespace.
+func WithIndentation() Option {
+	return func(lx *TextLexer) {
+		lx.indentation = true
+	}
+}
+
+// InvalidUTF8Policy tells what to do with bytes of the input that are not
+// valid UTF-8, see WithInvalidUTF8Policy.
+type InvalidUTF8Policy uint8
+
+const (
+	// InvalidUTF8Replace gives rules a U+FFFD replacement character for each
+	// invalid byte, as io.RuneReader does.
+	InvalidUTF8Replace InvalidUTF8Policy = iota
+
+	// InvalidUTF8Skip drops invalid bytes, rules never see them.
+	InvalidUTF8Skip
+
+	// InvalidUTF8Error makes Next return ErrInvalidUTF8.
+	InvalidUTF8Error
+)
+
+// ErrInvalidUTF8 is returned by Next when the input has a byte that is not
+// valid UTF-8, with InvalidUTF8Error.
+var ErrInvalidUTF8 = errors.New("invalid UTF-8")
+
+// WithInvalidUTF8Policy sets what to do with bytes of the input that are not
+// valid UTF-8. By default they are replaced with U+FFFD, which rules see as
+// any other rune. Skipped bytes are not counted in offsets or columns.
+// Replacement characters that are in the input are always kept.
+func WithInvalidUTF8Policy(policy InvalidUTF8Policy) Option {
+	return func(lx *TextLexer) {
+		lx.invalidUTF8 = policy
+	}
+}
+
+// WithTrace writes a line to w for each rune given to each rule, with the rune,
+// the type of the rule and the state the rule returned.
+func WithTrace(w io.Writer) Option {
+	return func(lx *TextLexer) {
+		lx.tracer = w
+	}
+}
+
+// WithMaxPushback limits how many runes a rule may push back while matching a
+// single lexeme. A rule that goes past the limit is rejected. By default a rule
+// may push back as many runes as it has consumed.
+func WithMaxPushback(n int) Option {
+	return func(lx *TextLexer) {
+		lx.maxPushback = n
+	}
+}
+
+// WithGraphemeColumns counts columns in grapheme clusters instead of runes, so
+// that a letter followed by a combining accent or an emoji sequence joined
+// with zero width joiners takes a single column.
+func WithGraphemeColumns() Option {
+	return func(lx *TextLexer) {
+		lx.graphemeColumns = true
+	}
+}
+
+// WithTabWidth makes a tab move the column to the next multiple of n, as
+// editors show it. By default a tab takes a single column.
+func WithTabWidth(n int) Option {
+	return func(lx *TextLexer) {
+		lx.tabWidth = n
+	}
+}
+
+// WithMaxReadAhead makes the lexer stop reading after n runes from the start
+// of a lexeme, even if some rules are still expecting more input. The longest
+// match found so far is returned, or an UNKNOWN lexeme with the n runes if
+// there is none. It keeps rules that never decide from reading the whole
+// input.
+func WithMaxReadAhead(n int) Option {
+	return func(lx *TextLexer) {
+		lx.maxReadAhead = n
+	}
+}
+
+// WithMaxInput makes Next return ErrInputLimitExceeded instead of reading
+// past the first n runes of the input, even in the middle of a lexeme. Unlike
+// WithMaxReadAhead it bounds the whole input, as a guard against inputs that
+// are too large to be lexed.
+func WithMaxInput(n int) Option {
+	return func(lx *TextLexer) {
+		lx.maxInput = n
+	}
+}
+
+// WithMaxRules makes AddRule fail once n rules are registered. Every rule is
+// given every rune of the input until it rejects it, so the time spent on each
+// lexeme grows with the number of rules.
+func WithMaxRules(n int) Option {
+	return func(lx *TextLexer) {
+		lx.maxRules = n
+	}
+}
+
+// WithFirstRuneIndex remembers which rules reject each first rune, so they
+// are not tried again on lexemes starting with the same rune. This pays off
+// with many rules that only match a few first runes, such as keywords. Rules
+// must decide on the first rune the same way every time, except for rules
+// added with AddRuleWithContext, which are always tried.
+func WithFirstRuneIndex() Option {
+	return func(lx *TextLexer) {
+		lx.probeFirstRunes = true
+	}
+}
+
+// WithMergedUnknown makes consecutive UNKNOWN lexemes come out as a single
+// UNKNOWN lexeme that spans the whole unmatched input.
+func WithMergedUnknown() Option {
+	return func(lx *TextLexer) {
+		lx.mergeUnknown = true
+	}
+}
+
+// WithStats makes the lexer count how many lexemes each rule was tried on and
+// how many it produced, see Stats.
+func WithStats() Option {
+	return func(lx *TextLexer) {
+		lx.stats = map[LexemeType]*RuleStats{}
+	}
+}
+
+// WithStrictStates makes Next panic when a rule returns an undefined state,
+// instead of returning ErrInvalidState.
+func WithStrictStates() Option {
+	return func(lx *TextLexer) {
+		lx.strictStates = true
+	}
+}
+
+// WithZeroCopy makes lexers created with NewFromString or NewFromBytes keep
+// their input, so the text of each lexeme is a substring of it instead of a
+// new string. It has no effect with NormalizeNewlines, since the text of the
+// lexemes is no longer the same as the input. Lexemes keep the whole input
+// alive.
+func WithZeroCopy() Option {
+	return func(lx *TextLexer) {
+		lx.zeroCopy = true
+	}
+}
+
+// WithTextTransform makes the lexer pass the text of each lexeme to fn, and
+// return the lexeme with the text fn gives back, as to lowercase keywords or
+// to normalize text to NFC. The offset and the length of the lexeme are still
+// those of the text in the input, and rules, classifiers and splitters see
+// the text as it is in the input. Transformed text is never a substring of
+// the input, even with WithZeroCopy.
+func WithTextTransform(fn func(text []rune) []rune) Option {
+	return func(lx *TextLexer) {
+		lx.transform = fn
+	}
+}
+
+// WithStripBOM discards a byte order mark (U+FEFF) at the start of the input.
+// Offsets and columns are counted from after the mark, so the first lexeme
+// is at offset 0 either way.
+func WithStripBOM() Option {
+	return func(lx *TextLexer) {
+		lx.stripBOM = true
+	}
+}
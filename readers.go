@@ -0,0 +1,100 @@
+package textlexer
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+// multiReader reads its readers one after the other, as if they were a
+// single input. The runes read so far are kept so it can seek back to them.
+type multiReader struct {
+	readers []io.RuneReader
+
+	runes []rune
+	// byte offset of each rune and of the end of the last one
+	offsets []int
+
+	pos int
+	err error
+}
+
+func newMultiReader(rs []io.RuneReader) *multiReader {
+	return &multiReader{
+		readers: rs,
+		offsets: []int{0},
+	}
+}
+
+func (mr *multiReader) ReadRune() (rune, int, error) {
+	if mr.pos == len(mr.runes) {
+		if err := mr.fill(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	r := mr.runes[mr.pos]
+	size := mr.offsets[mr.pos+1] - mr.offsets[mr.pos]
+	mr.pos++
+
+	return r, size, nil
+}
+
+// fill reads the next rune, moving on to the next reader at the end of each.
+func (mr *multiReader) fill() error {
+	for mr.err == nil && len(mr.readers) > 0 {
+		r, size, err := mr.readers[0].ReadRune()
+		if size > 0 {
+			mr.runes = append(mr.runes, r)
+			mr.offsets = append(mr.offsets, mr.offsets[len(mr.offsets)-1]+size)
+		}
+
+		if err == io.EOF {
+			mr.readers = mr.readers[1:]
+		} else if err != nil {
+			mr.err = err
+		}
+
+		if size > 0 {
+			return nil
+		}
+	}
+
+	if mr.err != nil {
+		return mr.err
+	}
+
+	return io.EOF
+}
+
+func (mr *multiReader) Seek(offset int64, whence int) (int64, error) {
+	pos := int64(mr.offsets[mr.pos])
+
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos += offset
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+
+	if pos < 0 {
+		return 0, errors.New("seek: negative position")
+	}
+
+	// read up to the position if it was not reached yet
+	for int64(mr.offsets[len(mr.offsets)-1]) < pos {
+		if err := mr.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	i := sort.SearchInts(mr.offsets, int(pos))
+	if mr.offsets[i] != int(pos) {
+		return 0, errors.New("seek: position is not at the start of a rune")
+	}
+
+	mr.pos = i
+	return pos, nil
+}
@@ -0,0 +1,514 @@
+package rules
+
+import (
+	"github.com/xiam/textlexer"
+)
+
+// feeder consumes one rune and reports whether the rune was consumed
+// (StateContinue), whether a match ended before it (StateAccept, with the
+// number of consumed runes that are not part of the match) or whether there
+// is no match (StateReject).
+type feeder func(r rune) (textlexer.State, int)
+
+// Repeat matches rule at least min and at most max times in a row, a negative
+// max means no limit. Like in the other combinators, a rule that accepts on
+// the first rune it is given matches nothing, so it does not count as a
+// repetition.
+func Repeat(rule textlexer.Rule, min, max int) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		rp := &repetition{rule: rule, min: min, max: max}
+		return drive(rp.feed)(r)
+	}
+}
+
+// Optional matches rule once or not at all, as Repeat(rule, 0, 1).
+func Optional(rule textlexer.Rule) func(r rune) (textlexer.Rule, textlexer.State) {
+	return Repeat(rule, 0, 1)
+}
+
+// SeparatedBy matches one or more elements separated by separator. A
+// separator that is not followed by an element is only matched when
+// allowTrailing is set.
+func SeparatedBy(element, separator textlexer.Rule, allowTrailing bool) func(r rune) (textlexer.Rule, textlexer.State) {
+	list := []func(r rune) (textlexer.Rule, textlexer.State){
+		element,
+		Repeat(Compose(separator, element), 0, -1),
+	}
+
+	if allowTrailing {
+		list = append(list, Optional(separator))
+	}
+
+	return Compose(list...)
+}
+
+// LongestOf runs all the rules side by side and matches the longest match
+// found among them, unlike NewMatchAnyOf, which matches as soon as any rule
+// accepts. A rule that accepts on its first rune matches nothing and is not
+// taken into account.
+func LongestOf(rules ...textlexer.Rule) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		l := &longest{current: append([]textlexer.Rule(nil), rules...)}
+		return drive(l.feed)(r)
+	}
+}
+
+type longest struct {
+	// rules that are still running
+	current []textlexer.Rule
+
+	pos int
+	// length of the longest match so far
+	best int
+}
+
+func (l *longest) feed(r rune) (textlexer.State, int) {
+	running := l.current[:0]
+
+	for _, rule := range l.current {
+		next, state, pushed := step(rule, r)
+
+		switch state {
+		case textlexer.StateContinue:
+			if next != nil && !textlexer.IsEOF(r) {
+				running = append(running, next)
+			}
+		case textlexer.StateAccept:
+			if n := l.pos - pushed; n > l.best {
+				l.best = n
+			}
+		}
+	}
+
+	l.current = running
+
+	if len(l.current) == 0 {
+		if l.best > 0 {
+			return textlexer.StateAccept, l.pos - l.best
+		}
+
+		return textlexer.StateReject, 0
+	}
+
+	l.pos++
+	return textlexer.StateContinue, 0
+}
+
+type sequence struct {
+	rules []func(r rune) (textlexer.Rule, textlexer.State)
+
+	// called with the ends of the sub-matches when the sequence matches
+	onMatch func(ends []int)
+
+	index   int
+	current textlexer.Rule
+	buf     []rune
+
+	pos  int
+	ends []int
+}
+
+func (s *sequence) feed(r rune) (textlexer.State, int) {
+	if s.index >= len(s.rules) {
+		return textlexer.StateAccept, 0
+	}
+
+	if s.current == nil {
+		s.current = s.rules[s.index]
+	}
+
+	next, state, pushed := step(s.current, r)
+
+	switch state {
+	case textlexer.StateContinue:
+		s.current = next
+		s.buf = append(s.buf, r)
+		s.pos++
+		return textlexer.StateContinue, 0
+	case textlexer.StateAccept:
+		pending := unconsumed(s.buf, pushed)
+
+		s.index++
+		s.current = nil
+		s.buf = nil
+
+		s.pos -= len(pending)
+		s.ends = append(s.ends, s.pos)
+
+		if s.index >= len(s.rules) {
+			if s.onMatch != nil {
+				s.onMatch(append([]int(nil), s.ends...))
+			}
+			return textlexer.StateAccept, len(pending)
+		}
+
+		// the runes the rule did not keep belong to the next one
+		return replay(s.feed, pending, r)
+	}
+
+	return textlexer.StateReject, 0
+}
+
+type repetition struct {
+	rule     textlexer.Rule
+	min, max int
+
+	count   int
+	current textlexer.Rule
+	buf     []rune
+}
+
+func (rp *repetition) feed(r rune) (textlexer.State, int) {
+	if rp.max >= 0 && rp.count >= rp.max {
+		return textlexer.StateAccept, 0
+	}
+
+	if rp.current == nil {
+		rp.current = rp.rule
+	}
+
+	next, state, pushed := step(rp.current, r)
+
+	switch state {
+	case textlexer.StateContinue:
+		rp.current = next
+		rp.buf = append(rp.buf, r)
+		return textlexer.StateContinue, 0
+	case textlexer.StateAccept:
+		pending := unconsumed(rp.buf, pushed)
+		if len(pending) == len(rp.buf) {
+			// an empty match would repeat forever
+			return textlexer.StateAccept, len(pending)
+		}
+
+		rp.count++
+		rp.current = nil
+		rp.buf = nil
+
+		return replay(rp.feed, pending, r)
+	}
+
+	if rp.count >= rp.min {
+		// give back the runes of the incomplete iteration
+		return textlexer.StateAccept, len(rp.buf)
+	}
+
+	return textlexer.StateReject, 0
+}
+
+// step feeds r to rule and resolves any push backs it requests.
+func step(rule textlexer.Rule, r rune) (textlexer.Rule, textlexer.State, int) {
+	pushed := 0
+
+	next, state := rule(r)
+	for state == textlexer.StatePushBack {
+		if next == nil {
+			return nil, textlexer.StateReject, 0
+		}
+
+		pushed++
+		next, state = next(r)
+	}
+
+	return next, state, pushed
+}
+
+// unconsumed returns a copy of the last n runes of buf.
+func unconsumed(buf []rune, n int) []rune {
+	if n > len(buf) {
+		n = len(buf)
+	}
+
+	return append([]rune(nil), buf[len(buf)-n:]...)
+}
+
+// replay feeds runes and then r, stopping as soon as a decision is made.
+func replay(feed feeder, runes []rune, r rune) (textlexer.State, int) {
+	for i := range runes {
+		state, pushed := feed(runes[i])
+
+		switch state {
+		case textlexer.StateAccept:
+			return textlexer.StateAccept, pushed + len(runes) - i
+		case textlexer.StateReject:
+			return textlexer.StateReject, 0
+		}
+	}
+
+	return feed(r)
+}
+
+// drive turns a feeder into a rule. An empty match on the first rune is
+// reported by pushing back one rune more than was consumed, which rules around
+// it take as an empty match and the lexer rejects, since accepting on the
+// first rune alone means a match of that one rune.
+func drive(feed feeder) textlexer.Rule {
+	var next textlexer.Rule
+
+	consumed := 0
+
+	next = func(r rune) (textlexer.Rule, textlexer.State) {
+		state, pushed := feed(r)
+
+		switch state {
+		case textlexer.StateContinue:
+			consumed++
+			return next, textlexer.StateContinue
+		case textlexer.StateAccept:
+			if consumed == 0 {
+				return pushBack(1, Accept)(r)
+			}
+			return pushBack(pushed, Accept)(r)
+		}
+
+		return nil, textlexer.StateReject
+	}
+
+	return next
+}
+
+// pushBack returns a rule that pushes back n runes before handing over to
+// next.
+func pushBack(n int, next textlexer.Rule) textlexer.Rule {
+	if n < 1 {
+		return next
+	}
+
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		return pushBack(n-1, next), textlexer.StatePushBack
+	}
+}
+
+// Until matches the input up to where stop starts matching, stop itself is
+// not part of the match. If stop never matches, Until matches up to EOF. As in
+// LongestOf, stop must match at least one rune, accepting on its first rune
+// does not count as a match.
+func Until(stop textlexer.Rule) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		u := &until{stop: stop, found: -1}
+		return drive(u.feed)(r)
+	}
+}
+
+type until struct {
+	stop textlexer.Rule
+
+	// runes consumed so far
+	pos int
+
+	// instances of stop started at each position that are still running
+	probes []untilProbe
+
+	// earliest position where stop matched, -1 if none
+	found int
+}
+
+type untilProbe struct {
+	start int
+	rule  textlexer.Rule
+	n     int
+}
+
+func (u *until) feed(r rune) (textlexer.State, int) {
+	u.probes = append(u.probes, untilProbe{start: u.pos, rule: u.stop})
+
+	running := u.probes[:0]
+	for _, p := range u.probes {
+		next, state, pushed := step(p.rule, r)
+
+		switch state {
+		case textlexer.StateContinue:
+			if textlexer.IsEOF(r) {
+				// no more input to decide on
+				continue
+			}
+			p.rule = next
+			p.n++
+			running = append(running, p)
+		case textlexer.StateAccept:
+			if n := p.n - pushed; n > 0 && (u.found < 0 || p.start < u.found) {
+				u.found = p.start
+			}
+		}
+	}
+	u.probes = running
+
+	// a match is only final when no earlier probe may still match
+	if u.found >= 0 && (len(u.probes) == 0 || u.probes[0].start > u.found) {
+		if u.found == 0 {
+			return textlexer.StateReject, 0
+		}
+		return textlexer.StateAccept, u.pos - u.found
+	}
+
+	if textlexer.IsEOF(r) {
+		if u.pos == 0 {
+			return textlexer.StateReject, 0
+		}
+		return textlexer.StateAccept, 0
+	}
+
+	u.pos++
+	return textlexer.StateContinue, 0
+}
+
+// FollowedBy matches main only when ahead matches right after it. The input
+// matched by ahead is not part of the match.
+func FollowedBy(main, ahead textlexer.Rule) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		la := &lookahead{main: main, ahead: ahead, matched: -1}
+		return drive(la.feed)(r)
+	}
+}
+
+// NotFollowedBy matches main only when ahead does not match right after it.
+func NotFollowedBy(main, ahead textlexer.Rule) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		la := &lookahead{main: main, ahead: ahead, matched: -1, negate: true}
+		return drive(la.feed)(r)
+	}
+}
+
+type lookahead struct {
+	main, ahead textlexer.Rule
+	negate      bool
+
+	current textlexer.Rule
+	buf     []rune
+
+	// length of the match of main, -1 while main is running
+	matched int
+	// runes consumed so far, once main has matched
+	total int
+	// runes consumed by ahead
+	aheadN int
+}
+
+func (la *lookahead) feed(r rune) (textlexer.State, int) {
+	if la.matched < 0 {
+		if la.current == nil {
+			la.current = la.main
+		}
+
+		next, state, pushed := step(la.current, r)
+
+		switch state {
+		case textlexer.StateContinue:
+			la.current = next
+			la.buf = append(la.buf, r)
+			return textlexer.StateContinue, 0
+		case textlexer.StateAccept:
+			pending := unconsumed(la.buf, pushed)
+
+			la.matched = len(la.buf) - len(pending)
+			if la.matched == 0 {
+				return textlexer.StateReject, 0
+			}
+
+			la.current = la.ahead
+			la.total = len(la.buf)
+
+			// the runes main did not keep are the first ones ahead sees
+			for _, p := range pending {
+				if found, done := la.look(p); done {
+					return la.decide(found)
+				}
+			}
+		default:
+			return textlexer.StateReject, 0
+		}
+	}
+
+	if found, done := la.look(r); done {
+		return la.decide(found)
+	}
+
+	la.total++
+	return textlexer.StateContinue, 0
+}
+
+// look feeds r to ahead and tells whether ahead matched, once it is known.
+func (la *lookahead) look(r rune) (found bool, done bool) {
+	next, state, pushed := step(la.current, r)
+
+	switch state {
+	case textlexer.StateContinue:
+		if textlexer.IsEOF(r) {
+			return false, true
+		}
+		la.current = next
+		la.aheadN++
+		return false, false
+	case textlexer.StateAccept:
+		return la.aheadN-pushed > 0, true
+	}
+
+	return false, true
+}
+
+func (la *lookahead) decide(found bool) (textlexer.State, int) {
+	if found == la.negate {
+		return textlexer.StateReject, 0
+	}
+
+	return textlexer.StateAccept, la.total - la.matched
+}
+
+// ComposeSymbols works like Compose for rules that are given the position of
+// each rune, such as WordBoundary. Rules that only look at runes can be used
+// with textlexer.SymbolRuleFunc.
+func ComposeSymbols(rules ...textlexer.SymbolRule) textlexer.SymbolRule {
+	return func(s textlexer.Symbol) (textlexer.SymbolRule, textlexer.State) {
+		ss := &symbols{}
+
+		// the sequence is given the index of each symbol instead of its rune,
+		// so that the rules can be given the symbol back
+		indexed := make([]func(r rune) (textlexer.Rule, textlexer.State), len(rules))
+		for i := range rules {
+			indexed[i] = ss.rule(rules[i])
+		}
+
+		return ss.feed(Compose(indexed...))(s)
+	}
+}
+
+// symbols holds the symbols given to a ComposeSymbols sequence.
+type symbols struct {
+	seen []textlexer.Symbol
+	eof  textlexer.Symbol
+}
+
+func (ss *symbols) feed(rule textlexer.Rule) textlexer.SymbolRule {
+	return func(s textlexer.Symbol) (textlexer.SymbolRule, textlexer.State) {
+		r := rune(textlexer.RuneEOF)
+		if s.IsEOF() {
+			ss.eof = s
+		} else {
+			r = rune(len(ss.seen))
+			ss.seen = append(ss.seen, s)
+		}
+
+		next, state := rule(r)
+		if next == nil {
+			return nil, state
+		}
+
+		return ss.feed(next), state
+	}
+}
+
+func (ss *symbols) rule(rule textlexer.SymbolRule) textlexer.Rule {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		s := ss.eof
+		if !textlexer.IsEOF(r) {
+			s = ss.seen[r]
+		}
+
+		next, state := rule(s)
+		if next == nil {
+			return nil, state
+		}
+
+		return ss.rule(next), state
+	}
+}
@@ -0,0 +1,618 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/xiam/textlexer"
+)
+
+const maxRepeatCount = 1000
+
+// maxProgramSize is the number of instructions a compiled pattern can take,
+// nested repetitions multiply the size of what they repeat.
+const maxProgramSize = 10000
+
+type nodeKind uint8
+
+const (
+	nodeEmpty nodeKind = iota
+	nodeMatch
+	nodeConcat
+	nodeAlternate
+	nodeRepeat
+)
+
+type node struct {
+	kind nodeKind
+
+	match    func(r rune) bool
+	children []*node
+
+	min, max int
+}
+
+type opcode uint8
+
+const (
+	opRune opcode = iota
+	opSplit
+	opJump
+	opMatch
+)
+
+type instruction struct {
+	op opcode
+
+	match func(r rune) bool
+	x, y  int
+}
+
+type program []instruction
+
+// Compile turns a regular expression into a rule that accepts the longest
+// prefix of the input matched by the expression. Only a subset of the regexp
+// syntax is supported: literals, escapes, character classes, ".", groups,
+// alternation and the "*", "+", "?" and "{m,n}" repetition operators.
+// Matches are never empty.
+func Compile(pattern string) (textlexer.Rule, error) {
+	p := &parser{pattern: []rune(pattern)}
+
+	root, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("compile %q: %w", pattern, err)
+	}
+
+	if root.size() > maxProgramSize {
+		return nil, fmt.Errorf("compile %q: pattern is larger than %d instructions", pattern, maxProgramSize)
+	}
+
+	prog := program{}
+	prog.emit(root)
+	prog = append(prog, instruction{op: opMatch})
+
+	return prog.rule, nil
+}
+
+func MustCompile(pattern string) textlexer.Rule {
+	rule, err := Compile(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("MustCompile: %v", err))
+	}
+	return rule
+}
+
+type parser struct {
+	pattern []rune
+	pos     int
+}
+
+func (p *parser) parse() (*node, error) {
+	n, err := p.parseAlternate()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.pattern) {
+		return nil, p.errorf("unexpected %q", p.pattern[p.pos])
+	}
+
+	return n, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at offset %d", fmt.Sprintf(format, args...), p.pos)
+}
+
+func (p *parser) peek() (rune, bool) {
+	if p.pos >= len(p.pattern) {
+		return 0, false
+	}
+	return p.pattern[p.pos], true
+}
+
+func (p *parser) parseAlternate() (*node, error) {
+	n, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+
+	alternatives := []*node{n}
+	for {
+		if r, ok := p.peek(); !ok || r != '|' {
+			break
+		}
+		p.pos++
+
+		n, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, n)
+	}
+
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+
+	return &node{kind: nodeAlternate, children: alternatives}, nil
+}
+
+func (p *parser) parseConcat() (*node, error) {
+	items := []*node{}
+
+	for {
+		r, ok := p.peek()
+		if !ok || r == '|' || r == ')' {
+			break
+		}
+
+		n, err := p.parseRepeat()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, n)
+	}
+
+	switch len(items) {
+	case 0:
+		return &node{kind: nodeEmpty}, nil
+	case 1:
+		return items[0], nil
+	}
+
+	return &node{kind: nodeConcat, children: items}, nil
+}
+
+func (p *parser) parseRepeat() (*node, error) {
+	n, err := p.parseAtom()
+	if err != nil {
+		return nil, err
+	}
+
+	repeated := false
+	for {
+		r, ok := p.peek()
+		if !ok {
+			break
+		}
+
+		min, max := 0, 0
+
+		switch r {
+		case '*':
+			min, max = 0, -1
+			p.pos++
+		case '+':
+			min, max = 1, -1
+			p.pos++
+		case '?':
+			min, max = 0, 1
+			p.pos++
+		case '{':
+			var ok bool
+			min, max, ok = p.parseRepeatCount()
+			if !ok {
+				// not a repetition, "{" is taken as a literal
+				return n, nil
+			}
+		default:
+			return n, nil
+		}
+
+		if repeated {
+			if r == '?' {
+				return nil, p.errorf("non-greedy repetition is not supported")
+			}
+			return nil, p.errorf("invalid nested repetition operator %q", r)
+		}
+		repeated = true
+
+		if min > maxRepeatCount || max > maxRepeatCount {
+			return nil, p.errorf("repeat count is larger than %d", maxRepeatCount)
+		}
+
+		if max >= 0 && max < min {
+			return nil, p.errorf("invalid repeat count {%d,%d}", min, max)
+		}
+
+		n = &node{kind: nodeRepeat, children: []*node{n}, min: min, max: max}
+	}
+
+	return n, nil
+}
+
+func (p *parser) parseRepeatCount() (int, int, bool) {
+	end := p.pos + 1
+	for end < len(p.pattern) && p.pattern[end] != '}' {
+		end++
+	}
+	if end >= len(p.pattern) {
+		return 0, 0, false
+	}
+
+	var min, max int
+	var err error
+
+	body := string(p.pattern[p.pos+1 : end])
+	for i, c := range body {
+		if c == ',' {
+			if min, err = strconv.Atoi(body[:i]); err != nil {
+				return 0, 0, false
+			}
+
+			if body[i+1:] == "" {
+				max = -1
+			} else if max, err = strconv.Atoi(body[i+1:]); err != nil {
+				return 0, 0, false
+			}
+
+			p.pos = end + 1
+			return min, max, true
+		}
+	}
+
+	if min, err = strconv.Atoi(body); err != nil {
+		return 0, 0, false
+	}
+
+	p.pos = end + 1
+	return min, min, true
+}
+
+func (p *parser) parseAtom() (*node, error) {
+	r := p.pattern[p.pos]
+
+	switch r {
+	case '(':
+		p.pos++
+		if next, ok := p.peek(); ok && next == '?' {
+			return nil, p.errorf("group flags are not supported")
+		}
+
+		n, err := p.parseAlternate()
+		if err != nil {
+			return nil, err
+		}
+
+		if next, ok := p.peek(); !ok || next != ')' {
+			return nil, p.errorf("missing closing )")
+		}
+		p.pos++
+
+		return n, nil
+	case '[':
+		return p.parseClass()
+	case '.':
+		p.pos++
+		return &node{kind: nodeMatch, match: func(r rune) bool {
+			return r != '\n'
+		}}, nil
+	case '\\':
+		match, err := p.parseEscape()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: nodeMatch, match: match}, nil
+	case '^', '$':
+		return nil, p.errorf("anchor %q is not supported", r)
+	case '*', '+', '?':
+		return nil, p.errorf("missing argument to repetition operator %q", r)
+	}
+
+	p.pos++
+	return &node{kind: nodeMatch, match: func(c rune) bool {
+		return c == r
+	}}, nil
+}
+
+func (p *parser) parseEscape() (func(r rune) bool, error) {
+	p.pos++
+
+	r, ok := p.peek()
+	if !ok {
+		return nil, p.errorf("trailing backslash")
+	}
+	p.pos++
+
+	switch r {
+	case 'd':
+		return isNumeric, nil
+	case 'D':
+		return negate(isNumeric), nil
+	case 'w':
+		return isWordChar, nil
+	case 'W':
+		return negate(isWordChar), nil
+	case 's':
+		return isSpace, nil
+	case 'S':
+		return negate(isSpace), nil
+	}
+
+	c, ok := escapedRune(r)
+	if !ok {
+		p.pos -= 2
+		return nil, p.errorf("escape sequence \\%c is not supported", r)
+	}
+
+	return func(r rune) bool {
+		return r == c
+	}, nil
+}
+
+// escapedRune returns the rune a backslash followed by r stands for, it
+// reports false for letters and digits that are not known escapes.
+func escapedRune(r rune) (rune, bool) {
+	switch r {
+	case 'n':
+		return '\n', true
+	case 't':
+		return '\t', true
+	case 'r':
+		return '\r', true
+	case 'f':
+		return '\f', true
+	case 'v':
+		return '\v', true
+	}
+
+	if isLetter(r) || isNumeric(r) {
+		return 0, false
+	}
+
+	return r, true
+}
+
+func (p *parser) parseClass() (*node, error) {
+	p.pos++
+
+	negated := false
+	if r, ok := p.peek(); ok && r == '^' {
+		negated = true
+		p.pos++
+	}
+
+	var members []func(r rune) bool
+
+	for first := true; ; first = false {
+		r, ok := p.peek()
+		if !ok {
+			return nil, p.errorf("missing closing ]")
+		}
+
+		if r == ']' && !first {
+			p.pos++
+			break
+		}
+
+		if r == '[' && p.pos+1 < len(p.pattern) && p.pattern[p.pos+1] == ':' {
+			return nil, p.errorf("named character classes are not supported")
+		}
+
+		var lo rune
+		if r == '\\' {
+			match, err := p.parseEscape()
+			if err != nil {
+				return nil, err
+			}
+
+			escaped := p.pattern[p.pos-1]
+			switch escaped {
+			case 'd', 'D', 'w', 'W', 's', 'S':
+				members = append(members, match)
+				continue
+			}
+
+			lo, _ = escapedRune(escaped)
+		} else {
+			lo = r
+			p.pos++
+		}
+
+		hi := lo
+		if p.pos+1 < len(p.pattern) && p.pattern[p.pos] == '-' && p.pattern[p.pos+1] != ']' {
+			p.pos++
+
+			hi = p.pattern[p.pos]
+			if hi == '\\' {
+				if _, err := p.parseEscape(); err != nil {
+					return nil, err
+				}
+
+				escaped := p.pattern[p.pos-1]
+				switch escaped {
+				case 'd', 'D', 'w', 'W', 's', 'S':
+					return nil, p.errorf("invalid character class range %c-\\%c", lo, escaped)
+				}
+
+				hi, _ = escapedRune(escaped)
+			} else {
+				p.pos++
+			}
+
+			if hi < lo {
+				return nil, p.errorf("invalid character class range %c-%c", lo, hi)
+			}
+		}
+
+		members = append(members, func(r rune) bool {
+			return r >= lo && r <= hi
+		})
+	}
+
+	return &node{kind: nodeMatch, match: func(r rune) bool {
+		for _, member := range members {
+			if member(r) {
+				return !negated
+			}
+		}
+		return negated
+	}}, nil
+}
+
+// size returns the number of instructions emit takes for n, or a number
+// past maxProgramSize if there are more.
+func (n *node) size() int {
+	total := 0
+
+	switch n.kind {
+	case nodeMatch:
+		total = 1
+	case nodeConcat, nodeAlternate:
+		for _, child := range n.children {
+			total += child.size()
+		}
+		if n.kind == nodeAlternate {
+			// a split and a jump between alternatives
+			total += 2 * (len(n.children) - 1)
+		}
+	case nodeRepeat:
+		child := n.children[0].size()
+		if child > maxProgramSize {
+			return maxProgramSize + 1
+		}
+
+		total = n.min * child
+		if n.max < 0 {
+			total += child + 2
+		} else {
+			total += (n.max - n.min) * (child + 1)
+		}
+	}
+
+	if total > maxProgramSize {
+		return maxProgramSize + 1
+	}
+
+	return total
+}
+
+func (prog *program) emit(n *node) {
+	switch n.kind {
+	case nodeMatch:
+		*prog = append(*prog, instruction{op: opRune, match: n.match})
+	case nodeConcat:
+		for _, child := range n.children {
+			prog.emit(child)
+		}
+	case nodeAlternate:
+		var jumps []int
+
+		for i, child := range n.children {
+			if i == len(n.children)-1 {
+				prog.emit(child)
+				break
+			}
+
+			split := len(*prog)
+			*prog = append(*prog, instruction{op: opSplit, x: split + 1})
+
+			prog.emit(child)
+
+			jumps = append(jumps, len(*prog))
+			*prog = append(*prog, instruction{op: opJump})
+
+			(*prog)[split].y = len(*prog)
+		}
+
+		for _, jump := range jumps {
+			(*prog)[jump].x = len(*prog)
+		}
+	case nodeRepeat:
+		child := n.children[0]
+
+		for i := 0; i < n.min; i++ {
+			prog.emit(child)
+		}
+
+		if n.max < 0 {
+			split := len(*prog)
+			*prog = append(*prog, instruction{op: opSplit, x: split + 1})
+
+			prog.emit(child)
+			*prog = append(*prog, instruction{op: opJump, x: split})
+
+			(*prog)[split].y = len(*prog)
+			return
+		}
+
+		var splits []int
+		for i := n.min; i < n.max; i++ {
+			splits = append(splits, len(*prog))
+			*prog = append(*prog, instruction{op: opSplit, x: len(*prog) + 1})
+
+			prog.emit(child)
+		}
+
+		for _, split := range splits {
+			(*prog)[split].y = len(*prog)
+		}
+	}
+}
+
+func (prog program) rule(r rune) (textlexer.Rule, textlexer.State) {
+	m := &machine{prog: prog}
+	m.threads = m.add(nil, 0, make([]bool, len(prog)))
+
+	return m.next(r)
+}
+
+type machine struct {
+	prog program
+
+	threads  []int
+	consumed int
+	accepted int
+}
+
+func (m *machine) add(threads []int, pc int, seen []bool) []int {
+	if seen[pc] {
+		return threads
+	}
+	seen[pc] = true
+
+	switch m.prog[pc].op {
+	case opJump:
+		return m.add(threads, m.prog[pc].x, seen)
+	case opSplit:
+		threads = m.add(threads, m.prog[pc].x, seen)
+		return m.add(threads, m.prog[pc].y, seen)
+	}
+
+	return append(threads, pc)
+}
+
+func (m *machine) next(r rune) (textlexer.Rule, textlexer.State) {
+	var threads []int
+
+	if !textlexer.IsEOF(r) {
+		seen := make([]bool, len(m.prog))
+		for _, pc := range m.threads {
+			if m.prog[pc].op == opRune && m.prog[pc].match(r) {
+				threads = m.add(threads, pc+1, seen)
+			}
+		}
+	}
+
+	if len(threads) == 0 {
+		if m.accepted == 0 {
+			return nil, textlexer.StateReject
+		}
+
+		// go back to the end of the longest match
+		return pushBack(m.consumed-m.accepted, Accept)(r)
+	}
+
+	m.threads = threads
+	m.consumed++
+
+	for _, pc := range threads {
+		if m.prog[pc].op == opMatch {
+			m.accepted = m.consumed
+			break
+		}
+	}
+
+	return m.next, textlexer.StateContinue
+}
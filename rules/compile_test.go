@@ -0,0 +1,241 @@
+package rules_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xiam/textlexer"
+	"github.com/xiam/textlexer/rules"
+)
+
+func TestCompile(t *testing.T) {
+	patterns := []string{
+		`a`,
+		`abc`,
+		`a|b`,
+		`ab|abcd`,
+		`abcd|ab`,
+		`a*`,
+		`a+`,
+		`a?b`,
+		`a*a`,
+		`a*ab`,
+		`(ab)+`,
+		`(a|b)*c`,
+		`(a|ab)(c|bcd)`,
+		`a{2}`,
+		`a{2,}`,
+		`a{2,3}`,
+		`a{0,2}b`,
+		`(ab){1,2}a`,
+		`.`,
+		`.+`,
+		`[abc]+`,
+		`[a-z]+`,
+		`[^a-z]+`,
+		`[a-z0-9_]+`,
+		`[-a]+`,
+		`[a-]+`,
+		`[]a]+`,
+		`[\]\\]+`,
+		`[\d.]+`,
+		`[\t-\r]+`,
+		`[ -\~]+`,
+		`\d+`,
+		`\d+\.\d+`,
+		`\w+`,
+		`\s+`,
+		`\S+`,
+		`\W`,
+		`\D+`,
+		`\+\*\?`,
+		`[a-zA-Z_][a-zA-Z0-9_]*`,
+		`-?[0-9]+(\.[0-9]+)?`,
+		`0x[0-9a-fA-F]+|[0-9]+`,
+		`(a*)*b`,
+		`(a|)+b`,
+		`a|`,
+		`x*`,
+		`é+ñ?`,
+		`[α-ω]+`,
+		`a{,2}`,
+		`a{x}`,
+		`"[^"]*"`,
+		`/\*.*\*/`,
+	}
+
+	inputs := []string{
+		"",
+		"a",
+		"b",
+		"ab",
+		"abc",
+		"abcd",
+		"abcde",
+		"aaa",
+		"aaab",
+		"aab",
+		"ababab",
+		"ababa",
+		"abbcd",
+		"bbac",
+		"abcab",
+		"xyz",
+		"XYZ_123 abc",
+		"123",
+		"12.5",
+		"12.",
+		"-12.5x",
+		"0x1F",
+		"0xZZ",
+		"-",
+		"]]a\\",
+		"a-a-",
+		" \t\nx",
+		"\n",
+		"+*?",
+		"ééñ",
+		"αβγ",
+		"a{,2}",
+		"a{x}",
+		`"quoted" tail`,
+		`"unterminated`,
+		"/* comment */ x",
+		"/* comment",
+	}
+
+	for _, pattern := range patterns {
+		rule, err := rules.Compile(pattern)
+		require.NoError(t, err, "pattern: %q", pattern)
+
+		re := regexp.MustCompile(`^(?:` + pattern + `)`)
+		re.Longest()
+
+		for _, input := range inputs {
+			expected := re.FindString(input)
+
+			match, ok := matchLongest(t, rule, input)
+			if expected == "" {
+				assert.False(t, ok, "pattern: %q, input: %q, got: %q", pattern, input, match)
+				continue
+			}
+
+			if assert.True(t, ok, "pattern: %q, input: %q, expected: %q", pattern, input, expected) {
+				assert.Equal(t, expected, match, "pattern: %q, input: %q", pattern, input)
+			}
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	patterns := []string{
+		`(`,
+		`(a`,
+		`a)`,
+		`[a`,
+		`[`,
+		`*a`,
+		`+`,
+		`a**`,
+		`a*?`,
+		`a|*`,
+		`^a`,
+		`a$`,
+		`\b`,
+		`\1`,
+		`\p{L}`,
+		`a\`,
+		`(?i)a`,
+		`[[:alpha:]]`,
+		`[z-a]`,
+		`a{3,2}`,
+		`a{1001}`,
+		`[a-\n]`,
+		`[a-\d]`,
+		`(a{1000}){1000}`,
+		`((a{1000}){1000}){1000}`,
+	}
+
+	for _, pattern := range patterns {
+		_, err := rules.Compile(pattern)
+		assert.Error(t, err, "pattern: %q", pattern)
+	}
+}
+
+func TestCompileWithLexer(t *testing.T) {
+	const (
+		lexTypeWhitespace = textlexer.LexemeType("WHITESPACE")
+		lexTypeIdentifier = textlexer.LexemeType("IDENTIFIER")
+		lexTypeNumber     = textlexer.LexemeType("NUMBER")
+		lexTypeOperator   = textlexer.LexemeType("OPERATOR")
+	)
+
+	in := `x1 = 3.14 + y_2 * 10.`
+
+	out := []struct {
+		Type textlexer.LexemeType
+		Text string
+	}{
+		{lexTypeIdentifier, "x1"},
+		{lexTypeWhitespace, " "},
+		{lexTypeOperator, "="},
+		{lexTypeWhitespace, " "},
+		{lexTypeNumber, "3.14"},
+		{lexTypeWhitespace, " "},
+		{lexTypeOperator, "+"},
+		{lexTypeWhitespace, " "},
+		{lexTypeIdentifier, "y_2"},
+		{lexTypeWhitespace, " "},
+		{lexTypeOperator, "*"},
+		{lexTypeWhitespace, " "},
+		{lexTypeNumber, "10"},
+		{textlexer.LexemeTypeUnknown, "."},
+	}
+
+	lx := textlexer.New(strings.NewReader(in))
+
+	lx.MustAddRule(lexTypeWhitespace, rules.MustCompile(`\s+`))
+	lx.MustAddRule(lexTypeIdentifier, rules.MustCompile(`[a-zA-Z_][a-zA-Z0-9_]*`))
+	lx.MustAddRule(lexTypeNumber, rules.MustCompile(`[0-9]+(\.[0-9]+)?`))
+	lx.MustAddRule(lexTypeOperator, rules.MustCompile(`[=+*/-]`))
+
+	for _, expected := range out {
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, expected.Type, lex.Type)
+		assert.Equal(t, expected.Text, lex.Text())
+	}
+}
+
+// matchLongest feeds input to rule and returns the match it accepts, taking
+// push backs into account.
+func matchLongest(t *testing.T, rule textlexer.Rule, input string) (string, bool) {
+	runes := append([]rune(input), textlexer.RuneEOF)
+
+	consumed, pushBacks := 0, 0
+	for _, r := range runes {
+		next, state := rule(r)
+		for state == textlexer.StatePushBack {
+			pushBacks++
+			require.True(t, pushBacks <= consumed, "Pushed back more runes than consumed.")
+			next, state = next(r)
+		}
+
+		switch state {
+		case textlexer.StateAccept:
+			return string(runes[:consumed-pushBacks]), true
+		case textlexer.StateReject:
+			return "", false
+		}
+
+		rule = next
+		consumed++
+	}
+
+	return "", false
+}
@@ -0,0 +1,81 @@
+package rules
+
+import (
+	"github.com/xiam/textlexer"
+)
+
+var (
+	timeFraction = Compose(Period, newCharacterClassMatcher(isNumeric, 1, -1))
+
+	timeZoneOffset = Compose(
+		NewMatchAnyOf(Plus, Minus),
+		newFixedDigitsMatcher(2, 0, 23),
+		Colon,
+		newFixedDigitsMatcher(2, 0, 59),
+	)
+
+	timeZone = NewMatchAnyOf(NewLiteralMatch("Z"), timeZoneOffset)
+)
+
+// DateISO matches calendar dates in the YYYY-MM-DD format. Months must be in
+// the 1-12 range and days in the 1-31 range.
+func DateISO(r rune) (textlexer.Rule, textlexer.State) {
+	return Compose(
+		newFixedDigitsMatcher(4, 0, 9999),
+		Minus,
+		newFixedDigitsMatcher(2, 1, 12),
+		Minus,
+		newFixedDigitsMatcher(2, 1, 31),
+	)(r)
+}
+
+// Time matches times of the day in the HH:MM:SS format, optionally followed
+// by fractional seconds and a "Z" or "+HH:MM" time zone.
+func Time(r rune) (textlexer.Rule, textlexer.State) {
+	return Compose(
+		newFixedDigitsMatcher(2, 0, 23),
+		Colon,
+		newFixedDigitsMatcher(2, 0, 59),
+		Colon,
+		// 60 is a leap second
+		newFixedDigitsMatcher(2, 0, 60),
+		Optional(timeFraction),
+		Optional(timeZone),
+	)(r)
+}
+
+// DateTimeISO matches a DateISO and a Time separated by "T".
+func DateTimeISO(r rune) (textlexer.Rule, textlexer.State) {
+	return Compose(DateISO, NewLiteralMatch("T"), Time)(r)
+}
+
+// newFixedDigitsMatcher matches exactly n decimal digits whose value is
+// between min and max.
+func newFixedDigitsMatcher(n, min, max int) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		var nextDigit textlexer.Rule
+
+		count, value := 0, 0
+
+		nextDigit = func(r rune) (textlexer.Rule, textlexer.State) {
+			if isNumeric(r) {
+				if count == n {
+					return nil, textlexer.StateReject
+				}
+
+				count++
+				value = value*10 + int(r-'0')
+
+				return nextDigit, textlexer.StateContinue
+			}
+
+			if count < n || value < min || value > max {
+				return nil, textlexer.StateReject
+			}
+
+			return nil, textlexer.StateAccept
+		}
+
+		return nextDigit(r)
+	}
+}
@@ -0,0 +1,166 @@
+package rules_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xiam/textlexer"
+	"github.com/xiam/textlexer/rules"
+)
+
+func TestDateISO(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"2023-01-31",
+			[]string{"2023-01-31"},
+		},
+		{
+			"on 1999-12-01, then 2000-02-29.",
+			[]string{"1999-12-01", "2000-02-29"},
+		},
+		{
+			"2023-13-45",
+			nil,
+		},
+		{
+			"2023-00-10",
+			nil,
+		},
+		{
+			"2023-01-00",
+			nil,
+		},
+		{
+			"2023-1-10",
+			nil,
+		},
+		{
+			"2023-01-101",
+			nil,
+		},
+		{
+			"2023",
+			nil,
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.DateISO)
+}
+
+func TestTime(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"00:00:00",
+			[]string{"00:00:00"},
+		},
+		{
+			"23:59:60",
+			[]string{"23:59:60"},
+		},
+		{
+			"12:34:56.789",
+			[]string{"12:34:56.789"},
+		},
+		{
+			"12:34:56Z",
+			[]string{"12:34:56Z"},
+		},
+		{
+			"12:34:56.5-03:30 ok",
+			[]string{"12:34:56.5-03:30"},
+		},
+		{
+			"12:34:56+05",
+			[]string{"12:34:56"},
+		},
+		{
+			"12:34:56.",
+			[]string{"12:34:56"},
+		},
+		{
+			"24:00:00",
+			nil,
+		},
+		{
+			"12:60:00",
+			nil,
+		},
+		{
+			"12:34",
+			nil,
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.Time)
+}
+
+func TestDateTimeISO(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"2023-06-01T12:00:00Z",
+			[]string{"2023-06-01T12:00:00Z"},
+		},
+		{
+			"at 2023-06-01T12:00:00.123+02:00 done",
+			[]string{"2023-06-01T12:00:00.123+02:00"},
+		},
+		{
+			"2023-06-01 12:00:00",
+			nil,
+		},
+		{
+			"2023-13-01T12:00:00",
+			nil,
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.DateTimeISO)
+}
+
+func TestDateTimeAndInteger(t *testing.T) {
+	lx := textlexer.NewFromString("2023 2023-06-01 2023-13-45 2023-06-01T08:15:00Z", textlexer.WithSkipLeadingWhitespace())
+
+	lx.MustAddRule("INT", rules.UnsignedInteger)
+	lx.MustAddRule("MINUS", rules.Minus)
+	lx.MustAddRule("DATE", rules.DateISO)
+	lx.MustAddRule("DATETIME", rules.DateTimeISO)
+
+	var out []string
+	for {
+		lex, err := lx.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		out = append(out, lex.String())
+	}
+
+	expected := []string{
+		`INT("2023")@0+4`,
+		`DATE("2023-06-01")@5+10`,
+		`INT("2023")@16+4`,
+		`MINUS("-")@20+1`,
+		`INT("13")@21+2`,
+		`MINUS("-")@23+1`,
+		`INT("45")@24+2`,
+		`DATETIME("2023-06-01T08:15:00Z")@27+20`,
+	}
+
+	assert.Equal(t, expected, out)
+}
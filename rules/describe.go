@@ -0,0 +1,155 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xiam/textlexer"
+)
+
+const (
+	// maxDescribeDepth is how many runes Describe follows from the start, so
+	// that rules that loop are not expanded forever.
+	maxDescribeDepth = 8
+
+	// maxDescribeStates is how many states Describe draws at most, so that
+	// large alphabets don't make the graph grow out of hand.
+	maxDescribeStates = 256
+)
+
+// describedState is a state reached by Describe, along with the runes that
+// lead to it and the states it went through.
+type describedState struct {
+	path []rune
+	ids  []int
+}
+
+// Describe returns a Graphviz DOT graph of the transitions rule makes when
+// driven with the runes of alphabet, for debugging. Rules are closures, so
+// their states can't be compared: runs of runes that lead to states that act
+// the same on the next two runes are drawn as one state, which makes loops show
+// up as such, though a rule that repeats itself further ahead, such as a
+// literal "aaaa", may be drawn with loops it does not have. Transitions are
+// followed up to a fixed depth and number of states, states left unexplored are
+// dashed. States where a match ends are drawn with a double circle, runes that
+// are rejected are left out.
+func Describe(rule textlexer.Rule, alphabet []rune) string {
+	var edges []string
+
+	accepting := map[int]bool{}
+	truncated := map[int]bool{}
+
+	probes := append(append([]rune(nil), alphabet...), textlexer.RuneEOF)
+
+	// states already drawn, by what they do with the next runes, the start
+	// is left out as nothing can match before it
+	seen := map[string]int{}
+
+	count := 1
+	queue := []describedState{{ids: []int{0}}}
+
+	for len(queue) > 0 {
+		st := queue[0]
+		queue = queue[1:]
+
+		id := st.ids[len(st.ids)-1]
+
+		for _, r := range probes {
+			state, pushed := probe(rule, st.path, r)
+
+			switch state {
+			case textlexer.StateAccept:
+				// the match ends before r and the runes given back
+				if end := len(st.path) - pushed; end > 0 {
+					accepting[st.ids[end]] = true
+				}
+			case textlexer.StateContinue:
+				if textlexer.IsEOF(r) {
+					continue
+				}
+				if len(st.path) >= maxDescribeDepth {
+					truncated[id] = true
+					continue
+				}
+
+				path := append(st.path[:len(st.path):len(st.path)], r)
+
+				sig := signature(rule, path, probes)
+				if to, ok := seen[sig]; ok {
+					edges = append(edges, fmt.Sprintf("\ts%d -> s%d [label=%q];\n", id, to, string(r)))
+					continue
+				}
+				if count >= maxDescribeStates {
+					truncated[id] = true
+					continue
+				}
+				seen[sig] = count
+
+				edges = append(edges, fmt.Sprintf("\ts%d -> s%d [label=%q];\n", id, count, string(r)))
+				queue = append(queue, describedState{
+					path: path,
+					ids:  append(st.ids[:len(st.ids):len(st.ids)], count),
+				})
+				count++
+			}
+		}
+	}
+
+	var b strings.Builder
+
+	b.WriteString("digraph rule {\n\trankdir=LR;\n")
+	for id := 0; id < count; id++ {
+		shape := "circle"
+		if accepting[id] {
+			shape = "doublecircle"
+		}
+
+		style := ""
+		if truncated[id] {
+			style = ", style=dashed"
+		}
+
+		fmt.Fprintf(&b, "\ts%d [shape=%s%s];\n", id, shape, style)
+	}
+	for _, edge := range edges {
+		b.WriteString(edge)
+	}
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// signature tells what rule does with each of probes, and with each pair of
+// them, after path.
+func signature(rule textlexer.Rule, path []rune, probes []rune) string {
+	var b strings.Builder
+
+	for _, r := range probes {
+		state, pushed := probe(rule, path, r)
+		fmt.Fprintf(&b, "%v/%d", state, pushed)
+
+		if state == textlexer.StateContinue && !textlexer.IsEOF(r) {
+			next := append(path[:len(path):len(path)], r)
+			for _, r := range probes {
+				state, pushed := probe(rule, next, r)
+				fmt.Fprintf(&b, " %v/%d", state, pushed)
+			}
+		}
+		b.WriteByte(';')
+	}
+
+	return b.String()
+}
+
+// probe drives a fresh run of rule with path, which it is known to continue
+// on, and then with r. It returns what rule did with r and how many runes it
+// gave back.
+func probe(rule textlexer.Rule, path []rune, r rune) (textlexer.State, int) {
+	next := rule
+	for _, p := range path {
+		next, _, _ = step(next, p)
+	}
+
+	_, state, pushed := step(next, r)
+	return state, pushed
+}
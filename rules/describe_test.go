@@ -0,0 +1,54 @@
+package rules_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xiam/textlexer/rules"
+)
+
+func TestDescribe(t *testing.T) {
+	t.Run("chain", func(t *testing.T) {
+		dot := rules.Describe(rules.NewLiteralMatch("ab"), []rune("ab"))
+
+		expected := "digraph rule {\n" +
+			"\trankdir=LR;\n" +
+			"\ts0 [shape=circle];\n" +
+			"\ts1 [shape=circle];\n" +
+			"\ts2 [shape=doublecircle];\n" +
+			"\ts0 -> s1 [label=\"a\"];\n" +
+			"\ts1 -> s2 [label=\"b\"];\n" +
+			"}\n"
+		assert.Equal(t, expected, dot)
+	})
+
+	t.Run("loop", func(t *testing.T) {
+		dot := rules.Describe(rules.UnsignedInteger, []rune("1a"))
+
+		expected := "digraph rule {\n" +
+			"\trankdir=LR;\n" +
+			"\ts0 [shape=circle];\n" +
+			"\ts1 [shape=doublecircle];\n" +
+			"\ts0 -> s1 [label=\"1\"];\n" +
+			"\ts1 -> s1 [label=\"1\"];\n" +
+			"}\n"
+		assert.Equal(t, expected, dot)
+	})
+
+	t.Run("repeated chain", func(t *testing.T) {
+		dot := rules.Describe(rules.NewLiteralMatch("abab"), []rune("ab"))
+
+		assert.Equal(t, 4, strings.Count(dot, "->"))
+		assert.Contains(t, dot, "s4 [shape=doublecircle];")
+	})
+
+	t.Run("large alphabet", func(t *testing.T) {
+		dot := rules.Describe(rules.Word, []rune("abcdefghijklmnopqrstuvwxyz0123456789 "))
+
+		assert.Equal(t, 2, strings.Count(dot, "shape="))
+		assert.Contains(t, dot, "s1 [shape=doublecircle];")
+		assert.Equal(t, 26+36, strings.Count(dot, "->"))
+	})
+}
@@ -0,0 +1,91 @@
+package rules
+
+import (
+	"github.com/xiam/textlexer"
+)
+
+func isBase64Char(r rune) bool {
+	return isLetter(r) || isNumeric(r) || r == '+' || r == '/'
+}
+
+// Base64 matches standard base64 text of at least minLen runes, padding
+// included. Padding, when present, must make the length a multiple of 4.
+// Unpadded text is matched unless its length is one more than a multiple of
+// 4, which no encoding produces. Text that is not valid is not matched.
+func Base64(minLen int) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		var nextChar, nextPad textlexer.Rule
+
+		n, padding := 0, 0
+
+		end := func() (textlexer.Rule, textlexer.State) {
+			valid := n%4 != 1
+			if padding > 0 {
+				valid = n%4 == 0
+			}
+
+			if !valid || n < minLen {
+				return nil, textlexer.StateReject
+			}
+
+			return nil, textlexer.StateAccept
+		}
+
+		nextPad = func(r rune) (textlexer.Rule, textlexer.State) {
+			if r == '=' && padding < 2 {
+				n++
+				padding++
+				return nextPad, textlexer.StateContinue
+			}
+
+			return end()
+		}
+
+		nextChar = func(r rune) (textlexer.Rule, textlexer.State) {
+			if isBase64Char(r) {
+				n++
+				return nextChar, textlexer.StateContinue
+			}
+
+			if r == '=' {
+				return nextPad(r)
+			}
+
+			return end()
+		}
+
+		if !isBase64Char(r) {
+			return nil, textlexer.StateReject
+		}
+
+		return nextChar(r)
+	}
+}
+
+// HexBlob matches an even number of hexadecimal digits, as in hex dumps of
+// binary data. A run with an odd number of digits is not matched, as it does
+// not make up whole bytes.
+func HexBlob(r rune) (textlexer.Rule, textlexer.State) {
+	var nextDigit textlexer.Rule
+
+	n := 0
+
+	nextDigit = func(r rune) (textlexer.Rule, textlexer.State) {
+		if isHexDigit(r) {
+			n++
+			return nextDigit, textlexer.StateContinue
+		}
+
+		if n%2 != 0 {
+			return nil, textlexer.StateReject
+		}
+
+		return nil, textlexer.StateAccept
+	}
+
+	if !isHexDigit(r) {
+		return nil, textlexer.StateReject
+	}
+
+	return nextDigit(r)
+}
@@ -0,0 +1,39 @@
+package rules_test
+
+import (
+	"testing"
+
+	"github.com/xiam/textlexer/rules"
+)
+
+func TestBase64(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{"", nil},
+		{"SGVsbG8=", []string{"SGVsbG8="}},
+		{"SGVsbA==", []string{"SGVsbA=="}},
+		{"SGVsbG8", []string{"SGVsbG8"}},
+		{"a+b/", []string{"a+b/"}},
+		{"data: SGVsbG8sIHdvcmxk!", []string{"data", "SGVsbG8sIHdvcmxk"}},
+		{"SGVsb", nil},
+		{"SGVsbG=", nil},
+		{"SGVsbA===", []string{"SGVsbA=="}},
+		{"abc", nil},
+		{"=abc", nil},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.Base64(4))
+}
+
+func TestHexBlob(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{"", nil},
+		{"00", []string{"00"}},
+		{"deadBEEF", []string{"deadBEEF"}},
+		{"0a1b2c 3", []string{"0a1b2c"}},
+		{"abc", nil},
+		{"fg", nil},
+		{"12 345 6789", []string{"12", "6789"}},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.HexBlob)
+}
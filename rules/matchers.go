@@ -5,13 +5,17 @@ import (
 )
 
 func isSpace(r rune) bool {
-	switch byte(r) {
+	switch r {
 	case ' ', '\t', '\r', '\n', '\f':
 		return true
 	}
 	return false
 }
 
+func isHorizontalSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\f'
+}
+
 func toLower(r rune) rune {
 	return unicode.ToLower(r)
 }
@@ -26,9 +30,46 @@ func isLetter(r rune) bool {
 	return false
 }
 
+// isWordRune reports the runes that make up words, as \w in regular
+// expressions.
+func isWordRune(r rune) bool {
+	return r == '_' || IsLetterOrDigit(r)
+}
+
+func isPunctuation(r rune) bool {
+	return r >= 0 && unicode.IsPunct(r)
+}
+
 func isNumeric(r rune) bool {
 	if r >= '0' && r <= '9' {
 		return true
 	}
 	return false
 }
+
+func isEOL(r rune) bool {
+	return r == '\n' || r == '\r'
+}
+
+func isWordChar(r rune) bool {
+	return isLetter(r) || isNumeric(r) || r == '_'
+}
+
+func negate(match func(r rune) bool) func(r rune) bool {
+	return func(r rune) bool {
+		return !match(r)
+	}
+}
+
+func isHexDigit(r rune) bool {
+	if isNumeric(r) {
+		return true
+	}
+	if r >= 'a' && r <= 'f' {
+		return true
+	}
+	if r >= 'A' && r <= 'F' {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package rules
+
+import (
+	"strings"
+
+	"github.com/xiam/textlexer"
+)
+
+const (
+	emailAtomChars = "!#$%&'*+/=?^_`{|}~-"
+	urlSchemeChars = "+.-"
+
+	// length of "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"
+	maxIPv6Len = 39
+)
+
+var (
+	hostnameLabel = newCharacterClassMatcher(isHostnameChar, 1, -1)
+
+	hostname = SeparatedBy(hostnameLabel, Period, false)
+
+	domainName = Compose(
+		hostnameLabel,
+		Repeat(Compose(Period, hostnameLabel), 1, -1),
+	)
+
+	emailLocalPart = SeparatedBy(
+		newCharacterClassMatcher(isEmailAtomChar, 1, -1),
+		Period,
+		false,
+	)
+
+	urlScheme = Compose(
+		newCharacterClassMatcher(isLetter, 1, 1),
+		Optional(newCharacterClassMatcher(isURLSchemeChar, 1, -1)),
+	)
+
+	// path, query and fragment, none of them can end with punctuation that
+	// usually follows a URL in a sentence
+	urlTail = MustCompile(
+		`(/([-A-Za-z0-9._~%!$&'*+,;=:@/]*[-A-Za-z0-9_~%$&*+=@/])?)?` +
+			`(\?[-A-Za-z0-9._~%!$&'*+,;=:@/?]*[-A-Za-z0-9_~%$&*+=@/?])?` +
+			`(#[-A-Za-z0-9._~%!$&'*+,;=:@/?]*[-A-Za-z0-9_~%$&*+=@/?])?`,
+	)
+)
+
+// Email matches the common forms of email addresses, like
+// "jane.doe+tag@mail.example.com". The domain must have at least two labels
+// and neither part may start or end with a dot.
+func Email(r rune) (textlexer.Rule, textlexer.State) {
+	return Compose(
+		emailLocalPart,
+		NewLiteralMatch("@"),
+		domainName,
+	)(r)
+}
+
+// URL matches URLs with a scheme and a host, like
+// "https://example.com:8080/path?q=1#top". Trailing punctuation, such as the
+// period at the end of a sentence, is not part of the match.
+func URL(r rune) (textlexer.Rule, textlexer.State) {
+	return Compose(
+		urlScheme,
+		NewLiteralMatch("://"),
+		hostname,
+		Optional(Compose(Colon, UnsignedInteger)),
+		Optional(urlTail),
+	)(r)
+}
+
+// IPv4 matches dotted decimal IPv4 addresses, like "192.168.0.1". Each octet
+// must be in the 0-255 range.
+func IPv4(r rune) (textlexer.Rule, textlexer.State) {
+	return Compose(
+		ipv4Octet,
+		Repeat(Compose(Period, ipv4Octet), 3, 3),
+	)(r)
+}
+
+// IPv6 matches IPv6 addresses made of hexadecimal groups, like "2001:db8::1".
+// The "::" compression may appear once. IPv4 suffixes and zones are not
+// supported.
+func IPv6(r rune) (textlexer.Rule, textlexer.State) {
+	var buf []rune
+	var next textlexer.Rule
+
+	// length of the longest valid address read so far
+	longest := 0
+
+	next = func(r rune) (textlexer.Rule, textlexer.State) {
+		if len(buf) < maxIPv6Len && (isHexDigit(r) || r == ':') {
+			buf = append(buf, r)
+			if isIPv6(string(buf)) {
+				longest = len(buf)
+			}
+			return next, textlexer.StateContinue
+		}
+
+		if longest == 0 {
+			return nil, textlexer.StateReject
+		}
+
+		return pushBack(len(buf)-longest, Accept)(r)
+	}
+
+	return next(r)
+}
+
+func ipv4Octet(r rune) (textlexer.Rule, textlexer.State) {
+	var next textlexer.Rule
+
+	digits, value := 0, 0
+
+	next = func(r rune) (textlexer.Rule, textlexer.State) {
+		if isNumeric(r) {
+			digits++
+			value = value*10 + int(r-'0')
+
+			if digits > 3 || value > 255 {
+				return nil, textlexer.StateReject
+			}
+
+			return next, textlexer.StateContinue
+		}
+
+		if digits == 0 {
+			return nil, textlexer.StateReject
+		}
+
+		return nil, textlexer.StateAccept
+	}
+
+	return next(r)
+}
+
+func isIPv6(s string) bool {
+	if strings.Contains(s, ":::") {
+		return false
+	}
+
+	head, tail, compressed := strings.Cut(s, "::")
+	if !compressed {
+		groups, ok := ipv6Groups(s)
+		return ok && groups == 8
+	}
+
+	if strings.Contains(tail, "::") {
+		return false
+	}
+
+	headGroups, ok := ipv6Groups(head)
+	if !ok {
+		return false
+	}
+
+	tailGroups, ok := ipv6Groups(tail)
+	if !ok {
+		return false
+	}
+
+	return headGroups+tailGroups < 8
+}
+
+// ipv6Groups counts the colon separated groups in s, an empty s has no groups.
+func ipv6Groups(s string) (int, bool) {
+	if s == "" {
+		return 0, true
+	}
+
+	groups := strings.Split(s, ":")
+	for _, group := range groups {
+		if len(group) < 1 || len(group) > 4 {
+			return 0, false
+		}
+	}
+
+	return len(groups), true
+}
+
+func isHostnameChar(r rune) bool {
+	return isLetter(r) || isNumeric(r) || r == '-'
+}
+
+func isEmailAtomChar(r rune) bool {
+	return isLetter(r) || isNumeric(r) || strings.ContainsRune(emailAtomChars, r)
+}
+
+func isURLSchemeChar(r rune) bool {
+	return isLetter(r) || isNumeric(r) || strings.ContainsRune(urlSchemeChars, r)
+}
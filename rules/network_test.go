@@ -0,0 +1,258 @@
+package rules_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xiam/textlexer"
+	"github.com/xiam/textlexer/rules"
+)
+
+func TestEmail(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"jane@example.com",
+			[]string{"jane@example.com"},
+		},
+		{
+			"jane.doe+tag@mail.example.com",
+			[]string{"jane.doe+tag@mail.example.com"},
+		},
+		{
+			"write to jane@example.com.",
+			[]string{"jane@example.com"},
+		},
+		{
+			"<jane@example.com>, joe@example.org",
+			[]string{"jane@example.com", "joe@example.org"},
+		},
+		{
+			"jane.@example.com",
+			nil,
+		},
+		{
+			".jane@example.com",
+			[]string{"jane@example.com"},
+		},
+		{
+			"jane@localhost",
+			nil,
+		},
+		{
+			"jane@",
+			nil,
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.Email)
+}
+
+func TestURL(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"http://x.com",
+			[]string{"http://x.com"},
+		},
+		{
+			"visit http://x.com.",
+			[]string{"http://x.com"},
+		},
+		{
+			"https://example.com:8080/a/b.html?q=1&r=2#top",
+			[]string{"https://example.com:8080/a/b.html?q=1&r=2#top"},
+		},
+		{
+			"see https://example.com/docs/, or not",
+			[]string{"https://example.com/docs/"},
+		},
+		{
+			"(https://example.com/a.b).",
+			[]string{"https://example.com/a.b"},
+		},
+		{
+			"is it http://localhost?",
+			[]string{"http://localhost"},
+		},
+		{
+			"svn+ssh://host/repo",
+			[]string{"svn+ssh://host/repo"},
+		},
+		{
+			"http:/x.com",
+			nil,
+		},
+		{
+			"://x.com",
+			nil,
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.URL)
+}
+
+func TestEmailAndURLInText(t *testing.T) {
+	lx := textlexer.NewFromString("Mail jane@example.com, or visit http://x.com/a.")
+
+	lx.MustAddRule("WORD", rules.Word)
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+	lx.MustAddRule("PUNCTUATION", rules.NewMatchAnyOf(rules.Comma, rules.Period))
+	lx.MustAddRule("EMAIL", rules.Email)
+	lx.MustAddRule("URL", rules.URL)
+
+	var out []string
+	for {
+		lex, err := lx.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		if lex.Type != "WHITESPACE" {
+			out = append(out, lex.String())
+		}
+	}
+
+	expected := []string{
+		`WORD("Mail")@0+4`,
+		`EMAIL("jane@example.com")@5+16`,
+		`PUNCTUATION(",")@21+1`,
+		`WORD("or")@23+2`,
+		`WORD("visit")@26+5`,
+		`URL("http://x.com/a")@32+14`,
+		`PUNCTUATION(".")@46+1`,
+	}
+
+	assert.Equal(t, expected, out)
+}
+
+func TestIPv4(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"1.2.3.4",
+			[]string{"1.2.3.4"},
+		},
+		{
+			"192.168.0.255",
+			[]string{"192.168.0.255"},
+		},
+		{
+			"0.0.0.0 255.255.255.255",
+			[]string{"0.0.0.0", "255.255.255.255"},
+		},
+		{
+			"host 10.0.0.1.",
+			[]string{"10.0.0.1"},
+		},
+		{
+			"1.2.3.256",
+			nil,
+		},
+		{
+			"1.2.3",
+			nil,
+		},
+		{
+			"1..2.3.4",
+			nil,
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.IPv4)
+}
+
+func TestIPv6(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"2001:0db8:85a3:0000:0000:8a2e:0370:7334",
+			[]string{"2001:0db8:85a3:0000:0000:8a2e:0370:7334"},
+		},
+		{
+			"2001:db8::1",
+			[]string{"2001:db8::1"},
+		},
+		{
+			"::1",
+			[]string{"::1"},
+		},
+		{
+			"::",
+			[]string{"::"},
+		},
+		{
+			"fe80:: ",
+			[]string{"fe80::"},
+		},
+		{
+			"[2001:db8::ff00:42:8329]:80",
+			[]string{"2001:db8::ff00:42:8329"},
+		},
+		{
+			"1::2::3",
+			[]string{"1::2", "::3"},
+		},
+		{
+			"12345::1",
+			nil,
+		},
+		{
+			"1:2:3:4:5:6:7",
+			nil,
+		},
+		{
+			"12:34:56",
+			nil,
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.IPv6)
+}
+
+func TestIPv4AndFloat(t *testing.T) {
+	lx := textlexer.NewFromString("1.2.3.4 1.5 999.1.1.1")
+
+	lx.MustAddRule("FLOAT", rules.UnsignedFloat)
+	lx.MustAddRule("IPV4", rules.IPv4)
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+	var out []string
+	for {
+		lex, err := lx.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		if lex.Type != "WHITESPACE" {
+			out = append(out, lex.String())
+		}
+	}
+
+	expected := []string{
+		`IPV4("1.2.3.4")@0+7`,
+		`FLOAT("1.5")@8+3`,
+		`FLOAT("999.1")@12+5`,
+		`FLOAT(".1")@17+2`,
+		`FLOAT(".1")@19+2`,
+	}
+
+	assert.Equal(t, expected, out)
+}
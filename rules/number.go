@@ -0,0 +1,297 @@
+package rules
+
+import (
+	"strconv"
+
+	"github.com/xiam/textlexer"
+)
+
+func newDigitsMatcher(isDigit func(r rune) bool) func(r rune) (textlexer.Rule, textlexer.State) {
+	var nextDigit, afterSeparator textlexer.Rule
+
+	nextDigit = func(r rune) (textlexer.Rule, textlexer.State) {
+		if isDigit(r) {
+			return nextDigit, textlexer.StateContinue
+		}
+
+		if r == '_' {
+			return afterSeparator, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateAccept
+	}
+
+	afterSeparator = func(r rune) (textlexer.Rule, textlexer.State) {
+		// a separator must be followed by a digit
+		if isDigit(r) {
+			return nextDigit, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		// starts with a digit, never with a separator
+		if isDigit(r) {
+			return nextDigit, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+}
+
+func isOctalDigit(r rune) bool {
+	return r >= '0' && r <= '7'
+}
+
+func isBinaryDigit(r rune) bool {
+	return r == '0' || r == '1'
+}
+
+// DecimalDigits matches a run of decimal digits that may be grouped with
+// underscores, as in "1_000". An underscore must be between two digits.
+func DecimalDigits(r rune) (textlexer.Rule, textlexer.State) {
+	return newDigitsMatcher(isNumeric)(r)
+}
+
+// HexDigits works like DecimalDigits with hexadecimal digits, in any case.
+func HexDigits(r rune) (textlexer.Rule, textlexer.State) {
+	return newDigitsMatcher(isHexDigit)(r)
+}
+
+// OctalDigits works like DecimalDigits with the digits 0 to 7.
+func OctalDigits(r rune) (textlexer.Rule, textlexer.State) {
+	return newDigitsMatcher(isOctalDigit)(r)
+}
+
+// BinaryDigits works like DecimalDigits with the digits 0 and 1.
+func BinaryDigits(r rune) (textlexer.Rule, textlexer.State) {
+	return newDigitsMatcher(isBinaryDigit)(r)
+}
+
+// BoundedInteger returns a rule that matches a run of decimal digits whose
+// value is between min and max, both included. When min is negative the
+// digits can follow a minus sign, as in "-5". Values that do not fit in an
+// int64 are rejected.
+func BoundedInteger(min, max int64) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		var nextDigit textlexer.Rule
+		var digits []byte
+
+		nextDigit = func(r rune) (textlexer.Rule, textlexer.State) {
+			if isNumeric(r) {
+				digits = append(digits, byte(r))
+				return nextDigit, textlexer.StateContinue
+			}
+
+			value, err := strconv.ParseInt(string(digits), 10, 64)
+			if err != nil || value < min || value > max {
+				return nil, textlexer.StateReject
+			}
+
+			return nil, textlexer.StateAccept
+		}
+
+		if isNumeric(r) {
+			return nextDigit(r)
+		}
+
+		if r == '-' && min < 0 {
+			digits = append(digits, '-')
+
+			// the sign must be followed by a digit
+			return func(r rune) (textlexer.Rule, textlexer.State) {
+				if isNumeric(r) {
+					return nextDigit(r)
+				}
+
+				return nil, textlexer.StateReject
+			}, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+}
+
+// Sign matches a single "+" or "-".
+func Sign(r rune) (textlexer.Rule, textlexer.State) {
+	if r == '-' || r == '+' {
+		return Accept, textlexer.StateContinue
+	}
+
+	return nil, textlexer.StateReject
+}
+
+// Exponent matches the exponent of a decimal number, an "e" or "E" followed by
+// an optional sign and DecimalDigits, as in "e-3".
+func Exponent(r rune) (textlexer.Rule, textlexer.State) {
+	if r == 'e' || r == 'E' {
+		return Compose(Optional(Sign), DecimalDigits), textlexer.StateContinue
+	}
+
+	return nil, textlexer.StateReject
+}
+
+// HexInteger matches HexDigits after a "0x" or "0X" prefix, as in "0xFF".
+func HexInteger(r rune) (textlexer.Rule, textlexer.State) {
+	return Compose(NewCaseInsensitiveLiteralMatch("0x"), HexDigits)(r)
+}
+
+// OctalInteger matches OctalDigits after a "0o" or "0O" prefix, as in "0o755".
+func OctalInteger(r rune) (textlexer.Rule, textlexer.State) {
+	return Compose(NewCaseInsensitiveLiteralMatch("0o"), OctalDigits)(r)
+}
+
+// BinaryInteger matches BinaryDigits after a "0b" or "0B" prefix, as in
+// "0b1010".
+func BinaryInteger(r rune) (textlexer.Rule, textlexer.State) {
+	return Compose(NewCaseInsensitiveLiteralMatch("0b"), BinaryDigits)(r)
+}
+
+// DecimalNumber matches an unsigned decimal number with an optional
+// fractional part and an optional Exponent, as in "12", "1.5", "1.", ".5" or
+// "6.02e23".
+func DecimalNumber(r rune) (textlexer.Rule, textlexer.State) {
+	// an exponent marker must be followed by a valid exponent
+	optionalExponent := func(r rune) (textlexer.Rule, textlexer.State) {
+		if r == 'e' || r == 'E' {
+			return Exponent(r)
+		}
+
+		return nil, textlexer.StateAccept
+	}
+
+	if r == '.' {
+		// fractional part only, as in ".5"
+		return Compose(Period, DecimalDigits, optionalExponent)(r)
+	}
+
+	return Compose(
+		DecimalDigits,
+		Optional(Compose(Period, Optional(DecimalDigits))),
+		optionalExponent,
+	)(r)
+}
+
+// Number matches a number literal with an optional Sign: a DecimalNumber or
+// an integer with a "0x", "0o" or "0b" prefix, as in "-0x1F".
+func Number(r rune) (textlexer.Rule, textlexer.State) {
+	unsignedNumber := func(r rune) (textlexer.Rule, textlexer.State) {
+		if r != '0' {
+			return DecimalNumber(r)
+		}
+
+		return func(r rune) (textlexer.Rule, textlexer.State) {
+			switch r {
+			case 'x', 'X':
+				return HexDigits, textlexer.StateContinue
+			case 'o', 'O':
+				return OctalDigits, textlexer.StateContinue
+			case 'b', 'B':
+				return BinaryDigits, textlexer.StateContinue
+			}
+
+			// not a prefix, the leading zero is part of a decimal number
+			next, _ := DecimalNumber('0')
+			return next(r)
+		}, textlexer.StateContinue
+	}
+
+	return Compose(Optional(Sign), unsignedNumber)(r)
+}
+
+// LocaleNumber returns a rule that matches an unsigned decimal number written
+// with the given thousands and decimal separators, as in "1,234.56" with
+// LocaleNumber(',', '.') or "1.234,56" with LocaleNumber('.', ','). Digits
+// may be grouped by thousands, in which case the first group has one to three
+// digits and every other group exactly three, or not grouped at all. Numbers
+// with groups of any other size are rejected. A separator that is not
+// followed by a digit is not part of the match.
+func LocaleNumber(thousands, decimal rune) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		var leading, group, fraction textlexer.Rule
+
+		// digits in the current group
+		count := 0
+
+		afterDecimal := func(r rune) (textlexer.Rule, textlexer.State) {
+			if isNumeric(r) {
+				return fraction, textlexer.StateContinue
+			}
+
+			// not a decimal separator after all
+			return pushBack(1, Accept)(r)
+		}
+
+		afterThousands := func(r rune) (textlexer.Rule, textlexer.State) {
+			if isNumeric(r) {
+				count = 1
+				return group, textlexer.StateContinue
+			}
+
+			// not a thousands separator after all
+			return pushBack(1, Accept)(r)
+		}
+
+		leading = func(r rune) (textlexer.Rule, textlexer.State) {
+			switch {
+			case isNumeric(r):
+				count++
+				return leading, textlexer.StateContinue
+			case r == decimal:
+				return afterDecimal, textlexer.StateContinue
+			case r == thousands && count <= 3:
+				return afterThousands, textlexer.StateContinue
+			case r == thousands:
+				// a long run of digits can't be followed by a group
+				return func(r rune) (textlexer.Rule, textlexer.State) {
+					if isNumeric(r) {
+						return nil, textlexer.StateReject
+					}
+
+					return pushBack(1, Accept)(r)
+				}, textlexer.StateContinue
+			}
+
+			return nil, textlexer.StateAccept
+		}
+
+		group = func(r rune) (textlexer.Rule, textlexer.State) {
+			if isNumeric(r) {
+				count++
+				if count > 3 {
+					return nil, textlexer.StateReject
+				}
+
+				return group, textlexer.StateContinue
+			}
+
+			if count < 3 {
+				return nil, textlexer.StateReject
+			}
+
+			switch r {
+			case thousands:
+				return afterThousands, textlexer.StateContinue
+			case decimal:
+				return afterDecimal, textlexer.StateContinue
+			}
+
+			return nil, textlexer.StateAccept
+		}
+
+		fraction = func(r rune) (textlexer.Rule, textlexer.State) {
+			if isNumeric(r) {
+				return fraction, textlexer.StateContinue
+			}
+
+			return nil, textlexer.StateAccept
+		}
+
+		if isNumeric(r) {
+			return leading(r)
+		}
+
+		return nil, textlexer.StateReject
+	}
+}
@@ -0,0 +1,263 @@
+package rules_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/xiam/textlexer/rules"
+)
+
+func TestNumber(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"0",
+			[]string{"0"},
+		},
+		{
+			"123",
+			[]string{"123"},
+		},
+		{
+			"007",
+			[]string{"007"},
+		},
+		{
+			"1_000",
+			[]string{"1_000"},
+		},
+		{
+			"1_000_000",
+			[]string{"1_000_000"},
+		},
+		{
+			"1.5",
+			[]string{"1.5"},
+		},
+		{
+			"1.5e-10",
+			[]string{"1.5e-10"},
+		},
+		{
+			"1E+5",
+			[]string{"1E+5"},
+		},
+		{
+			"2e8",
+			[]string{"2e8"},
+		},
+		{
+			"1_000.25e3",
+			[]string{"1_000.25e3"},
+		},
+		{
+			"1.e3",
+			[]string{"1.e3"},
+		},
+		{
+			".123",
+			[]string{".123"},
+		},
+		{
+			".5e1",
+			[]string{".5e1"},
+		},
+		{
+			"123.",
+			[]string{"123."},
+		},
+		{
+			"1.2.3",
+			[]string{"1.2", ".3"},
+		},
+		{
+			"-42",
+			[]string{"-42"},
+		},
+		{
+			"+3.14",
+			[]string{"+3.14"},
+		},
+		{
+			"0xFF",
+			[]string{"0xFF"},
+		},
+		{
+			"0Xdead_beef",
+			[]string{"0Xdead_beef"},
+		},
+		{
+			"0o17",
+			[]string{"0o17"},
+		},
+		{
+			"0b1010",
+			[]string{"0b1010"},
+		},
+		{
+			"0b12",
+			[]string{"0b1", "2"},
+		},
+		{
+			"-0x1F",
+			[]string{"-0x1F"},
+		},
+		{
+			"0.5",
+			[]string{"0.5"},
+		},
+		{
+			"0e1",
+			[]string{"0e1"},
+		},
+		{
+			"1, 2.5; 3e2",
+			[]string{"1", "2.5", "3e2"},
+		},
+		{
+			"_1",
+			[]string{"1"},
+		},
+		{
+			"1_",
+			nil,
+		},
+		{
+			"1__0",
+			[]string{"0"},
+		},
+		{
+			"1e",
+			nil,
+		},
+		{
+			"1e+",
+			nil,
+		},
+		{
+			"0x",
+			nil,
+		},
+		{
+			"0x_1",
+			[]string{"1"},
+		},
+		{
+			".",
+			nil,
+		},
+		{
+			"-",
+			nil,
+		},
+		{
+			"- 1",
+			[]string{"1"},
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.Number)
+}
+
+func TestBoundedInteger(t *testing.T) {
+	t.Run("byte", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"0", []string{"0"}},
+			{"255", []string{"255"}},
+			{"256", nil},
+			{"0255", []string{"0255"}},
+			{"10.0.0.300", []string{"10", "0", "0"}},
+			{"a1", []string{"1"}},
+			{"-1", []string{"1"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.BoundedInteger(0, 255))
+	})
+
+	t.Run("status codes", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"200", []string{"200"}},
+			{"99", nil},
+			{"600", nil},
+			{"599 404", []string{"599", "404"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.BoundedInteger(100, 599))
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"9223372036854775807", []string{"9223372036854775807"}},
+			{"9223372036854775808", nil},
+			{"99999999999999999999999", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.BoundedInteger(0, math.MaxInt64))
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"-5", []string{"-5"}},
+			{"-10 10", []string{"-10", "10"}},
+			{"-0", []string{"-0"}},
+			{"-11", nil},
+			{"11", nil},
+			{"-", nil},
+			{"--5", []string{"-5"}},
+			{"-9223372036854775808", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.BoundedInteger(-10, 10))
+	})
+
+	t.Run("min int64", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"-9223372036854775808", []string{"-9223372036854775808"}},
+			{"-9223372036854775809", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.BoundedInteger(math.MinInt64, 0))
+	})
+}
+
+func TestLocaleNumber(t *testing.T) {
+	t.Run("us", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"0", []string{"0"}},
+			{"1234", []string{"1234"}},
+			{"1,234", []string{"1,234"}},
+			{"1,234.56", []string{"1,234.56"}},
+			{"12,345,678.9", []string{"12,345,678.9"}},
+			{"1234.5", []string{"1234.5"}},
+			{"0.25", []string{"0.25"}},
+			{"1, 2, 3", []string{"1", "2", "3"}},
+			{"costs 1,000.", []string{"1,000"}},
+			{"1,23", nil},
+			{"1,2345", []string{"5"}},
+			{"1234,567", []string{"567"}},
+			{"1.234,56", []string{"1.234", "56"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.LocaleNumber(',', '.'))
+	})
+
+	t.Run("eu", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"1.234,56", []string{"1.234,56"}},
+			{"1.234.567", []string{"1.234.567"}},
+			{"1234,5", []string{"1234,5"}},
+			{"0,5", []string{"0,5"}},
+			{"100.", []string{"100"}},
+			{"1.23.456", []string{"456"}},
+			{"12.34", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.LocaleNumber('.', ','))
+	})
+}
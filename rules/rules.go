@@ -1,6 +1,9 @@
 package rules
 
 import (
+	"strings"
+	"unicode"
+
 	"github.com/xiam/textlexer"
 )
 
@@ -237,6 +240,22 @@ func NewCaseInsensitiveLiteralMatch(match string) func(r rune) (textlexer.Rule,
 	}
 }
 
+// KeywordPhrase matches the given words in order, separated by any amount of
+// white space, as a single match. It is meant for keywords made of several
+// words, such as "ORDER BY", which match "ORDER   BY" too. Words are matched
+// as they are given, see NewCaseInsensitiveLiteralMatch otherwise.
+func KeywordPhrase(words ...string) func(r rune) (textlexer.Rule, textlexer.State) {
+	var seq []func(r rune) (textlexer.Rule, textlexer.State)
+	for i, word := range words {
+		if i > 0 {
+			seq = append(seq, Whitespace)
+		}
+		seq = append(seq, NewLiteralMatch(word))
+	}
+
+	return Compose(seq...)
+}
+
 func UnsignedNumeric(r rune) (textlexer.Rule, textlexer.State) {
 	var expectInteger, scanInteger, expectDecimal, scanDecimal textlexer.Rule
 
@@ -377,12 +396,56 @@ func Whitespace(r rune) (textlexer.Rule, textlexer.State) {
 	return nil, textlexer.StateReject
 }
 
+// HorizontalWhitespace works like Whitespace but does not match line breaks.
+func HorizontalWhitespace(r rune) (textlexer.Rule, textlexer.State) {
+	return newCharacterClassMatcher(isHorizontalSpace, 1, -1)(r)
+}
+
+// Newline matches a single line break, "\n" or "\r\n", so that it can be a
+// lexeme of its own in line oriented grammars. Use it along with
+// HorizontalWhitespace rather than Whitespace, which would take the line
+// breaks too.
+func Newline(r rune) (textlexer.Rule, textlexer.State) {
+	if r == '\r' {
+		return func(r rune) (textlexer.Rule, textlexer.State) {
+			if r == '\n' {
+				return Accept, textlexer.StateContinue
+			}
+			return nil, textlexer.StateReject
+		}, textlexer.StateContinue
+	}
+
+	if r == '\n' {
+		return Accept, textlexer.StateContinue
+	}
+
+	return nil, textlexer.StateReject
+}
+
+// UnicodeWhitespace matches runs of any Unicode white space, including
+// non-breaking spaces.
+func UnicodeWhitespace(r rune) (textlexer.Rule, textlexer.State) {
+	return newCharacterClassMatcher(unicode.IsSpace, 1, -1)(r)
+}
+
+// Punctuation matches a single punctuation mark, in any script.
+func Punctuation(r rune) (textlexer.Rule, textlexer.State) {
+	return newCharacterClassMatcher(isPunctuation, 1, 1)(r)
+}
+
+// PunctuationRun matches a run of punctuation marks, like "!!!" or "...".
+func PunctuationRun(r rune) (textlexer.Rule, textlexer.State) {
+	return newCharacterClassMatcher(isPunctuation, 1, -1)(r)
+}
+
+// Word matches a letter followed by letters, digits and combining marks, in
+// any script.
 func Word(r rune) (next textlexer.Rule, state textlexer.State) {
 	var nextLetter textlexer.Rule
 
 	nextLetter = func(r rune) (textlexer.Rule, textlexer.State) {
-		// can be followed by more letters or digits
-		if isLetter(r) || isNumeric(r) {
+		// can be followed by more letters, digits or marks
+		if IsLetterOrDigit(r) || IsMark(r) {
 			return nextLetter, textlexer.StateContinue
 		}
 
@@ -391,7 +454,7 @@ func Word(r rune) (next textlexer.Rule, state textlexer.State) {
 	}
 
 	// starts with a letter
-	if isLetter(r) {
+	if IsLetter(r) {
 		return nextLetter, textlexer.StateContinue
 	}
 
@@ -474,10 +537,182 @@ func DoubleQuotedFormattedString(r rune) (textlexer.Rule, textlexer.State) {
 	return nil, textlexer.StateReject
 }
 
+// DoubledQuoteString matches a string between quotes in which a quote is
+// written twice rather than escaped with a backslash, as in SQL and CSV:
+//
+//	'it''s'
+//	"say ""hi"""
+//
+// A single quote ends the string.
+func DoubledQuoteString(quote rune) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		var nextChar, afterQuote textlexer.Rule
+
+		nextChar = func(r rune) (textlexer.Rule, textlexer.State) {
+			if textlexer.IsEOF(r) {
+				return nil, textlexer.StateReject
+			}
+
+			if r == quote {
+				return afterQuote, textlexer.StateContinue
+			}
+
+			return nextChar, textlexer.StateContinue
+		}
+
+		// a quote is either the first of a doubled quote or the end of the
+		// string
+		afterQuote = func(r rune) (textlexer.Rule, textlexer.State) {
+			if r == quote {
+				return nextChar, textlexer.StateContinue
+			}
+
+			return nil, textlexer.StateAccept
+		}
+
+		if r == quote {
+			return nextChar, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+}
+
+// EscapeSequence returns a rule that matches the escape rune followed by one
+// of the runes in named, or by 'u' and exactly hexDigits hex digits. named maps
+// each escape to the rune it stands for, as in 'n' to '\n'. A hexDigits of
+// zero disables the 'u' form.
+func EscapeSequence(escape rune, named map[rune]rune, hexDigits int) func(r rune) (textlexer.Rule, textlexer.State) {
+	hex := func(r rune) (textlexer.Rule, textlexer.State) {
+		var nextDigit func(count int) textlexer.Rule
+
+		nextDigit = func(count int) textlexer.Rule {
+			return func(r rune) (textlexer.Rule, textlexer.State) {
+				if !isHexDigit(r) {
+					return nil, textlexer.StateReject
+				}
+
+				if count+1 == hexDigits {
+					return Accept, textlexer.StateContinue
+				}
+
+				return nextDigit(count + 1), textlexer.StateContinue
+			}
+		}
+
+		return nextDigit(0)(r)
+	}
+
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		if r != escape {
+			return nil, textlexer.StateReject
+		}
+
+		return func(r rune) (textlexer.Rule, textlexer.State) {
+			if _, ok := named[r]; ok {
+				return Accept, textlexer.StateContinue
+			}
+
+			if r == 'u' && hexDigits > 0 {
+				return hex, textlexer.StateContinue
+			}
+
+			return nil, textlexer.StateReject
+		}, textlexer.StateContinue
+	}
+}
+
+// cEscapes are the named escapes of C character and string literals.
+var cEscapes = map[rune]rune{
+	'a':  '\a',
+	'b':  '\b',
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+	'v':  '\v',
+	'0':  0,
+	'\\': '\\',
+	'\'': '\'',
+	'"':  '"',
+}
+
+// CharLiteral matches a single character between single quotes, as in 'c',
+// '\n', '\x41' or '\u00e9'. It matches the same input as SingleQuotedString
+// when both are used, so it must be added after it or with a higher priority.
+func CharLiteral(r rune) (textlexer.Rule, textlexer.State) {
+	char := func(r rune) (textlexer.Rule, textlexer.State) {
+		if r == '\'' || r == '\\' || isEOL(r) || textlexer.IsEOF(r) {
+			return nil, textlexer.StateReject
+		}
+
+		return Accept, textlexer.StateContinue
+	}
+
+	return Compose(
+		NewSingleMatch('\''),
+		NewMatchAnyOf(
+			char,
+			EscapeSequence('\\', cEscapes, 4),
+			Compose(NewLiteralMatch(`\x`), newCharacterClassMatcher(isHexDigit, 2, 2)),
+		),
+		NewSingleMatch('\''),
+	)(r)
+}
+
 func InlineComment(r rune) (textlexer.Rule, textlexer.State) {
 	return NewChainAnyAfterLiteralMatch("//", UntilEOL)(r)
 }
 
+// CStyleComments matches both "//" line comments and "/* */" block comments.
+func CStyleComments(r rune) (textlexer.Rule, textlexer.State) {
+	return LongestOf(InlineComment, SlashStarComment)(r)
+}
+
+// HashComment matches a comment from "#" to the end of the line, as in shell
+// scripts, Python or YAML.
+func HashComment(r rune) (textlexer.Rule, textlexer.State) {
+	return NewChainAnyAfterLiteralMatch("#", UntilEOL)(r)
+}
+
+// SemicolonComment matches a comment from ";" to the end of the line, as in
+// Lisp, INI files or assembly.
+func SemicolonComment(r rune) (textlexer.Rule, textlexer.State) {
+	return NewChainAnyAfterLiteralMatch(";", UntilEOL)(r)
+}
+
+// SQLComment matches a comment from "--" to the end of the line.
+func SQLComment(r rune) (textlexer.Rule, textlexer.State) {
+	return NewChainAnyAfterLiteralMatch("--", UntilEOL)(r)
+}
+
+// LineContinuation matches escape right before a line break, along with the
+// line break, as the "\\" that joins lines in shell scripts. See also
+// textlexer.WithLineContinuation.
+func LineContinuation(escape rune) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		if r != escape {
+			return nil, textlexer.StateReject
+		}
+
+		return func(r rune) (textlexer.Rule, textlexer.State) {
+			switch r {
+			case '\n':
+				return Accept, textlexer.StateContinue
+			case '\r':
+				return func(r rune) (textlexer.Rule, textlexer.State) {
+					if r == '\n' {
+						return Accept, textlexer.StateContinue
+					}
+					return nil, textlexer.StateAccept
+				}, textlexer.StateContinue
+			}
+
+			return nil, textlexer.StateReject
+		}, textlexer.StateContinue
+	}
+}
+
 func UntilEOF(r rune) (textlexer.Rule, textlexer.State) {
 	return func(r rune) (textlexer.Rule, textlexer.State) {
 		if textlexer.IsEOF(r) {
@@ -510,6 +745,8 @@ func BasicMathOperator(r rune) (textlexer.Rule, textlexer.State) {
 	return nil, textlexer.StateReject
 }
 
+// Invert matches the runs of input where rule does not match. A run that goes
+// on until EOF is matched as a whole, including its last rune.
 func Invert(rule textlexer.Rule) func(r rune) (textlexer.Rule, textlexer.State) {
 	var contRejected func(textlexer.Rule) func(rune) (textlexer.Rule, textlexer.State)
 	var contContinued func(textlexer.Rule) func(rune) (textlexer.Rule, textlexer.State)
@@ -527,11 +764,8 @@ func Invert(rule textlexer.Rule) func(r rune) (textlexer.Rule, textlexer.State)
 				return contRejected(next), textlexer.StateContinue
 			}
 
-			if state == textlexer.StateContinue {
-				return nil, textlexer.StateAccept
-			}
-
-			panic("unexpected state")
+			// a match starts here, the run ends before it
+			return nil, textlexer.StateAccept
 		}
 	}
 
@@ -576,34 +810,67 @@ func Invert(rule textlexer.Rule) func(r rune) (textlexer.Rule, textlexer.State)
 }
 
 func Compose(rules ...func(r rune) (textlexer.Rule, textlexer.State)) func(r rune) (textlexer.Rule, textlexer.State) {
-	var match func(int, textlexer.Rule) func(r rune) (textlexer.Rule, textlexer.State)
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		s := &sequence{rules: rules}
+		return drive(s.feed)(r)
+	}
+}
 
-	match = func(offset int, rule textlexer.Rule) func(r rune) (textlexer.Rule, textlexer.State) {
-		return func(r rune) (textlexer.Rule, textlexer.State) {
+// ComposeWithBoundaries works like Compose and calls onMatch each time the
+// sequence matches, with the offset (relative to the start of the match) at
+// which each of the rules ended.
+func ComposeWithBoundaries(onMatch func(ends []int), rules ...func(r rune) (textlexer.Rule, textlexer.State)) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		s := &sequence{rules: rules, onMatch: onMatch}
+		return drive(s.feed)(r)
+	}
+}
 
-			if offset >= len(rules) {
-				return nil, textlexer.StateAccept
-			}
+// KeyValueRule matches a key, a separator and a value in a row, see KeyValue.
+type KeyValueRule struct {
+	key, sep, value textlexer.Rule
+}
 
-			if rule == nil {
-				rule = rules[offset]
-			}
+// KeyValue creates a rule for pairs such as "name = John". Use Match as the
+// rule and Split to tell its parts apart, as in:
+//
+//	kv := rules.KeyValue(rules.Word, rules.Equal, rules.Word)
+//	lx.AddSplitRule("PAIR", kv.Match, kv.Split, "KEY", "", "VALUE")
+func KeyValue(key, sep, value textlexer.Rule) *KeyValueRule {
+	return &KeyValueRule{key: key, sep: sep, value: value}
+}
 
-			next, state := rule(r)
+func (kv *KeyValueRule) Match(r rune) (textlexer.Rule, textlexer.State) {
+	return Compose(kv.key, kv.sep, kv.value)(r)
+}
 
-			if state == textlexer.StateReject {
-				return nil, textlexer.StateReject
-			}
+// Split returns the length of the key, the separator and the value in the
+// text of a match, or nil if text does not match.
+func (kv *KeyValueRule) Split(text []rune) []int {
+	var ends []int
 
-			if state == textlexer.StateAccept {
-				return match(offset+1, nil)(r)
-			}
+	current := ComposeWithBoundaries(func(e []int) {
+		ends = e
+	}, kv.key, kv.sep, kv.value)
 
-			return match(offset, next), state
+	for i := 0; i <= len(text); i++ {
+		r := rune(textlexer.RuneEOF)
+		if i < len(text) {
+			r = text[i]
 		}
+
+		next, state, _ := step(current, r)
+		if state != textlexer.StateContinue {
+			break
+		}
+		current = next
+	}
+
+	if len(ends) != 3 || ends[2] != len(text) {
+		return nil
 	}
 
-	return match(0, nil)
+	return []int{ends[0], ends[1] - ends[0], ends[2] - ends[1]}
 }
 
 func SlashStarComment(r rune) (textlexer.Rule, textlexer.State) {
@@ -616,6 +883,9 @@ func SlashStarComment(r rune) (textlexer.Rule, textlexer.State) {
 	)(r)
 }
 
+// NewMatchAnyOf runs all the rules side by side and matches as soon as any of
+// them accepts, rules that accept on the same rune are tried in the given
+// order. See LongestOf to match the longest match instead.
 func NewMatchAnyOf(rules ...textlexer.Rule) func(r rune) (textlexer.Rule, textlexer.State) {
 	var matchAnyOf func([]textlexer.Rule) textlexer.Rule
 
@@ -627,9 +897,12 @@ func NewMatchAnyOf(rules ...textlexer.Rule) func(r rune) (textlexer.Rule, textle
 			matched := []textlexer.Rule{}
 
 			for i := range rules {
-				next, state = rules[i](r)
+				var pushed int
+
+				// rules that push back runes before accepting are kept
+				next, state, pushed = step(rules[i], r)
 				if state == textlexer.StateAccept {
-					return nil, textlexer.StateAccept
+					return pushBack(pushed, Accept)(r)
 				}
 
 				if state == textlexer.StateContinue {
@@ -747,3 +1020,847 @@ func Ampersand(r rune) (textlexer.Rule, textlexer.State) {
 func QuestionMark(r rune) (textlexer.Rule, textlexer.State) {
 	return NewSingleMatch('?')(r)
 }
+
+// AtEndOfLine matches what rule matches only when it is followed by a line
+// break or by the end of the input. The line break is not part of the match.
+func AtEndOfLine(rule textlexer.Rule) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		var buf []rune
+
+		current := rule
+
+		return drive(func(r rune) (textlexer.State, int) {
+			next, state, pushed := step(current, r)
+
+			switch state {
+			case textlexer.StateContinue:
+				if next != nil {
+					current = next
+				}
+				buf = append(buf, r)
+				return textlexer.StateContinue, 0
+			case textlexer.StateAccept:
+				// the rune right after the match is used as lookahead without
+				// consuming it
+				lookahead := r
+
+				pending := unconsumed(buf, pushed)
+				if len(pending) > 0 {
+					lookahead = pending[0]
+				}
+
+				if isEOL(lookahead) || textlexer.IsEOF(lookahead) {
+					return textlexer.StateAccept, len(pending)
+				}
+			}
+
+			return textlexer.StateReject, 0
+		})(r)
+	}
+}
+
+// IdentifierFunc returns a rule that matches a rune accepted by isStart,
+// followed by any number of runes accepted by isContinue.
+func IdentifierFunc(isStart, isContinue func(rune) bool) func(r rune) (textlexer.Rule, textlexer.State) {
+	var nextChar textlexer.Rule
+
+	nextChar = func(r rune) (textlexer.Rule, textlexer.State) {
+		if isContinue(r) {
+			return nextChar, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateAccept
+	}
+
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		if isStart(r) {
+			return nextChar, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+}
+
+// NewNamespacedIdentifier returns a rule that matches a name or two names
+// joined by nsSep, as in "xml:lang" with ':'. Names start with a letter or an
+// underscore and go on with letters, digits, underscores, hyphens and
+// periods. A leading nsSep is matched, as in ":keyword", a trailing one is
+// not.
+func NewNamespacedIdentifier(nsSep rune) func(r rune) (textlexer.Rule, textlexer.State) {
+	separator := NewSingleMatch(nsSep)
+
+	name := func(r rune) (textlexer.Rule, textlexer.State) {
+		var nextChar textlexer.Rule
+
+		nextChar = func(r rune) (textlexer.Rule, textlexer.State) {
+			if r != nsSep && (isWordChar(r) || r == '-' || r == '.') {
+				return nextChar, textlexer.StateContinue
+			}
+
+			return nil, textlexer.StateAccept
+		}
+
+		// starts with a letter or an underscore
+		if isLetter(r) || r == '_' {
+			return nextChar, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+
+	return Compose(
+		// keyword form, as in ":keyword"
+		Optional(separator),
+		name,
+		Optional(Compose(separator, name)),
+	)
+}
+
+// NewTableCell returns a rule that matches the content of a table cell, from
+// after a pipe up to the next pipe or the end of the line, neither of which is
+// part of the match. A pipe right after escape is part of the cell, as in
+// `a \| b` with '\\'. Use 0 as escape to end cells at every pipe.
+func NewTableCell(escape rune) func(r rune) (textlexer.Rule, textlexer.State) {
+	var nextChar textlexer.Rule
+
+	nextChar = func(r rune) (textlexer.Rule, textlexer.State) {
+		// ends before the next unescaped pipe or the end of the line
+		if r == '|' || isEOL(r) || textlexer.IsEOF(r) {
+			return nil, textlexer.StateAccept
+		}
+
+		if escape != 0 && r == escape {
+			return func(r rune) (textlexer.Rule, textlexer.State) {
+				if r == '|' {
+					return nextChar, textlexer.StateContinue
+				}
+
+				return nextChar(r)
+			}, textlexer.StateContinue
+		}
+
+		return nextChar, textlexer.StateContinue
+	}
+
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		if r == '|' || isEOL(r) || textlexer.IsEOF(r) {
+			return nil, textlexer.StateReject
+		}
+
+		return nextChar(r)
+	}
+}
+
+// UnescapeTableCell replaces the escaped pipes in a cell matched by
+// NewTableCell with the same escape.
+func UnescapeTableCell(text string, escape rune) string {
+	if escape == 0 {
+		return text
+	}
+
+	return strings.ReplaceAll(text, string(escape)+"|", "|")
+}
+
+// NewHexFloat returns a rule that matches hexadecimal floating point literals,
+// as in C and Go: a "0x" or "0X" prefix, hexadecimal digits with an optional
+// radix point, and a binary exponent, as in "0x1.8p3" or "0x.fP-2". The
+// exponent is mandatory, "p" or "P" followed by a decimal integer with an
+// optional sign.
+func NewHexFloat() func(r rune) (textlexer.Rule, textlexer.State) {
+	mantissa := func(r rune) (textlexer.Rule, textlexer.State) {
+		var integerPart, fractionalPart textlexer.Rule
+
+		fractionalPart = func(r rune) (textlexer.Rule, textlexer.State) {
+			if isHexDigit(r) {
+				return fractionalPart, textlexer.StateContinue
+			}
+
+			return nil, textlexer.StateAccept
+		}
+
+		integerPart = func(r rune) (textlexer.Rule, textlexer.State) {
+			if isHexDigit(r) {
+				return integerPart, textlexer.StateContinue
+			}
+
+			if r == '.' {
+				return fractionalPart, textlexer.StateContinue
+			}
+
+			return nil, textlexer.StateAccept
+		}
+
+		if isHexDigit(r) {
+			return integerPart, textlexer.StateContinue
+		}
+
+		if r == '.' {
+			return func(r rune) (textlexer.Rule, textlexer.State) {
+				// expects a digit immediately after the radix point
+				if isHexDigit(r) {
+					return fractionalPart, textlexer.StateContinue
+				}
+
+				return nil, textlexer.StateReject
+			}, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+
+	exponent := func(r rune) (textlexer.Rule, textlexer.State) {
+		// the binary exponent is mandatory
+		if r == 'p' || r == 'P' {
+			return func(r rune) (textlexer.Rule, textlexer.State) {
+				if r == '-' || r == '+' {
+					return UnsignedInteger, textlexer.StateContinue
+				}
+
+				return UnsignedInteger(r)
+			}, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+
+	return Compose(
+		NewCaseInsensitiveLiteralMatch("0x"),
+		mantissa,
+		exponent,
+	)
+}
+
+// NewBraceFormatField returns a rule that matches a replacement field of
+// Python's str.format, as in "{name}", "{0!r}" or "{value:>{width}}". A field
+// has an optional name with attributes and indexes, as in "user.name[0]", an
+// optional "!r", "!s" or "!a" conversion and an optional ":" format spec that
+// may hold nested fields. The escaped braces "{{" and "}}" are matched on their
+// own.
+func NewBraceFormatField() func(r rune) (textlexer.Rule, textlexer.State) {
+	var fieldName, index, conversion, formatSpec, nestedField textlexer.Rule
+
+	closeField := func(r rune) (textlexer.Rule, textlexer.State) {
+		if r == '}' {
+			return Accept, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+
+	fieldName = func(r rune) (textlexer.Rule, textlexer.State) {
+		if isWordChar(r) || r == '.' {
+			return fieldName, textlexer.StateContinue
+		}
+
+		switch r {
+		case '[':
+			return index, textlexer.StateContinue
+		case '!':
+			return conversion, textlexer.StateContinue
+		case ':':
+			return formatSpec, textlexer.StateContinue
+		}
+
+		return closeField(r)
+	}
+
+	index = func(r rune) (textlexer.Rule, textlexer.State) {
+		if r == ']' {
+			return fieldName, textlexer.StateContinue
+		}
+
+		if r == '}' || isEOL(r) || textlexer.IsEOF(r) {
+			return nil, textlexer.StateReject
+		}
+
+		return index, textlexer.StateContinue
+	}
+
+	conversion = func(r rune) (textlexer.Rule, textlexer.State) {
+		if r == 'r' || r == 's' || r == 'a' {
+			return func(r rune) (textlexer.Rule, textlexer.State) {
+				if r == ':' {
+					return formatSpec, textlexer.StateContinue
+				}
+
+				return closeField(r)
+			}, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+
+	formatSpec = func(r rune) (textlexer.Rule, textlexer.State) {
+		if r == '}' {
+			return Accept, textlexer.StateContinue
+		}
+
+		// the spec may have nested fields, as in "{:>{width}}"
+		if r == '{' {
+			return nestedField, textlexer.StateContinue
+		}
+
+		if isEOL(r) || textlexer.IsEOF(r) {
+			return nil, textlexer.StateReject
+		}
+
+		return formatSpec, textlexer.StateContinue
+	}
+
+	nestedField = func(r rune) (textlexer.Rule, textlexer.State) {
+		if isWordChar(r) || r == '.' {
+			return nestedField, textlexer.StateContinue
+		}
+
+		if r == '}' {
+			return formatSpec, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		if r == '{' {
+			return func(r rune) (textlexer.Rule, textlexer.State) {
+				// "{{" is an escaped brace
+				if r == '{' {
+					return Accept, textlexer.StateContinue
+				}
+
+				return fieldName(r)
+			}, textlexer.StateContinue
+		}
+
+		// "}}" is an escaped brace, a single "}" is not valid
+		if r == '}' {
+			return closeField, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+}
+
+// NewShellWord returns a rule that matches a word of a POSIX shell command
+// line, up to the first unquoted space or operator such as "|", ";" or ">".
+// Within single quotes every rune is literal, within double quotes and
+// outside of quotes a backslash escapes the rune that follows. Quoted and
+// unquoted parts run together, as in `a"b c"'d'`. The quotes and escapes are
+// part of the match, an unterminated quote is not matched.
+func NewShellWord() func(r rune) (textlexer.Rule, textlexer.State) {
+	var unquoted, singleQuoted, doubleQuoted textlexer.Rule
+
+	isWordBreak := func(r rune) bool {
+		switch r {
+		case '|', '&', ';', '<', '>', '(', ')':
+			return true
+		}
+		return isSpace(r) || textlexer.IsEOF(r)
+	}
+
+	escaped := func(next textlexer.Rule) textlexer.Rule {
+		return func(r rune) (textlexer.Rule, textlexer.State) {
+			if textlexer.IsEOF(r) {
+				return nil, textlexer.StateReject
+			}
+
+			return next, textlexer.StateContinue
+		}
+	}
+
+	unquoted = func(r rune) (textlexer.Rule, textlexer.State) {
+		switch r {
+		case '\'':
+			return singleQuoted, textlexer.StateContinue
+		case '"':
+			return doubleQuoted, textlexer.StateContinue
+		case '\\':
+			return escaped(unquoted), textlexer.StateContinue
+		}
+
+		// the word ends at the first unquoted space or operator
+		if isWordBreak(r) {
+			return nil, textlexer.StateAccept
+		}
+
+		return unquoted, textlexer.StateContinue
+	}
+
+	singleQuoted = func(r rune) (textlexer.Rule, textlexer.State) {
+		// everything is literal within single quotes
+		if r == '\'' {
+			return unquoted, textlexer.StateContinue
+		}
+
+		if textlexer.IsEOF(r) {
+			return nil, textlexer.StateReject
+		}
+
+		return singleQuoted, textlexer.StateContinue
+	}
+
+	doubleQuoted = func(r rune) (textlexer.Rule, textlexer.State) {
+		switch r {
+		case '"':
+			return unquoted, textlexer.StateContinue
+		case '\\':
+			return escaped(doubleQuoted), textlexer.StateContinue
+		}
+
+		if textlexer.IsEOF(r) {
+			return nil, textlexer.StateReject
+		}
+
+		return doubleQuoted, textlexer.StateContinue
+	}
+
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		if isWordBreak(r) {
+			return nil, textlexer.StateReject
+		}
+
+		return unquoted(r)
+	}
+}
+
+func newCharacterClassMatcher(match func(r rune) bool, min, max int) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		var nextChar textlexer.Rule
+
+		count := 0
+
+		nextChar = func(r rune) (textlexer.Rule, textlexer.State) {
+			if (max < 0 || count < max) && !textlexer.IsEOF(r) && match(r) {
+				count++
+				return nextChar, textlexer.StateContinue
+			}
+
+			if count == 0 || count < min {
+				return nil, textlexer.StateReject
+			}
+
+			return nil, textlexer.StateAccept
+		}
+
+		return nextChar(r)
+	}
+}
+
+// NotRune matches any single rune other than c.
+func NotRune(c rune) func(r rune) (textlexer.Rule, textlexer.State) {
+	return NoneOf(c)
+}
+
+// NoneOf matches any single rune that is not one of chars.
+func NoneOf(chars ...rune) func(r rune) (textlexer.Rule, textlexer.State) {
+	return newCharacterClassMatcher(func(r rune) bool {
+		for _, c := range chars {
+			if r == c {
+				return false
+			}
+		}
+		return true
+	}, 1, 1)
+}
+
+// NewCharacterClassIgnoreCase returns a rule that matches a run of runes that
+// are in chars regardless of case, at least min and at most max of them. A
+// negative max means no limit, and at least one rune is always needed, even
+// with a min of 0. Runes are compared by their unicode.ToLower mapping, so
+// case pairs that need full case folding, as "ß" and "SS", don't match.
+func NewCharacterClassIgnoreCase(chars []rune, min, max int) func(r rune) (textlexer.Rule, textlexer.State) {
+	members := make(map[rune]bool, len(chars))
+	for _, c := range chars {
+		members[toLower(c)] = true
+	}
+
+	return newCharacterClassMatcher(func(r rune) bool {
+		return members[toLower(r)]
+	}, min, max)
+}
+
+// NestedDelimited returns a rule that matches a span from open to the close
+// that balances it, as in "(a (b) c)". When open and close are the same rune
+// spans can't nest, so the match ends at the next close, as in "|a b|".
+func NestedDelimited(open, close rune) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		var nextChar textlexer.Rule
+
+		depth := 0
+
+		nextChar = func(r rune) (textlexer.Rule, textlexer.State) {
+			if textlexer.IsEOF(r) {
+				// unbalanced
+				return nil, textlexer.StateReject
+			}
+
+			switch {
+			case open == close && depth > 0 && r == close:
+				return Accept, textlexer.StateContinue
+			case r == open:
+				depth++
+			case r == close:
+				depth--
+				if depth == 0 {
+					return Accept, textlexer.StateContinue
+				}
+			}
+
+			return nextChar, textlexer.StateContinue
+		}
+
+		if r == open {
+			return nextChar(r)
+		}
+
+		return nil, textlexer.StateReject
+	}
+}
+
+// Heredoc returns a rule that matches startPrefix followed by a tag and a new
+// line, as in "<<END\n", and then everything up to a line that holds only the
+// tag. The new line after the closing tag is not part of the match.
+func Heredoc(startPrefix string) func(r rune) (textlexer.Rule, textlexer.State) {
+	prefix := []rune(startPrefix)
+
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		var nextPrefix, nextTag, nextBody textlexer.Rule
+
+		var tag []rune
+		matched := 0
+
+		// whether the current line is still a prefix of the tag
+		atTag := true
+
+		nextBody = func(r rune) (textlexer.Rule, textlexer.State) {
+			if isEOL(r) || textlexer.IsEOF(r) {
+				if atTag && matched == len(tag) {
+					return nil, textlexer.StateAccept
+				}
+
+				if textlexer.IsEOF(r) {
+					return nil, textlexer.StateReject
+				}
+
+				atTag, matched = true, 0
+				return nextBody, textlexer.StateContinue
+			}
+
+			if atTag && matched < len(tag) && r == tag[matched] {
+				matched++
+			} else {
+				atTag = false
+			}
+
+			return nextBody, textlexer.StateContinue
+		}
+
+		nextTag = func(r rune) (textlexer.Rule, textlexer.State) {
+			if isWordChar(r) {
+				tag = append(tag, r)
+				return nextTag, textlexer.StateContinue
+			}
+
+			if isEOL(r) && len(tag) > 0 {
+				return nextBody, textlexer.StateContinue
+			}
+
+			return nil, textlexer.StateReject
+		}
+
+		nextPrefix = func(r rune) (textlexer.Rule, textlexer.State) {
+			if matched == len(prefix) {
+				matched = 0
+				return nextTag(r)
+			}
+
+			if r != prefix[matched] {
+				return nil, textlexer.StateReject
+			}
+
+			matched++
+			return nextPrefix, textlexer.StateContinue
+		}
+
+		return nextPrefix(r)
+	}
+}
+
+// NewTemplateLiteral returns a rule that matches a JavaScript template
+// literal, from the opening backquote to the closing one, as in `a ${b} c`.
+// Within an interpolation braces are balanced and strings in single or double
+// quotes are skipped, so a "}" or a backquote in them does not end it, and
+// template literals can be nested, as in `a ${`b ${c}`}`. A backslash escapes
+// the rune that follows, both in the text and in quoted strings. An
+// unterminated literal is not matched.
+func NewTemplateLiteral() func(r rune) (textlexer.Rule, textlexer.State) {
+	type frame struct {
+		// whether the frame is the text of a literal or an interpolation
+		template bool
+		// open braces and quote within an interpolation
+		depth int
+		quote rune
+	}
+
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		var nextChar textlexer.Rule
+		var escaped, dollar bool
+
+		if r != '`' {
+			return nil, textlexer.StateReject
+		}
+
+		stack := []*frame{{template: true}}
+
+		nextChar = func(r rune) (textlexer.Rule, textlexer.State) {
+			if textlexer.IsEOF(r) {
+				// unterminated literal
+				return nil, textlexer.StateReject
+			}
+
+			if escaped {
+				escaped = false
+				return nextChar, textlexer.StateContinue
+			}
+
+			top := stack[len(stack)-1]
+
+			if top.template {
+				if dollar {
+					dollar = false
+					if r == '{' {
+						stack = append(stack, &frame{})
+						return nextChar, textlexer.StateContinue
+					}
+				}
+
+				switch r {
+				case '\\':
+					escaped = true
+				case '$':
+					dollar = true
+				case '`':
+					stack = stack[:len(stack)-1]
+					if len(stack) == 0 {
+						return Accept, textlexer.StateContinue
+					}
+				}
+
+				return nextChar, textlexer.StateContinue
+			}
+
+			if top.quote != 0 {
+				switch r {
+				case '\\':
+					escaped = true
+				case top.quote:
+					top.quote = 0
+				}
+
+				return nextChar, textlexer.StateContinue
+			}
+
+			switch r {
+			case '\'', '"':
+				top.quote = r
+			case '`':
+				// a literal nested within the interpolation
+				stack = append(stack, &frame{template: true})
+			case '{':
+				top.depth++
+			case '}':
+				if top.depth == 0 {
+					stack = stack[:len(stack)-1]
+				} else {
+					top.depth--
+				}
+			}
+
+			return nextChar, textlexer.StateContinue
+		}
+
+		return nextChar, textlexer.StateContinue
+	}
+}
+
+// RestOfLine matches everything up to the end of the line, the line ending is
+// not part of the match, including the "\r" of a "\r\n" sequence.
+func RestOfLine(r rune) (textlexer.Rule, textlexer.State) {
+	var nextChar textlexer.Rule
+
+	consumed := 0
+
+	nextChar = func(r rune) (textlexer.Rule, textlexer.State) {
+		if r == '\n' || textlexer.IsEOF(r) {
+			if consumed == 0 {
+				// empty line
+				return nil, textlexer.StateReject
+			}
+			return nil, textlexer.StateAccept
+		}
+
+		if r == '\r' {
+			return func(r rune) (textlexer.Rule, textlexer.State) {
+				if r != '\n' {
+					consumed++
+					return nextChar(r)
+				}
+
+				if consumed == 0 {
+					return nil, textlexer.StateReject
+				}
+
+				// "\r\n" ends the line
+				return pushBack(1, Accept)(r)
+			}, textlexer.StateContinue
+		}
+
+		consumed++
+		return nextChar, textlexer.StateContinue
+	}
+
+	return nextChar(r)
+}
+
+// NewCommandLineFlag returns a rule that matches a command line flag: short
+// flags, as in "-x" or the combined "-abc", long flags, as in "--long" or
+// "--dry-run", and long flags with a value, as in "--long=value", where the
+// value runs up to the next space. A bare "--" or "-" is not matched, see
+// EndOfFlags and StdinFlag.
+func NewCommandLineFlag() func(r rune) (textlexer.Rule, textlexer.State) {
+	var shortName, longName, value textlexer.Rule
+
+	isFlagChar := func(r rune) bool {
+		return isWordChar(r) || r == '-'
+	}
+
+	// combined short flags, as in "-abc"
+	shortName = func(r rune) (textlexer.Rule, textlexer.State) {
+		if isLetter(r) || isNumeric(r) {
+			return shortName, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateAccept
+	}
+
+	longName = func(r rune) (textlexer.Rule, textlexer.State) {
+		if isFlagChar(r) {
+			return longName, textlexer.StateContinue
+		}
+
+		if r == '=' {
+			return value, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateAccept
+	}
+
+	value = func(r rune) (textlexer.Rule, textlexer.State) {
+		if isSpace(r) || textlexer.IsEOF(r) {
+			return nil, textlexer.StateAccept
+		}
+
+		return value, textlexer.StateContinue
+	}
+
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		if r != '-' {
+			return nil, textlexer.StateReject
+		}
+
+		return func(r rune) (textlexer.Rule, textlexer.State) {
+			if r == '-' {
+				return func(r rune) (textlexer.Rule, textlexer.State) {
+					// a bare "--" is matched by EndOfFlags
+					if isLetter(r) || isNumeric(r) {
+						return longName, textlexer.StateContinue
+					}
+
+					return nil, textlexer.StateReject
+				}, textlexer.StateContinue
+			}
+
+			// a bare "-" is matched by StdinFlag
+			if isLetter(r) || isNumeric(r) {
+				return shortName, textlexer.StateContinue
+			}
+
+			return nil, textlexer.StateReject
+		}, textlexer.StateContinue
+	}
+}
+
+// EndOfFlags matches a "--" that stands on its own, which marks the end of the
+// flags of a command line.
+func EndOfFlags(r rune) (textlexer.Rule, textlexer.State) {
+	return Compose(NewLiteralMatch("--"), WhitespaceDelimiter)(r)
+}
+
+// StdinFlag matches a "-" that stands on its own, which usually means the
+// standard input or output in place of a file name.
+func StdinFlag(r rune) (textlexer.Rule, textlexer.State) {
+	return Compose(Minus, WhitespaceDelimiter)(r)
+}
+
+// ExactlyN matches the next n runes, whatever they are, and rejects the input
+// if it ends before. Matches are never empty, so ExactlyN(0) matches nothing.
+func ExactlyN(n int) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		var nextRune textlexer.Rule
+
+		count := 0
+
+		nextRune = func(r rune) (textlexer.Rule, textlexer.State) {
+			if count >= n {
+				if count == 0 {
+					return nil, textlexer.StateReject
+				}
+				return nil, textlexer.StateAccept
+			}
+
+			if textlexer.IsEOF(r) {
+				return nil, textlexer.StateReject
+			}
+
+			count++
+			return nextRune, textlexer.StateContinue
+		}
+
+		return nextRune(r)
+	}
+}
+
+// NewSymbolFunctionMatcher matches a single symbol for which match returns
+// true.
+func NewSymbolFunctionMatcher(match func(s textlexer.Symbol) bool) textlexer.SymbolRule {
+	return func(s textlexer.Symbol) (textlexer.SymbolRule, textlexer.State) {
+		if s.IsEOF() || !match(s) {
+			return nil, textlexer.StateReject
+		}
+
+		return func(s textlexer.Symbol) (textlexer.SymbolRule, textlexer.State) {
+			return nil, textlexer.StateAccept
+		}, textlexer.StateContinue
+	}
+}
+
+// BOM matches a byte order mark (U+FEFF) at the start of the input, it must
+// be added with AddSymbolRule. See also textlexer.WithStripBOM.
+func BOM(s textlexer.Symbol) (textlexer.SymbolRule, textlexer.State) {
+	return NewSymbolFunctionMatcher(func(s textlexer.Symbol) bool {
+		return s.IsBOF() && s.Rune == '\uFEFF'
+	})(s)
+}
+
+// WordBoundary matches nothing, it accepts an empty match where the rune
+// before and the current one are on different sides of the edge of a word,
+// as \b in regular expressions. The start and the end of the input count as
+// outside of words. Use it within ComposeSymbols, as in:
+//
+//	ComposeSymbols(WordBoundary, textlexer.SymbolRuleFunc(NewLiteralMatch("if")), WordBoundary)
+func WordBoundary(s textlexer.Symbol) (textlexer.SymbolRule, textlexer.State) {
+	if isWordRune(s.Prev) == isWordRune(s.Rune) {
+		return nil, textlexer.StateReject
+	}
+
+	return nil, textlexer.StateAccept
+}
@@ -2,7 +2,10 @@ package rules_test
 
 import (
 	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"unicode"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -341,11 +344,113 @@ func TestWhitespace(t *testing.T) {
 			"a b c \n d",
 			[]string{" ", " ", " \n "},
 		},
+		{
+			"a\u200db\u010ac\u0120d\u0109e",
+			nil,
+		},
 	}
 
 	runTestInputAndMatches(t, testCases, rules.Whitespace)
 }
 
+func TestHorizontalWhitespace(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			" \t",
+			[]string{" \t"},
+		},
+		{
+			"\n",
+			nil,
+		},
+		{
+			"a \n b",
+			[]string{" ", " "},
+		},
+		{
+			"a\t \r\n\tb",
+			[]string{"\t ", "\t"},
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.HorizontalWhitespace)
+}
+
+func TestNewline(t *testing.T) {
+	t.Run("matches", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"\n", []string{"\n"}},
+			{"\r\n", []string{"\r\n"}},
+			{"\n\n", []string{"\n", "\n"}},
+			{"a\r b", nil},
+			{"a \r\n\tb\n", []string{"\r\n", "\n"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Newline)
+	})
+
+	t.Run("lines", func(t *testing.T) {
+		lx := textlexer.NewFromString("let a\r\nlet b\n\nend")
+
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("NEWLINE", rules.Newline)
+		lx.MustAddRule("WHITESPACE", rules.HorizontalWhitespace)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err != nil {
+				require.ErrorIs(t, err, io.EOF)
+				break
+			}
+			if lex.Type == "WHITESPACE" {
+				continue
+			}
+			out = append(out, fmt.Sprintf("%v:%d:%d", lex, lex.Line(), lex.Col()))
+		}
+
+		expected := []string{
+			`WORD("let")@0+3:0:0`,
+			`WORD("a")@4+1:0:4`,
+			`NEWLINE("\r\n")@5+2:0:5`,
+			`WORD("let")@7+3:1:0`,
+			`WORD("b")@11+1:1:4`,
+			`NEWLINE("\n")@12+1:1:5`,
+			`NEWLINE("\n")@13+1:2:0`,
+			`WORD("end")@14+3:3:0`,
+		}
+		assert.Equal(t, expected, out)
+	})
+}
+
+func TestUnicodeWhitespace(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"a \t\n b",
+			[]string{" \t\n "},
+		},
+		{
+			"a\u00a0b",
+			[]string{"\u00a0"},
+		},
+		{
+			"a\u2003\u3000 b\u200bc",
+			[]string{"\u2003\u3000 "},
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.UnicodeWhitespace)
+}
+
 func TestInvert(t *testing.T) {
 	t.Run("invert whitespace", func(t *testing.T) {
 		testCases := []inputAndMatchesCase{
@@ -625,11 +730,88 @@ func TestWord(t *testing.T) {
 			"/abc123.",
 			[]string{"abc123"},
 		},
+		{
+			"café, naïve",
+			[]string{"café", "naïve"},
+		},
+		{
+			"cafe\u0301!",
+			[]string{"cafe\u0301"},
+		},
+		{
+			"你好，世界",
+			[]string{"你好", "世界"},
+		},
+		{
+			"Привет мир",
+			[]string{"Привет", "мир"},
+		},
+		{
+			"\u0301a",
+			[]string{"a"},
+		},
 	}
 
 	runTestInputAndMatches(t, testCases, rules.Word)
 }
 
+func TestPunctuation(t *testing.T) {
+	t.Run("single", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"a", nil},
+			{"a, b.", []string{",", "."}},
+			{"wait...", []string{".", ".", "."}},
+			{"so\u2014that\u2026", []string{"\u2014", "\u2026"}},
+			{"¿qué?", []string{"¿", "?"}},
+			{"1+2", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Punctuation)
+	})
+
+	t.Run("run", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"a", nil},
+			{"what?!", []string{"?!"}},
+			{"wait... no!!!", []string{"...", "!!!"}},
+			{"so\u2014that\u2026", []string{"\u2014", "\u2026"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.PunctuationRun)
+	})
+}
+
+func TestWordAndPunctuation(t *testing.T) {
+	lx := textlexer.NewFromString("café, 世界!")
+
+	lx.MustAddRule("WORD", rules.Word)
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+	lx.MustAddRule("PUNCTUATION", rules.Punctuation)
+
+	var out []string
+	for {
+		lex, err := lx.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		out = append(out, lex.String())
+	}
+
+	expected := []string{
+		`WORD("café")@0+4`,
+		`PUNCTUATION(",")@4+1`,
+		`WHITESPACE(" ")@5+1`,
+		`WORD("世界")@6+2`,
+		`PUNCTUATION("!")@8+1`,
+	}
+
+	assert.Equal(t, expected, out)
+}
+
 func TestDoubleQuotedString(t *testing.T) {
 	testCases := []inputAndMatchesCase{
 		{
@@ -700,6 +882,34 @@ func TestSingleQuotedString(t *testing.T) {
 	runTestInputAndMatches(t, testCases, rules.SingleQuotedString)
 }
 
+func TestDoubledQuoteString(t *testing.T) {
+	t.Run("single quotes", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{``, nil},
+			{`'`, nil},
+			{`''`, []string{`''`}},
+			{`'a'`, []string{`'a'`}},
+			{`'a''b'`, []string{`'a''b'`}},
+			{`''''`, []string{`''''`}},
+			{`'it''s' 'x'`, []string{`'it''s'`, `'x'`}},
+			{`'a' 'b`, []string{`'a'`}},
+			{`'a\'b'`, []string{`'a\'`}},
+			{`'a''`, nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.DoubledQuoteString('\''))
+	})
+
+	t.Run("double quotes", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{`"say ""hi""",x`, []string{`"say ""hi"""`}},
+			{`"a"'b'`, []string{`"a"`}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.DoubledQuoteString('"'))
+	})
+}
+
 func TestDoubleQuotedFormattedString(t *testing.T) {
 	testCases := []inputAndMatchesCase{
 		{
@@ -793,6 +1003,83 @@ func TestSlashStarComment(t *testing.T) {
 	runTestInputAndMatches(t, testCases, rules.SlashStarComment)
 }
 
+func TestCStyleComments(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{"", nil},
+		{"// a\n/* b */c", []string{"// a", "/* b */"}},
+		{"a /* b\n// c */ d", []string{"/* b\n// c */"}},
+		{"// a /* b */", []string{"// a /* b */"}},
+		{"/* unterminated", nil},
+		{"/ * a", nil},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.CStyleComments)
+
+	t.Run("lexer", func(t *testing.T) {
+		lx := textlexer.NewFromString("// a\n/* b */c", textlexer.WithSkipLeadingWhitespace())
+		lx.MustAddRule("COMMENT", rules.CStyleComments)
+		lx.MustAddRule("WORD", rules.Word)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.String())
+		}
+
+		assert.Equal(t, []string{`COMMENT("// a")@0+4`, `COMMENT("/* b */")@5+7`, `WORD("c")@12+1`}, out)
+	})
+}
+
+func TestLineComments(t *testing.T) {
+	t.Run("hash", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"# a", []string{"# a"}},
+			{"x = 1 # one\ny # two\n", []string{"# one", "# two"}},
+			{"#", []string{"#"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.HashComment)
+	})
+
+	t.Run("semicolon", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"; a", []string{"; a"}},
+			{"(car x) ; first\n;; b", []string{"; first", ";; b"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.SemicolonComment)
+	})
+
+	t.Run("sql", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"-- a", []string{"-- a"}},
+			{"SELECT 1; -- one\nSELECT 2 - 1", []string{"-- one"}},
+			{"- a", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.SQLComment)
+	})
+}
+
+func TestLineContinuation(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{"", nil},
+		{"\\\n", []string{"\\\n"}},
+		{"a \\\r\nb", []string{"\\\r\n"}},
+		{"a \\\rb", []string{"\\\r"}},
+		{"a \\ b", nil},
+		{"a \\", nil},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.LineContinuation('\\'))
+}
+
 func TestLiteralMatch(t *testing.T) {
 	testCases := []inputAndMatchesCase{
 		{
@@ -871,6 +1158,47 @@ func TestCaseInsensitiveLiteralMatch(t *testing.T) {
 	runTestInputAndMatches(t, testCases, matchDefKeywordRule)
 }
 
+func TestKeywordPhrase(t *testing.T) {
+	t.Run("matches", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"ORDER BY", []string{"ORDER BY"}},
+			{"ORDER    BY", []string{"ORDER    BY"}},
+			{"ORDER\n\tBY x", []string{"ORDER\n\tBY"}},
+			{"ORDERBY", nil},
+			{"ORDER", nil},
+			{"order by", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.KeywordPhrase("ORDER", "BY"))
+	})
+
+	t.Run("with identifiers", func(t *testing.T) {
+		lx := textlexer.NewFromString("SELECT a FROM t ORDER    BY a", textlexer.WithSkipLeadingWhitespace())
+		lx.MustAddRule("ORDER_BY", rules.KeywordPhrase("ORDER", "BY"))
+		lx.MustAddRule("IDENT", rules.Word)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err != nil {
+				require.ErrorIs(t, err, io.EOF)
+				break
+			}
+			out = append(out, lex.String())
+		}
+
+		assert.Equal(t, []string{
+			`IDENT("SELECT")@0+6`,
+			`IDENT("a")@7+1`,
+			`IDENT("FROM")@9+4`,
+			`IDENT("t")@14+1`,
+			`ORDER_BY("ORDER    BY")@16+11`,
+			`IDENT("a")@28+1`,
+		}, out)
+	})
+}
+
 func TestAlways(t *testing.T) {
 
 	t.Run("reject", func(t *testing.T) {
@@ -1055,6 +1383,8 @@ func runTestInputAndMatches(t *testing.T, testCases []inputAndMatchesCase, initi
 
 			var matches []string
 
+			pushBacks := 0
+
 			buf := make([]rune, 0, len(tc.Input))
 			for j := 0; j < len(input); j++ {
 
@@ -1068,20 +1398,37 @@ func runTestInputAndMatches(t *testing.T, testCases []inputAndMatchesCase, initi
 				if rule == nil {
 					rule = initialRule
 					buf = buf[:0]
+					pushBacks = 0
 				}
 
 				rule, state = rule(r)
 
 				switch state {
+				case textlexer.StatePushBack:
+					require.True(t, pushBacks <= len(buf), "Pushed back more runes than consumed.")
+					pushBacks++
+					// feed the same rune again
+					j = j - 1
+					continue
 				case textlexer.StateAccept:
+					if pushBacks > len(buf) {
+						// an empty match, nothing matches at this rune
+						rule = nil
+						continue
+					}
 					if matches == nil {
 						matches = []string{}
 					}
-					matches = append(matches, string(buf))
+					matches = append(matches, string(buf[:len(buf)-pushBacks]))
 					if len(buf) > 0 {
-						j = j - 1
+						j = j - 1 - pushBacks
 					}
 					buf = buf[:0]
+					if pushBacks > 0 {
+						// the pushed back runes have to be scanned again
+						pushBacks = 0
+						continue
+					}
 				case textlexer.StateContinue:
 					buf = append(buf, r)
 				case textlexer.StateReject:
@@ -1102,3 +1449,1686 @@ func runTestInputAndMatches(t *testing.T, testCases []inputAndMatchesCase, initi
 		})
 	}
 }
+
+func TestAtEndOfLine(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"foo",
+			[]string{"foo"},
+		},
+		{
+			"foo bar",
+			[]string{"bar"},
+		},
+		{
+			"foo bar\nbaz",
+			[]string{"bar", "baz"},
+		},
+		{
+			"foo\r\nbar baz",
+			[]string{"foo", "baz"},
+		},
+		{
+			"foo \nbar",
+			[]string{"bar"},
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.AtEndOfLine(rules.Word))
+}
+
+func TestRepeat(t *testing.T) {
+	t.Run("between two and three", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{
+				"",
+				nil,
+			},
+			{
+				"ab",
+				nil,
+			},
+			{
+				"abab",
+				[]string{"abab"},
+			},
+			{
+				"abababab",
+				[]string{"ababab"},
+			},
+			{
+				"ababa",
+				[]string{"abab"},
+			},
+			{
+				"ab abab",
+				[]string{"abab"},
+			},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Repeat(rules.NewLiteralMatch("ab"), 2, 3))
+	})
+
+	t.Run("unbounded", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{
+				"",
+				nil,
+			},
+			{
+				"1,2,3,",
+				[]string{"1,2,3,"},
+			},
+			{
+				"12,3",
+				[]string{"12,"},
+			},
+			{
+				"12 3,",
+				[]string{"3,"},
+			},
+		}
+
+		digitAndComma := rules.Compose(rules.UnsignedInteger, rules.Comma)
+
+		runTestInputAndMatches(t, testCases, rules.Repeat(digitAndComma, 1, -1))
+	})
+}
+
+func TestOptional(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"12",
+			[]string{"12"},
+		},
+		{
+			"-12",
+			[]string{"-12"},
+		},
+		{
+			"--12",
+			[]string{"-12"},
+		},
+		{
+			"-x 7",
+			[]string{"7"},
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.Compose(
+		rules.Optional(rules.Minus),
+		rules.UnsignedInteger,
+	))
+
+	t.Run("alone", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"x", nil},
+			{"x12y", []string{"12"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Optional(rules.UnsignedInteger))
+	})
+}
+
+func TestComposePushBack(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"ac",
+			[]string{"ac"},
+		},
+		{
+			"abac",
+			[]string{"abac"},
+		},
+		{
+			"abab",
+			nil,
+		},
+		{
+			"abx ac",
+			[]string{"ac"},
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.Compose(
+		rules.Optional(rules.NewLiteralMatch("ab")),
+		rules.NewLiteralMatch("ac"),
+	))
+}
+
+func TestEscapeSequence(t *testing.T) {
+	named := map[rune]rune{
+		'n':  '\n',
+		't':  '\t',
+		'\\': '\\',
+		'"':  '"',
+	}
+
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			`\n`,
+			[]string{`\n`},
+		},
+		{
+			`\t\n`,
+			[]string{`\t`, `\n`},
+		},
+		{
+			`\\`,
+			[]string{`\\`},
+		},
+		{
+			`\u00e9`,
+			[]string{`\u00e9`},
+		},
+		{
+			`\u00E9z`,
+			[]string{`\u00E9`},
+		},
+		{
+			`\u00e9f`,
+			[]string{`\u00e9`},
+		},
+		{
+			`\u12`,
+			nil,
+		},
+		{
+			`\u12g4`,
+			nil,
+		},
+		{
+			`\x`,
+			nil,
+		},
+		{
+			`n`,
+			nil,
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.EscapeSequence('\\', named, 4))
+
+	t.Run("no unicode", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{
+				`\u00e9`,
+				nil,
+			},
+			{
+				`%n`,
+				[]string{`%n`},
+			},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.EscapeSequence('%', named, 0))
+	})
+}
+
+func TestCharLiteral(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{"", nil},
+		{`'a'`, []string{`'a'`}},
+		{`'é'`, []string{`'é'`}},
+		{`'\n'`, []string{`'\n'`}},
+		{`'\''`, []string{`'\''`}},
+		{`'\\'`, []string{`'\\'`}},
+		{`'\x41'`, []string{`'\x41'`}},
+		{`'\u00e9'`, []string{`'\u00e9'`}},
+		{`x = 'a';`, []string{`'a'`}},
+		{`'ab'`, nil},
+		{`''`, nil},
+		{`'a`, nil},
+		{`'\x4'`, nil},
+		{`'\x411'`, nil},
+		{`'\q'`, nil},
+		{"'\n'", nil},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.CharLiteral)
+
+	t.Run("with strings", func(t *testing.T) {
+		lx := textlexer.NewFromString(`'a' 'ab' '\n' ''`, textlexer.WithSkipLeadingWhitespace())
+		lx.MustAddRule("STRING", rules.SingleQuotedString)
+		lx.MustAddRule("CHAR", rules.CharLiteral)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, fmt.Sprintf("%s(%s)", lex.Type, lex.Text()))
+		}
+
+		assert.Equal(t, []string{`CHAR('a')`, `STRING('ab')`, `CHAR('\n')`, `STRING('')`}, out)
+	})
+}
+
+func TestIdentifierFunc(t *testing.T) {
+	isStart := func(r rune) bool {
+		return unicode.IsLetter(r) || r == '_'
+	}
+
+	t.Run("css", func(t *testing.T) {
+		isContinue := func(r rune) bool {
+			return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+		}
+
+		testCases := []inputAndMatchesCase{
+			{
+				"",
+				nil,
+			},
+			{
+				"a",
+				[]string{"a"},
+			},
+			{
+				"font-size: 12px",
+				[]string{"font-size", "px"},
+			},
+			{
+				"margin-top-0",
+				[]string{"margin-top-0"},
+			},
+			{
+				"-webkit",
+				[]string{"webkit"},
+			},
+			{
+				"1px",
+				[]string{"px"},
+			},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.IdentifierFunc(isStart, isContinue))
+	})
+
+	t.Run("lisp", func(t *testing.T) {
+		isContinue := func(r rune) bool {
+			return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-?!", r)
+		}
+
+		testCases := []inputAndMatchesCase{
+			{
+				"(empty? xs)",
+				[]string{"empty?", "xs"},
+			},
+			{
+				"(set-car! p 1)",
+				[]string{"set-car!", "p"},
+			},
+			{
+				"?x",
+				[]string{"x"},
+			},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.IdentifierFunc(isStart, isContinue))
+	})
+}
+
+func TestNamespacedIdentifier(t *testing.T) {
+	t.Run("colon", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{
+				"",
+				nil,
+			},
+			{
+				"a:b",
+				[]string{"a:b"},
+			},
+			{
+				"xml:lang",
+				[]string{"xml:lang"},
+			},
+			{
+				":kw",
+				[]string{":kw"},
+			},
+			{
+				"a:",
+				[]string{"a"},
+			},
+			{
+				"a: b",
+				[]string{"a", "b"},
+			},
+			{
+				"abc",
+				[]string{"abc"},
+			},
+			{
+				"a:1",
+				[]string{"a"},
+			},
+			{
+				"a:b:c",
+				[]string{"a:b", ":c"},
+			},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.NewNamespacedIdentifier(':'))
+	})
+
+	t.Run("slash", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{
+				"my.ns/symbol",
+				[]string{"my.ns/symbol"},
+			},
+			{
+				"(clojure.core/map inc)",
+				[]string{"clojure.core/map", "inc"},
+			},
+			{
+				"my-ns/",
+				[]string{"my-ns"},
+			},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.NewNamespacedIdentifier('/'))
+	})
+}
+
+func TestTableCell(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"|a|b|",
+			[]string{"a", "b"},
+		},
+		{
+			"| a \\| b | c |",
+			[]string{" a \\| b ", " c "},
+		},
+		{
+			"||x||",
+			[]string{"x"},
+		},
+		{
+			"| a | last\n| b |",
+			[]string{" a ", " last", " b "},
+		},
+		{
+			"| a\\|\r\n",
+			[]string{" a\\|"},
+		},
+		{
+			"| trailing \\\n",
+			[]string{" trailing \\"},
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.NewTableCell('\\'))
+
+	assert.Equal(t, " a | b ", rules.UnescapeTableCell(" a \\| b ", '\\'))
+	assert.Equal(t, "a \\ b", rules.UnescapeTableCell("a \\ b", '\\'))
+
+	t.Run("other escape", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"| a ^| b | c |", []string{" a ^| b ", " c "}},
+			{"| a \\| b |", []string{" a \\", " b "}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.NewTableCell('^'))
+
+		assert.Equal(t, " a | b ", rules.UnescapeTableCell(" a ^| b ", '^'))
+	})
+
+	t.Run("no escape", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"| a \\| b |", []string{" a \\", " b "}},
+			{"|a||b|", []string{"a", "b"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.NewTableCell(0))
+
+		assert.Equal(t, " a \\| b ", rules.UnescapeTableCell(" a \\| b ", 0))
+	})
+}
+
+func TestHexFloat(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"0x1.8p3",
+			[]string{"0x1.8p3"},
+		},
+		{
+			"0x1p-2",
+			[]string{"0x1p-2"},
+		},
+		{
+			"0X1P+2",
+			[]string{"0X1P+2"},
+		},
+		{
+			"0x.8p1",
+			[]string{"0x.8p1"},
+		},
+		{
+			"0x1.p1",
+			[]string{"0x1.p1"},
+		},
+		{
+			"0xAbC.dEfp10 ",
+			[]string{"0xAbC.dEfp10"},
+		},
+		{
+			"0x1.8",
+			nil,
+		},
+		{
+			"0xFF",
+			nil,
+		},
+		{
+			"0x1p",
+			nil,
+		},
+		{
+			"0x1p-",
+			nil,
+		},
+		{
+			"0xp1",
+			nil,
+		},
+		{
+			"0x.p1",
+			nil,
+		},
+		{
+			"1.5p3",
+			nil,
+		},
+		{
+			"a=0x1p4;",
+			[]string{"0x1p4"},
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.NewHexFloat())
+}
+
+func TestBraceFormatField(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"{}",
+			[]string{"{}"},
+		},
+		{
+			"{name}",
+			[]string{"{name}"},
+		},
+		{
+			"{0}",
+			[]string{"{0}"},
+		},
+		{
+			"{0:>10}",
+			[]string{"{0:>10}"},
+		},
+		{
+			"{:.2f}",
+			[]string{"{:.2f}"},
+		},
+		{
+			"{value!r}",
+			[]string{"{value!r}"},
+		},
+		{
+			"{0!s:>{width}}",
+			[]string{"{0!s:>{width}}"},
+		},
+		{
+			"{user.name[0]}",
+			[]string{"{user.name[0]}"},
+		},
+		{
+			"{{",
+			[]string{"{{"},
+		},
+		{
+			"}}",
+			[]string{"}}"},
+		},
+		{
+			"{{x}}",
+			[]string{"{{", "}}"},
+		},
+		{
+			"Hello, {name}! You are {age:d} years old.",
+			[]string{"{name}", "{age:d}"},
+		},
+		{
+			"{unterminated\n}",
+			nil,
+		},
+		{
+			"{0:>10\n",
+			nil,
+		},
+		{
+			"{",
+			nil,
+		},
+		{
+			"}",
+			nil,
+		},
+		{
+			"{x!z}",
+			nil,
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.NewBraceFormatField())
+}
+
+func TestAnyOfStrings(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"if",
+			[]string{"if"},
+		},
+		{
+			"in int interface",
+			[]string{"in", "int", "interface"},
+		},
+		{
+			"inter",
+			[]string{"int"},
+		},
+		{
+			"interfaces",
+			[]string{"interface"},
+		},
+		{
+			"i f",
+			nil,
+		},
+		{
+			"forfor",
+			[]string{"for", "for"},
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.AnyOfStrings("if", "in", "int", "interface", "for", ""))
+}
+
+var benchmarkKeywords = []string{
+	"break", "case", "chan", "const", "continue", "default", "defer", "else",
+	"fallthrough", "for", "func", "go", "goto", "if", "import", "interface",
+	"map", "package", "range", "return", "select", "struct", "switch", "type",
+	"var",
+}
+
+func TestAnyOfStringsOperators(t *testing.T) {
+	ops := rules.AnyOfStrings("<", "<=", "<<", "<<=", "-", "--", "->", "=", "==")
+
+	testCases := []inputAndMatchesCase{
+		{"", nil},
+		{"<<=", []string{"<<="}},
+		{"a < b", []string{"<"}},
+		{"a <= b", []string{"<="}},
+		{"a<<=-b", []string{"<<=", "-"}},
+		{"x--->y", []string{"--", "->"}},
+		{"a===b", []string{"==", "="}},
+		{"<<<", []string{"<<", "<"}},
+		{"+", nil},
+	}
+
+	runTestInputAndMatches(t, testCases, ops)
+
+	t.Run("lexer", func(t *testing.T) {
+		lx := textlexer.NewFromString("x<<=y<z", textlexer.WithSkipLeadingWhitespace())
+		lx.MustAddRule("IDENT", rules.Word)
+		lx.MustAddRule("OP", ops)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, fmt.Sprintf("%s(%s)", lex.Type, lex.Text()))
+		}
+
+		assert.Equal(t, []string{"IDENT(x)", "OP(<<=)", "IDENT(y)", "OP(<)", "IDENT(z)"}, out)
+	})
+}
+
+func BenchmarkAnyOfStrings(b *testing.B) {
+	benchmarkKeywordRule(b, rules.AnyOfStrings(benchmarkKeywords...))
+}
+
+func BenchmarkNaiveAnyOfStrings(b *testing.B) {
+	keywords := make([]textlexer.Rule, 0, len(benchmarkKeywords))
+	for _, keyword := range benchmarkKeywords {
+		keywords = append(keywords, rules.NewLiteralMatch(keyword))
+	}
+
+	benchmarkKeywordRule(b, rules.NewMatchAnyOf(keywords...))
+}
+
+func benchmarkKeywordRule(b *testing.B, rule textlexer.Rule) {
+	inputs := make([][]rune, 0, len(benchmarkKeywords))
+	for _, keyword := range benchmarkKeywords {
+		inputs = append(inputs, append([]rune(keyword), ' '))
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, input := range inputs {
+			next := rule
+			for _, r := range input {
+				var state textlexer.State
+				next, state = next(r)
+				for state == textlexer.StatePushBack {
+					next, state = next(r)
+				}
+				if state != textlexer.StateContinue {
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestShellWord(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"foo",
+			[]string{"foo"},
+		},
+		{
+			"ls -la /tmp",
+			[]string{"ls", "-la", "/tmp"},
+		},
+		{
+			`foo"bar baz"'qux'`,
+			[]string{`foo"bar baz"'qux'`},
+		},
+		{
+			`a\ b c`,
+			[]string{`a\ b`, "c"},
+		},
+		{
+			`'it'\''s' "say \"hi\"" x`,
+			[]string{`'it'\''s'`, `"say \"hi\""`, "x"},
+		},
+		{
+			`"$HOME/my dir"/file.txt>out`,
+			[]string{`"$HOME/my dir"/file.txt`, "out"},
+		},
+		{
+			`echo 'a|b'|wc`,
+			[]string{"echo", `'a|b'`, "wc"},
+		},
+		{
+			`'unterminated`,
+			nil,
+		},
+		{
+			`"unterminated \"`,
+			nil,
+		},
+		{
+			`trailing\`,
+			nil,
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.NewShellWord())
+}
+
+func TestCharacterClassIgnoreCase(t *testing.T) {
+	t.Run("hex", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{
+				"",
+				nil,
+			},
+			{
+				"DEADBEEF",
+				[]string{"DEADBEEF"},
+			},
+			{
+				"deadbeef",
+				[]string{"deadbeef"},
+			},
+			{
+				"DeadBeef 0x1f g",
+				[]string{"DeadBeef", "0", "1f"},
+			},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.NewCharacterClassIgnoreCase([]rune("0123456789abcdef"), 1, -1))
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{
+				"a",
+				nil,
+			},
+			{
+				"aB",
+				[]string{"aB"},
+			},
+			{
+				"ABCab",
+				[]string{"ABC", "ab"},
+			},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.NewCharacterClassIgnoreCase([]rune("abc"), 2, 3))
+	})
+}
+
+func TestHeredoc(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"cat <<END\nhello\nworld\nEND\n",
+			[]string{"<<END\nhello\nworld\nEND"},
+		},
+		{
+			"<<END\nEND",
+			[]string{"<<END\nEND"},
+		},
+		{
+			"<<EOF\n  EOF\nEOFS\nxEOF\nEOF\n",
+			[]string{"<<EOF\n  EOF\nEOFS\nxEOF\nEOF"},
+		},
+		{
+			"<<A_1\r\nline\r\nA_1\r\n",
+			[]string{"<<A_1\r\nline\r\nA_1"},
+		},
+		{
+			"<<END\nunterminated\nEN",
+			nil,
+		},
+		{
+			"<<\nEND\n",
+			nil,
+		},
+		{
+			"<< END\nEND\n",
+			nil,
+		},
+		{
+			"<END\nEND\n",
+			nil,
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.Heredoc("<<"))
+}
+
+func TestNestedDelimited(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"()",
+			[]string{"()"},
+		},
+		{
+			"(a (b) c)",
+			[]string{"(a (b) c)"},
+		},
+		{
+			"(a (b (c)) d) (e) f",
+			[]string{"(a (b (c)) d)", "(e)"},
+		},
+		{
+			"(a",
+			nil,
+		},
+		{
+			"(a (b)",
+			nil,
+		},
+		{
+			")(",
+			nil,
+		},
+		{
+			"x(y)z)",
+			[]string{"(y)"},
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.NestedDelimited('(', ')'))
+
+	t.Run("same delimiter", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"||", []string{"||"}},
+			{"|a b| c", []string{"|a b|"}},
+			{"|a| |b|", []string{"|a|", "|b|"}},
+			{"|a", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.NestedDelimited('|', '|'))
+	})
+}
+
+func TestTemplateLiteral(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"``",
+			[]string{"``"},
+		},
+		{
+			"`text ${expr} more`",
+			[]string{"`text ${expr} more`"},
+		},
+		{
+			"x = `a ${ {k: 1}.k } b`;",
+			[]string{"`a ${ {k: 1}.k } b`"},
+		},
+		{
+			"`outer ${ `inner ${x}` } end`",
+			[]string{"`outer ${ `inner ${x}` } end`"},
+		},
+		{
+			"`${'}'} ${\"`\"}`",
+			[]string{"`${'}'} ${\"`\"}`"},
+		},
+		{
+			"`escaped \\` and \\${not}`",
+			[]string{"`escaped \\` and \\${not}`"},
+		},
+		{
+			"`$x $ {y} $`",
+			[]string{"`$x $ {y} $`"},
+		},
+		{
+			"`a` `b`",
+			[]string{"`a`", "`b`"},
+		},
+		{
+			"`unterminated",
+			nil,
+		},
+		{
+			"`${a`",
+			nil,
+		},
+		{
+			"`${ {a }`",
+			nil,
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.NewTemplateLiteral())
+}
+
+func TestLiteralChoice(t *testing.T) {
+	choice := rules.NewLiteralChoice("BEGIN", "END", "ENDIF", "ELSE", "ELSEIF", "END")
+
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"BEGIN ELSEIF ELSE ENDIF END",
+			[]string{"BEGIN", "ELSEIF", "ELSE", "ENDIF", "END"},
+		},
+		{
+			"ENDI",
+			[]string{"END"},
+		},
+		{
+			"ELSEENDIF",
+			[]string{"ELSE", "ENDIF"},
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, choice.Match)
+
+	assert.Equal(t, 0, choice.Index("BEGIN"))
+	assert.Equal(t, 1, choice.Index("END"))
+	assert.Equal(t, 2, choice.Index("ENDIF"))
+	assert.Equal(t, 3, choice.Index("ELSE"))
+	assert.Equal(t, 4, choice.Index("ELSEIF"))
+	assert.Equal(t, -1, choice.Index("ENDI"))
+	assert.Equal(t, -1, choice.Index(""))
+}
+
+func TestRestOfLine(t *testing.T) {
+	testCases := []inputAndMatchesCase{
+		{
+			"",
+			nil,
+		},
+		{
+			"abc",
+			[]string{"abc"},
+		},
+		{
+			"key = value\nnext",
+			[]string{"key = value", "next"},
+		},
+		{
+			"\n\nx",
+			[]string{"x"},
+		},
+		{
+			"a b\r\nc\r\n",
+			[]string{"a b", "c"},
+		},
+		{
+			"\r\n",
+			nil,
+		},
+		{
+			"a\rb\n",
+			[]string{"a\rb"},
+		},
+		{
+			"trailing\r",
+			[]string{"trailing\r"},
+		},
+	}
+
+	runTestInputAndMatches(t, testCases, rules.RestOfLine)
+}
+
+func TestCommandLineFlag(t *testing.T) {
+	t.Run("flags", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{
+				"",
+				nil,
+			},
+			{
+				"-x",
+				[]string{"-x"},
+			},
+			{
+				"-abc",
+				[]string{"-abc"},
+			},
+			{
+				"--verbose",
+				[]string{"--verbose"},
+			},
+			{
+				"--dry-run --max_count",
+				[]string{"--dry-run", "--max_count"},
+			},
+			{
+				"--output=file.txt -v",
+				[]string{"--output=file.txt", "-v"},
+			},
+			{
+				"--key= x",
+				[]string{"--key="},
+			},
+			{
+				"ls -la /tmp",
+				[]string{"-la"},
+			},
+			{
+				"- -- cat",
+				nil,
+			},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.NewCommandLineFlag())
+	})
+
+	t.Run("end of flags", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{
+				"--",
+				[]string{"--"},
+			},
+			{
+				"-- -x",
+				[]string{"--"},
+			},
+			{
+				"--verbose",
+				nil,
+			},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.EndOfFlags)
+	})
+
+	t.Run("stdin", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{
+				"-",
+				[]string{"-"},
+			},
+			{
+				"cat - x",
+				[]string{"-"},
+			},
+			{
+				"-x",
+				nil,
+			},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.StdinFlag)
+	})
+}
+
+func TestComposeWithBoundaries(t *testing.T) {
+	var ends [][]int
+
+	rule := rules.ComposeWithBoundaries(
+		func(e []int) {
+			ends = append(ends, e)
+		},
+		rules.Word,
+		rules.Optional(rules.Compose(rules.Colon, rules.UnsignedInteger)),
+	)
+
+	testCases := []struct {
+		inputAndMatchesCase
+		Ends [][]int
+	}{
+		{
+			inputAndMatchesCase{"port:8080", []string{"port:8080"}},
+			[][]int{{4, 9}},
+		},
+		{
+			inputAndMatchesCase{"x:7 yz:42", []string{"x:7", "yz:42"}},
+			[][]int{{1, 3}, {2, 5}},
+		},
+		{
+			inputAndMatchesCase{"a: b", []string{"a", "b"}},
+			[][]int{{1, 1}, {1, 1}},
+		},
+	}
+
+	for _, tc := range testCases {
+		ends = nil
+
+		runTestInputAndMatches(t, []inputAndMatchesCase{tc.inputAndMatchesCase}, rule)
+		assert.Equal(t, tc.Ends, ends, "input: %q", tc.Input)
+	}
+}
+
+func TestInvertAtEOF(t *testing.T) {
+	t.Run("inner rule accepts immediately", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"a", nil},
+			{"abc", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Invert(rules.Accept))
+	})
+
+	t.Run("inner rule rejects throughout", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"a", []string{"a"}},
+			{"abc", []string{"abc"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Invert(rules.Reject))
+	})
+
+	t.Run("inner rule continues then accepts", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"ab", nil},
+			{"xyab", []string{"xy"}},
+			{"xyabz", []string{"xy", "z"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Invert(rules.NewLiteralMatch("ab")))
+	})
+
+	t.Run("inner rule continues then rejects", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"a", []string{"a"}},
+			{"xya", []string{"xy", "a"}},
+			{"xyax", []string{"xy", "a", "x"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Invert(rules.NewLiteralMatch("ab")))
+	})
+
+	t.Run("inner rule accepts on the first rune", func(t *testing.T) {
+		acceptA := func(r rune) (textlexer.Rule, textlexer.State) {
+			if r == 'a' {
+				return nil, textlexer.StateAccept
+			}
+			return nil, textlexer.StateReject
+		}
+
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"a", nil},
+			{"xya", []string{"xy"}},
+			{"xyaz", []string{"xy", "z"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Invert(acceptA))
+	})
+
+	t.Run("lexer keeps the last lexeme", func(t *testing.T) {
+		lx := textlexer.NewFromString("abc def")
+
+		lx.MustAddRule("WORD", rules.Invert(rules.Whitespace))
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		var words []string
+		for {
+			lex, err := lx.Next()
+			if err != nil {
+				break
+			}
+			words = append(words, lex.String())
+		}
+
+		assert.Equal(t, []string{`WORD("abc")@0+3`, `WHITESPACE(" ")@3+1`, `WORD("def")@4+3`}, words)
+	})
+}
+
+func TestSeparatedBy(t *testing.T) {
+	t.Run("numbers", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"1", []string{"1"}},
+			{"1,22,333", []string{"1,22,333"}},
+			{"1,22,", []string{"1,22"}},
+			{"1,,2", []string{"1", "2"}},
+			{",1", []string{"1"}},
+			{"1, 2", []string{"1", "2"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.SeparatedBy(rules.UnsignedInteger, rules.Comma, false))
+	})
+
+	t.Run("numbers with trailing separator", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"1", []string{"1"}},
+			{"1,22,333", []string{"1,22,333"}},
+			{"1,22,", []string{"1,22,"}},
+			{"1,,2", []string{"1,", "2"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.SeparatedBy(rules.UnsignedInteger, rules.Comma, true))
+	})
+
+	t.Run("identifiers", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"a", []string{"a"}},
+			{"a,", []string{"a"}},
+			{"foo.bar.baz", []string{"foo.bar.baz"}},
+			{"foo.bar. baz", []string{"foo.bar", "baz"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.SeparatedBy(rules.Word, rules.Period, false))
+	})
+
+	t.Run("identifiers with trailing separator", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"a,", []string{"a,"}},
+			{"a,b,", []string{"a,b,"}},
+			{"a,b,,", []string{"a,b,"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.SeparatedBy(rules.Word, rules.Comma, true))
+	})
+}
+
+func TestExactlyN(t *testing.T) {
+	t.Run("three", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"ab", nil},
+			{"abc", []string{"abc"}},
+			{"a \n\t", []string{"a \n"}},
+			{"abcdefg", []string{"abc", "def"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.ExactlyN(3))
+	})
+
+	t.Run("zero", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"abc", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.ExactlyN(0))
+	})
+
+	t.Run("more than the input", func(t *testing.T) {
+		for _, acceptInconclusive := range []bool{false, true} {
+			lx := textlexer.NewFromString("abcdefg", textlexer.AcceptInconclusiveAtEOF(acceptInconclusive))
+			lx.MustAddRule("RECORD", rules.ExactlyN(3))
+
+			var out []string
+			for {
+				lex, err := lx.Next()
+				if err != nil {
+					assert.ErrorIs(t, err, io.EOF)
+					break
+				}
+				out = append(out, lex.Text())
+			}
+
+			assert.Equal(t, []string{"abc", "def"}, out)
+			assert.Equal(t, "g", lx.Buffered())
+		}
+	})
+}
+
+func TestNoneOf(t *testing.T) {
+	t.Run("not rune", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"a", []string{"a"}},
+			{"x", nil},
+			{"axb", []string{"a", "b"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.NotRune('x'))
+	})
+
+	t.Run("none of", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{`a"b\c`, []string{"a", "b", "c"}},
+			{`"\`, nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.NoneOf('"', '\\'))
+	})
+
+	t.Run("string literal", func(t *testing.T) {
+		stringLiteral := rules.Compose(
+			rules.NewLiteralMatch(`"`),
+			rules.Repeat(
+				rules.NewMatchAnyOf(
+					rules.NoneOf('"', '\\'),
+					rules.Compose(rules.NewLiteralMatch(`\`), rules.ExactlyN(1)),
+				),
+				0, -1,
+			),
+			rules.NewLiteralMatch(`"`),
+		)
+
+		testCases := []inputAndMatchesCase{
+			{`""`, []string{`""`}},
+			{`"abc"`, []string{`"abc"`}},
+			{`x = "a \"b\" \\" + "c"`, []string{`"a \"b\" \\"`, `"c"`}},
+			{`"unterminated`, nil},
+		}
+
+		runTestInputAndMatches(t, testCases, stringLiteral)
+	})
+}
+
+func TestUntil(t *testing.T) {
+	t.Run("whitespace", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{" ", nil},
+			{"abc", []string{"abc"}},
+			{"abc def12 3", []string{"abc", "def12", "3"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Until(rules.Whitespace))
+	})
+
+	t.Run("digits", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"1", nil},
+			{"abc def12 3", []string{"abc def", " "}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Until(rules.UnsignedInteger))
+	})
+
+	t.Run("multi-rune terminator", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"a-b->c", []string{"a-b", "c"}},
+			{"a--->", []string{"a--"}},
+			{"a-", []string{"a-"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Until(rules.NewLiteralMatch("->")))
+	})
+
+	t.Run("terminator accepts on its first rune", func(t *testing.T) {
+		// WhitespaceDelimiter matches nothing, so it never stops Until
+		testCases := []inputAndMatchesCase{
+			{"ab cd", []string{"ab cd"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Until(rules.WhitespaceDelimiter))
+	})
+
+	t.Run("lexer", func(t *testing.T) {
+		lx := textlexer.NewFromString("key: value -> next")
+
+		lx.MustAddRule("TEXT", rules.Until(rules.NewLiteralMatch("->")))
+		lx.MustAddRule("ARROW", rules.NewLiteralMatch("->"))
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.String())
+		}
+
+		assert.Equal(t, []string{`TEXT("key: value ")@0+11`, `ARROW("->")@11+2`, `TEXT(" next")@13+5`}, out)
+	})
+}
+
+func TestLongestOf(t *testing.T) {
+	t.Run("accepts on its first rune", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{" ", nil},
+			{"ab cd", []string{"ab", "cd"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.LongestOf(rules.WhitespaceDelimiter, rules.Word))
+	})
+
+	t.Run("keywords", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"if", []string{"if"}},
+			{"ifelse", []string{"ifelse"}},
+			{"ifels", []string{"if"}},
+			{"ifelse if", []string{"ifelse", "if"}},
+			{"else", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.LongestOf(
+			rules.NewLiteralMatch("if"),
+			rules.NewLiteralMatch("ifelse"),
+		))
+
+		// the first rule to accept wins
+		runTestInputAndMatches(t, []inputAndMatchesCase{
+			{"ifelse", []string{"if"}},
+		}, rules.NewMatchAnyOf(
+			rules.NewLiteralMatch("if"),
+			rules.NewLiteralMatch("ifelse"),
+		))
+	})
+
+	t.Run("overlapping", func(t *testing.T) {
+		// "ab" and "abab" are matched by several of the rules
+		alternatives := []textlexer.Rule{
+			rules.NewLiteralMatch("ab"),
+			rules.Repeat(rules.NewLiteralMatch("ab"), 1, -1),
+			rules.NewLiteralMatch("aba"),
+		}
+
+		testCases := []inputAndMatchesCase{
+			{"ab", []string{"ab"}},
+			{"aba", []string{"aba"}},
+			{"ababa", []string{"abab"}},
+			{"ababab", []string{"ababab"}},
+		}
+
+		for i := 0; i < 10; i++ {
+			runTestInputAndMatches(t, testCases, rules.LongestOf(alternatives...))
+		}
+
+		// the first rule to accept wins, a rule that gives back runes
+		// before accepting is not dropped
+		testCases = []inputAndMatchesCase{
+			{"ab", []string{"ab"}},
+			{"ababa", []string{"abab"}},
+			{"xabx", []string{"x", "ab", "x"}},
+		}
+
+		for i := 0; i < 10; i++ {
+			runTestInputAndMatches(t, testCases, rules.NewMatchAnyOf(
+				rules.Repeat(rules.NewLiteralMatch("ab"), 1, -1),
+				rules.NewSingleMatch('x'),
+			))
+		}
+
+		runTestInputAndMatches(t, []inputAndMatchesCase{
+			{"abab", []string{"ab", "ab"}},
+		}, rules.NewMatchAnyOf(alternatives...))
+	})
+
+	t.Run("numbers", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"12", []string{"12"}},
+			{"12.5", []string{"12.5"}},
+			{"12.", []string{"12"}},
+			{"a 1.25 b", []string{"1.25"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.LongestOf(rules.UnsignedInteger, rules.UnsignedFloat))
+	})
+
+	t.Run("in a sequence", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"<=a", []string{"<=a"}},
+			{"<a", []string{"<a"}},
+			{"<=", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Compose(
+			rules.LongestOf(
+				rules.NewLiteralMatch("<"),
+				rules.NewLiteralMatch("<="),
+			),
+			rules.Word,
+		))
+	})
+}
+
+func TestKeyValue(t *testing.T) {
+	sep := rules.Compose(
+		rules.Optional(rules.HorizontalWhitespace),
+		rules.Equal,
+		rules.Optional(rules.HorizontalWhitespace),
+	)
+	kv := rules.KeyValue(rules.Word, sep, rules.Word)
+
+	t.Run("match", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"name = John", []string{"name = John"}},
+			{"a=b", []string{"a=b"}},
+			{"a = ", nil},
+			{"= b", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, kv.Match)
+	})
+
+	t.Run("split", func(t *testing.T) {
+		assert.Equal(t, []int{4, 3, 4}, kv.Split([]rune("name = John")))
+		assert.Equal(t, []int{1, 1, 1}, kv.Split([]rune("a=b")))
+		assert.Nil(t, kv.Split([]rune("a = b c")))
+		assert.Nil(t, kv.Split([]rune("a")))
+	})
+
+	t.Run("lexer", func(t *testing.T) {
+		lx := textlexer.NewFromString("name = John\ncity=Paris", textlexer.WithSkipLeadingWhitespace())
+		require.NoError(t, lx.AddSplitRule("PAIR", kv.Match, kv.Split, "KEY", "", "VALUE"))
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.String())
+		}
+
+		assert.Equal(t, []string{
+			`KEY("name")@0+4`,
+			`VALUE("John")@7+4`,
+			`KEY("city")@12+4`,
+			`VALUE("Paris")@17+5`,
+		}, out)
+	})
+}
+
+func TestFollowedBy(t *testing.T) {
+	t.Run("followed by", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"foo", nil},
+			{"foo(x)", []string{"foo"}},
+			{"foo (x)", nil},
+			{"foo bar(baz)", []string{"bar"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.FollowedBy(rules.Word, rules.LParen))
+	})
+
+	t.Run("not followed by", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"foo", []string{"foo"}},
+			{"foo(x)", []string{"x"}},
+			{"foo (x)", []string{"foo", "x"}},
+			{"foo bar(baz)", []string{"foo", "baz"}},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.NotFollowedBy(rules.Word, rules.LParen))
+	})
+
+	t.Run("multi-rune lookahead", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"a->b", []string{"a"}},
+			{"a-b", nil},
+			{"a-", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.FollowedBy(rules.Word, rules.NewLiteralMatch("->")))
+	})
+
+	t.Run("lexer", func(t *testing.T) {
+		lx := textlexer.NewFromString("foo(bar) baz", textlexer.WithSkipLeadingWhitespace())
+
+		lx.MustAddRule("CALL", rules.FollowedBy(rules.Word, rules.LParen))
+		lx.MustAddRule("IDENTIFIER", rules.NotFollowedBy(rules.Word, rules.LParen))
+		lx.MustAddRule("PAREN", rules.Paren)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.String())
+		}
+
+		expected := []string{
+			`CALL("foo")@0+3`,
+			`PAREN("(")@3+1`,
+			`IDENTIFIER("bar")@4+3`,
+			`PAREN(")")@7+1`,
+			`IDENTIFIER("baz")@9+3`,
+		}
+
+		assert.Equal(t, expected, out)
+	})
+}
+
+func TestWordBoundary(t *testing.T) {
+	lx := textlexer.NewFromString("keyword keywording xkeyword (keyword)", textlexer.WithSkipLeadingWhitespace())
+
+	keyword := rules.ComposeSymbols(
+		rules.WordBoundary,
+		textlexer.SymbolRuleFunc(rules.NewLiteralMatch("keyword")),
+		rules.WordBoundary,
+	)
+	require.NoError(t, lx.AddSymbolRule("KEYWORD", keyword))
+
+	var keywords []string
+	for {
+		lex, err := lx.Next()
+		if err != nil {
+			require.ErrorIs(t, err, io.EOF)
+			break
+		}
+		if lex.Type == "KEYWORD" {
+			keywords = append(keywords, lex.String())
+		}
+	}
+
+	assert.Equal(t, []string{`KEYWORD("keyword")@0+7`, `KEYWORD("keyword")@29+7`}, keywords)
+}
@@ -0,0 +1,104 @@
+package rules
+
+import (
+	"github.com/xiam/textlexer"
+)
+
+type trieNode struct {
+	children map[rune]*trieNode
+	terminal bool
+}
+
+func newTrie(words ...string) *trieNode {
+	root := &trieNode{}
+
+	for _, word := range words {
+		node := root
+		for _, r := range word {
+			if node.children == nil {
+				node.children = map[rune]*trieNode{}
+			}
+
+			child, ok := node.children[r]
+			if !ok {
+				child = &trieNode{}
+				node.children[r] = child
+			}
+			node = child
+		}
+
+		if node != root {
+			node.terminal = true
+		}
+	}
+
+	return root
+}
+
+// longestMatch returns a rule that accepts the longest word in the trie.
+func (root *trieNode) longestMatch(r rune) (textlexer.Rule, textlexer.State) {
+	var nextChar func(*trieNode) textlexer.Rule
+
+	consumed, accepted := 0, 0
+
+	nextChar = func(node *trieNode) textlexer.Rule {
+		return func(r rune) (textlexer.Rule, textlexer.State) {
+			child, ok := node.children[r]
+			if !ok || textlexer.IsEOF(r) {
+				if accepted == 0 {
+					return nil, textlexer.StateReject
+				}
+
+				// go back to the end of the longest word
+				return pushBack(consumed-accepted, Accept)(r)
+			}
+
+			consumed++
+			if child.terminal {
+				accepted = consumed
+			}
+
+			return nextChar(child), textlexer.StateContinue
+		}
+	}
+
+	return nextChar(root)(r)
+}
+
+// AnyOfStrings matches the longest of the given words, as in "<<=" rather
+// than "<" or "<<". Words are matched regardless of what follows them.
+func AnyOfStrings(words ...string) func(r rune) (textlexer.Rule, textlexer.State) {
+	return newTrie(words...).longestMatch
+}
+
+type LiteralChoice struct {
+	trie    *trieNode
+	indexes map[string]int
+}
+
+// NewLiteralChoice creates a matcher for the longest of the given literals,
+// use Index to tell which literal was matched.
+func NewLiteralChoice(literals ...string) *LiteralChoice {
+	indexes := make(map[string]int, len(literals))
+	for i := len(literals) - 1; i >= 0; i-- {
+		indexes[literals[i]] = i
+	}
+
+	return &LiteralChoice{
+		trie:    newTrie(literals...),
+		indexes: indexes,
+	}
+}
+
+func (c *LiteralChoice) Match(r rune) (textlexer.Rule, textlexer.State) {
+	return c.trie.longestMatch(r)
+}
+
+// Index returns the position of the matched text within the literals, or -1
+// if the text is not one of them.
+func (c *LiteralChoice) Index(text string) int {
+	if i, ok := c.indexes[text]; ok {
+		return i
+	}
+	return -1
+}
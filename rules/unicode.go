@@ -0,0 +1,95 @@
+package rules
+
+import (
+	"unicode"
+
+	"github.com/xiam/textlexer"
+)
+
+// EmojiTable holds the blocks where most emoji are found: pictographs,
+// emoticons, transport and map symbols, dingbats and regional indicators.
+var EmojiTable = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f1e6, Hi: 0x1f1ff, Stride: 1},
+		{Lo: 0x1f300, Hi: 0x1f64f, Stride: 1},
+		{Lo: 0x1f680, Hi: 0x1f6ff, Stride: 1},
+		{Lo: 0x1f900, Hi: 0x1f9ff, Stride: 1},
+		{Lo: 0x1fa70, Hi: 0x1faff, Stride: 1},
+	},
+}
+
+// IsLetter reports whether r is a letter in any script, such as 'a', 'é' or
+// '漢'.
+func IsLetter(r rune) bool {
+	return r >= 0 && unicode.IsLetter(r)
+}
+
+// IsDigit reports whether r is a decimal digit in any script, such as '1' or
+// '١'.
+func IsDigit(r rune) bool {
+	return r >= 0 && unicode.IsDigit(r)
+}
+
+// IsLetterOrDigit reports whether r is a letter or a decimal digit.
+func IsLetterOrDigit(r rune) bool {
+	return IsLetter(r) || IsDigit(r)
+}
+
+// IsSpace reports whether r is white space, including line breaks and the
+// spaces outside of ASCII, such as U+00A0.
+func IsSpace(r rune) bool {
+	return r >= 0 && unicode.IsSpace(r)
+}
+
+// IsMark reports whether r is a combining mark, such as an accent written as
+// a separate rune.
+func IsMark(r rune) bool {
+	return r >= 0 && unicode.IsMark(r)
+}
+
+// IsNumber reports whether r is a number of any kind, such as '1', 'Ⅻ' or
+// '½'.
+func IsNumber(r rune) bool {
+	return r >= 0 && unicode.IsNumber(r)
+}
+
+// IsSymbol reports whether r is a symbol, such as '+', '$' or '©'.
+func IsSymbol(r rune) bool {
+	return r >= 0 && unicode.IsSymbol(r)
+}
+
+// NewRuneClassMatch returns a rule that matches a run of one or more runes
+// for which match is true, as in NewRuneClassMatch(IsSymbol).
+func NewRuneClassMatch(match func(r rune) bool) func(r rune) (textlexer.Rule, textlexer.State) {
+	return newCharacterClassMatcher(match, 1, -1)
+}
+
+// UnicodeLetters matches a run of letters in any script.
+func UnicodeLetters(r rune) (textlexer.Rule, textlexer.State) {
+	return NewRuneClassMatch(IsLetter)(r)
+}
+
+// UnicodeDigits matches a run of decimal digits in any script.
+func UnicodeDigits(r rune) (textlexer.Rule, textlexer.State) {
+	return NewRuneClassMatch(IsDigit)(r)
+}
+
+// InUnicodeTable returns a rule that matches a single rune from t, as in
+// unicode.Han or EmojiTable.
+func InUnicodeTable(t *unicode.RangeTable) func(r rune) (textlexer.Rule, textlexer.State) {
+	return func(r rune) (textlexer.Rule, textlexer.State) {
+		if !textlexer.IsEOF(r) && unicode.Is(t, r) {
+			return Accept, textlexer.StateContinue
+		}
+
+		return nil, textlexer.StateReject
+	}
+}
+
+// Emoji matches a single rune from EmojiTable.
+func Emoji(r rune) (textlexer.Rule, textlexer.State) {
+	return InUnicodeTable(EmojiTable)(r)
+}
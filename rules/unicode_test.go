@@ -0,0 +1,110 @@
+package rules_test
+
+import (
+	"testing"
+	"unicode"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xiam/textlexer"
+	"github.com/xiam/textlexer/rules"
+)
+
+func TestInUnicodeTable(t *testing.T) {
+	t.Run("han", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"漢", []string{"漢"}},
+			{"漢字", []string{"漢", "字"}},
+			{"a漢b", []string{"漢"}},
+			{"かな", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.InUnicodeTable(unicode.Han))
+	})
+
+	t.Run("combined with repeat", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"日本語 text", []string{"日本語"}},
+			{"abc", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Repeat(rules.InUnicodeTable(unicode.Han), 1, -1))
+	})
+
+	t.Run("emoji", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"😀", []string{"😀"}},
+			{"a🚀b", []string{"🚀"}},
+			{"🌍🤖🥳🫠", []string{"🌍", "🤖", "🥳", "🫠"}},
+			{"☀✂", []string{"☀", "✂"}},
+			{"🇦", []string{"🇦"}},
+			{"abc 123", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.Emoji)
+	})
+}
+
+func TestPredicates(t *testing.T) {
+	assert.True(t, rules.IsLetter('a'))
+	assert.True(t, rules.IsLetter('é'))
+	assert.True(t, rules.IsLetter('漢'))
+	assert.False(t, rules.IsLetter('1'))
+	assert.False(t, rules.IsLetter(textlexer.RuneEOF))
+
+	assert.True(t, rules.IsDigit('1'))
+	assert.True(t, rules.IsDigit('١'))
+	assert.False(t, rules.IsDigit('½'))
+
+	assert.True(t, rules.IsLetterOrDigit('x'))
+	assert.True(t, rules.IsLetterOrDigit('7'))
+	assert.False(t, rules.IsLetterOrDigit('_'))
+
+	assert.True(t, rules.IsSpace(' '))
+	assert.True(t, rules.IsSpace('\n'))
+	assert.False(t, rules.IsSpace(textlexer.RuneEOF))
+
+	assert.True(t, rules.IsMark('\u0301'))
+	assert.False(t, rules.IsMark('e'))
+
+	assert.True(t, rules.IsNumber('½'))
+	assert.True(t, rules.IsNumber('Ⅻ'))
+	assert.False(t, rules.IsNumber('x'))
+
+	assert.True(t, rules.IsSymbol('+'))
+	assert.True(t, rules.IsSymbol('©'))
+	assert.False(t, rules.IsSymbol(','))
+}
+
+func TestRuneClassMatch(t *testing.T) {
+	t.Run("letters", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"", nil},
+			{"café 漢字", []string{"café", "漢字"}},
+			{"a1b", []string{"a", "b"}},
+			{"123", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.UnicodeLetters)
+	})
+
+	t.Run("digits", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"12 ١٢٣", []string{"12", "١٢٣"}},
+			{"½", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.UnicodeDigits)
+	})
+
+	t.Run("symbols", func(t *testing.T) {
+		testCases := []inputAndMatchesCase{
+			{"a+=b $5 ©", []string{"+=", "$", "©"}},
+			{"a, b", nil},
+		}
+
+		runTestInputAndMatches(t, testCases, rules.NewRuneClassMatch(rules.IsSymbol))
+	})
+}
@@ -0,0 +1,9 @@
+package textlexer
+
+type RuleDef struct {
+	Type LexemeType
+	Rule Rule
+}
+
+// RuleSet is an ordered list of rules.
+type RuleSet []RuleDef
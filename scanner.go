@@ -1,5 +1,14 @@
 package textlexer
 
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidState is returned by Next when a rule returns a state that is
+// not one of the states defined here.
+var ErrInvalidState = errors.New("invalid state")
+
 type State uint
 
 const (
@@ -7,8 +16,33 @@ const (
 
 	StateAccept
 	StateReject
+
+	// StatePushBack gives back the last rune the rule consumed. The returned
+	// rule is called again with the same rune, so a rule can push back several
+	// runes in a row before accepting a match shorter than what it has read.
+	// Pushing back more runes than were consumed and accepting is an empty
+	// match, which the lexer does not take.
+	StatePushBack
 )
 
+func (s State) String() string {
+	switch s {
+	case StateContinue:
+		return "CONTINUE"
+	case StateAccept:
+		return "ACCEPT"
+	case StateReject:
+		return "REJECT"
+	case StatePushBack:
+		return "PUSHBACK"
+	}
+	return fmt.Sprintf("State(%d)", uint(s))
+}
+
+func (s State) valid() bool {
+	return s <= StatePushBack
+}
+
 type Rule func(r rune) (next Rule, state State)
 
 const RuneEOF = -1
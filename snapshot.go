@@ -0,0 +1,65 @@
+package textlexer
+
+import (
+	"fmt"
+	"io"
+)
+
+// Snapshot holds the position of a lexer, see TextLexer.Snapshot.
+type Snapshot struct {
+	offset     int
+	byteOffset int
+	line, col  int
+	last       rune
+	indents    []int
+
+	prev     *Lexeme
+	pending  []*Lexeme
+	buffered []rune
+}
+
+// Snapshot returns the current position of the lexer, to go back to it later
+// with Restore, as when trying a production that may fail.
+func (lx *TextLexer) Snapshot() Snapshot {
+	lx.mu.Lock()
+	defer lx.mu.Unlock()
+
+	return Snapshot{
+		offset:     lx.offset,
+		byteOffset: lx.byteOffset,
+		line:       lx.line,
+		col:        lx.col,
+		last:       lx.last,
+		indents:    lx.indents,
+
+		prev:     lx.prev,
+		pending:  append([]*Lexeme(nil), lx.pending...),
+		buffered: append([]rune(nil), lx.buffered...),
+	}
+}
+
+// Restore moves the lexer back to a position returned by Snapshot, so Next
+// produces the same lexemes again. The input is read again from there, so
+// the reader must still be able to seek back to it, which is not the case
+// for readers that discard what was read. Counts returned by TypeCounts are
+// not rolled back.
+func (lx *TextLexer) Restore(s Snapshot) error {
+	lx.mu.Lock()
+	defer lx.mu.Unlock()
+
+	if err := lx.seek(int64(s.byteOffset), io.SeekStart); err != nil {
+		return fmt.Errorf("restore: %v", err)
+	}
+
+	lx.offset = s.offset
+	lx.byteOffset = s.byteOffset
+	lx.line, lx.col = s.line, s.col
+	lx.last = s.last
+	lx.indents = s.indents
+
+	lx.prev = s.prev
+	lx.pending = append([]*Lexeme(nil), s.pending...)
+	lx.buffered = append([]rune(nil), s.buffered...)
+
+	return nil
+}
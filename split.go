@@ -0,0 +1,68 @@
+package textlexer
+
+import "slices"
+
+type splitter struct {
+	split func(text []rune) []int
+	types []LexemeType
+
+	// used instead of split and types by AddCompoundRule
+	compound func(text []rune) []Lexeme
+}
+
+// parts returns the lengths and the types of the parts of text, or nil if
+// they don't cover text.
+func (sp splitter) parts(text []rune) ([]int, []LexemeType) {
+	if sp.compound != nil {
+		return sp.compoundParts(text)
+	}
+
+	parts := sp.split(text)
+	if len(parts) != len(sp.types) {
+		return nil, nil
+	}
+
+	total := 0
+	for _, n := range parts {
+		if n < 0 {
+			return nil, nil
+		}
+		total += n
+	}
+
+	if total != len(text) {
+		return nil, nil
+	}
+
+	return parts, sp.types
+}
+
+// compoundParts returns the lengths and the types of the lexemes text is
+// split into, or nil if their texts don't make up text in order.
+func (sp splitter) compoundParts(text []rune) ([]int, []LexemeType) {
+	lexemes := sp.compound(text)
+	if len(lexemes) == 0 {
+		return nil, nil
+	}
+
+	parts := make([]int, 0, len(lexemes))
+	types := make([]LexemeType, 0, len(lexemes))
+
+	total := 0
+	for _, lex := range lexemes {
+		n := len(lex.text)
+		if total+n > len(text) || !slices.Equal(lex.text, text[total:total+n]) {
+			return nil, nil
+		}
+
+		parts = append(parts, n)
+		types = append(types, lex.Type)
+		total += n
+	}
+
+	if total != len(text) {
+		return nil, nil
+	}
+
+	return parts, types
+}
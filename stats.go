@@ -0,0 +1,53 @@
+package textlexer
+
+// RuleStats counts what a rule did, see WithStats.
+type RuleStats struct {
+	// lexemes the rule produced
+	Matches int
+	// runes in the lexemes the rule produced
+	TotalRunes int
+	// lexemes the rule was tried on
+	Activations int
+}
+
+// Stats returns the counters of each rule that was tried at least once. It
+// returns nil unless the lexer was created with WithStats.
+func (lx *TextLexer) Stats() map[LexemeType]RuleStats {
+	lx.mu.Lock()
+	defer lx.mu.Unlock()
+
+	if lx.stats == nil {
+		return nil
+	}
+
+	stats := make(map[LexemeType]RuleStats, len(lx.stats))
+	for lexType, s := range lx.stats {
+		stats[lexType] = *s
+	}
+
+	return stats
+}
+
+func (lx *TextLexer) ruleStats(lexType LexemeType) *RuleStats {
+	s := lx.stats[lexType]
+	if s == nil {
+		s = &RuleStats{}
+		lx.stats[lexType] = s
+	}
+
+	return s
+}
+
+func (lx *TextLexer) copyStats() map[LexemeType]*RuleStats {
+	if lx.stats == nil {
+		return nil
+	}
+
+	stats := make(map[LexemeType]*RuleStats, len(lx.stats))
+	for lexType, s := range lx.stats {
+		c := *s
+		stats[lexType] = &c
+	}
+
+	return stats
+}
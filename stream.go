@@ -0,0 +1,46 @@
+package textlexer
+
+import (
+	"context"
+	"io"
+)
+
+type Result struct {
+	Lexeme *Lexeme
+	Err    error
+}
+
+// Stream lexes the input in a separate goroutine and sends the lexemes to the
+// returned channel, which is closed on EOF, on the first error or when ctx is
+// cancelled, even in the middle of a lexeme. The lexer must not be used by
+// anyone else while streaming.
+func (lx *TextLexer) Stream(ctx context.Context) <-chan Result {
+	ch := make(chan Result)
+
+	go func() {
+		defer close(ch)
+
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+
+			lex, err := lx.NextContext(ctx)
+			if err == io.EOF || ctx.Err() != nil {
+				return
+			}
+
+			select {
+			case ch <- Result{Lexeme: lex, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,127 @@
+package textlexer
+
+import (
+	"errors"
+	"io"
+)
+
+// Symbol is a rune along with its position in the input. Lines and columns
+// are zero-based, columns are counted in runes.
+type Symbol struct {
+	Rune rune
+
+	Offset int
+	Line   int
+	Col    int
+
+	// Prev is the rune right before this one, RuneEOF at the start of the
+	// input.
+	Prev rune
+}
+
+// IsBOF tells whether the symbol is the first one of the input.
+func (s Symbol) IsBOF() bool {
+	return s.Offset == 0
+}
+
+// IsBOL tells whether the symbol is the first one of a line.
+func (s Symbol) IsBOL() bool {
+	return s.Col == 0
+}
+
+// IsEOL tells whether the symbol is a line break.
+func (s Symbol) IsEOL() bool {
+	return s.Rune == '\n' || s.Rune == '\r'
+}
+
+func (s Symbol) IsEOF() bool {
+	return IsEOF(s.Rune)
+}
+
+// SymbolRule is a rule that is given the position of each rune along with it.
+type SymbolRule func(s Symbol) (next SymbolRule, state State)
+
+// SymbolRuleFunc turns a rule that only looks at runes into a SymbolRule, so
+// it can be combined with rules that look at positions.
+func SymbolRuleFunc(rule Rule) SymbolRule {
+	return func(s Symbol) (SymbolRule, State) {
+		next, state := rule(s.Rune)
+		if next == nil {
+			return nil, state
+		}
+
+		return SymbolRuleFunc(next), state
+	}
+}
+
+// symbolRule turns a SymbolRule into a Rule, s holds the position of the next
+// rune and index its position in the input given to NewFromSymbols, if any.
+func symbolRule(rule SymbolRule, s Symbol, index int, symbols []Symbol) Rule {
+	return func(r rune) (Rule, State) {
+		sym := s
+		sym.Rune = r
+		if index < len(symbols) {
+			sym = symbols[index]
+		}
+
+		next, state := rule(sym)
+		if next == nil {
+			return nil, state
+		}
+
+		if state == StatePushBack {
+			// the same rune is given again
+			return symbolRule(next, s, index, symbols), state
+		}
+
+		s.Offset++
+		s.Col++
+		s.Prev = r
+		if r == '\n' {
+			s.Line++
+			s.Col = 0
+		}
+
+		return symbolRule(next, s, index+1, symbols), state
+	}
+}
+
+// symbolReader reads the runes of a list of symbols, each symbol takes one
+// byte of input.
+type symbolReader struct {
+	symbols []Symbol
+	pos     int
+}
+
+func (sr *symbolReader) ReadRune() (rune, int, error) {
+	if sr.pos >= len(sr.symbols) {
+		return 0, 0, io.EOF
+	}
+
+	r := sr.symbols[sr.pos].Rune
+	sr.pos++
+
+	return r, 1, nil
+}
+
+func (sr *symbolReader) Seek(offset int64, whence int) (int64, error) {
+	pos := int64(sr.pos)
+
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos += offset
+	case io.SeekEnd:
+		pos = int64(len(sr.symbols)) + offset
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+
+	if pos < 0 {
+		return 0, errors.New("seek: negative position")
+	}
+
+	sr.pos = int(pos)
+	return pos, nil
+}
@@ -1,150 +1,1506 @@
 package textlexer
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"unicode/utf8"
+	"unsafe"
 )
 
+const (
+	// a lexeme is copied out of the read buffer when the buffer is larger
+	// than bufCompactMin runes and bufCompactFactor times the lexeme
+	bufCompactMin    = 1024
+	bufCompactFactor = 4
+)
+
+// ErrInputLimitExceeded is returned by Next once the lexer reaches the limit
+// set with WithMaxInput.
+var ErrInputLimitExceeded = errors.New("input limit exceeded")
+
 type Reader interface {
 	io.RuneReader
 	io.Seeker
 }
 
 type TextLexer struct {
-	r Reader
+	r  Reader
+	mu sync.Mutex
+
+	offset     int
+	byteOffset int
+	line, col  int
 
-	offset int
+	// positions of the lexeme boundaries produced so far sorted by offset,
+	// only kept when the input can be revisited
+	boundaries []boundary
 
 	rules    []LexemeType
-	rulesMu  sync.Mutex
+	rulesMu  sync.RWMutex
 	rulesMap map[LexemeType]Rule
+
+	classifiers map[LexemeType]func(text []rune) LexemeType
+	splitters   map[LexemeType]splitter
+
+	// rules that depend on the previous lexeme or on the position
+	contextual map[LexemeType]bool
+
+	// runes each rule may start with, rules without an entry may start with
+	// any rune
+	firstRunes map[LexemeType]map[rune]bool
+
+	// rules to try on lexemes starting with a given rune
+	dispatch    map[rune][]LexemeType
+	dispatchGen int
+
+	probeFirstRunes bool
+	mergeUnknown    bool
+
+	priorities map[LexemeType]int
+
+	maxRules int
+
+	// rule counters, only kept with WithStats
+	stats map[LexemeType]*RuleStats
+
+	typeCounts map[LexemeType]int
+
+	// last lexeme returned by Next
+	prev *Lexeme
+
+	// input read past the last lexeme
+	buffered []rune
+
+	// parts of a split lexeme that are yet to be returned
+	pending []*Lexeme
+
+	// error that came along with the last rune read
+	readErr error
+
+	// input given to NewFromSymbols
+	symbols []Symbol
+
+	// input given to NewFromString or NewFromBytes, with WithZeroCopy
+	source    string
+	hasSource bool
+	zeroCopy  bool
+
+	// see WithTextTransform
+	transform func(text []rune) []rune
+
+	acceptInconclusiveAtEOF bool
+	skipWhitespace          bool
+	normalizeNewlines       bool
+	maxPushback             int
+	graphemeColumns         bool
+	tabWidth                int
+	strictStates            bool
+	maxReadAhead            int
+	maxInput                int
+	invalidUTF8             InvalidUTF8Policy
+	stripBOM                bool
+	lineContinuation        rune
+
+	// lines joined before the rune at each byte offset, with
+	// WithLineContinuation
+	continuations map[int]continuation
+
+	// indentation levels of the enclosing blocks, with WithIndentation
+	indentation bool
+	indents     []int
+
+	// rune right before the current position, RuneEOF at the start of the
+	// input
+	last rune
+
+	tracer io.Writer
+}
+
+type boundary struct {
+	offset     int
+	byteOffset int
+	line, col  int
+	last       rune
+	indents    []int
+
+	// lexeme produced right before the boundary
+	prev *Lexeme
+
+	// runes of joined lines between the position and offset
+	skipped int
 }
 
-func New(r Reader) *TextLexer {
-	return &TextLexer{
+func New(r Reader, opts ...Option) *TextLexer {
+	lx := &TextLexer{
 		r:        r,
 		rules:    []LexemeType{},
 		rulesMap: map[LexemeType]Rule{},
+
+		classifiers: map[LexemeType]func(text []rune) LexemeType{},
+		splitters:   map[LexemeType]splitter{},
+		contextual:  map[LexemeType]bool{},
+		firstRunes:  map[LexemeType]map[rune]bool{},
+		dispatch:    map[rune][]LexemeType{},
+		priorities:  map[LexemeType]int{},
+
+		typeCounts: map[LexemeType]int{},
+
+		continuations: map[int]continuation{},
+
+		last: RuneEOF,
+	}
+
+	for _, opt := range opts {
+		opt(lx)
+	}
+
+	return lx
+}
+
+func NewFromString(s string, opts ...Option) *TextLexer {
+	lx := New(strings.NewReader(s), opts...)
+	lx.boundaries = []boundary{{last: RuneEOF}}
+	if lx.zeroCopy {
+		lx.source, lx.hasSource = s, true
+	}
+	return lx
+}
+
+// NewFromBytes creates a lexer that reads b. With WithZeroCopy, the text of
+// the lexemes points into b, so b must not be modified while they are in use.
+func NewFromBytes(b []byte, opts ...Option) *TextLexer {
+	lx := New(bytes.NewReader(b), opts...)
+	lx.boundaries = []boundary{{last: RuneEOF}}
+	if lx.zeroCopy {
+		lx.source, lx.hasSource = unsafe.String(unsafe.SliceData(b), len(b)), true
 	}
+	return lx
+}
+
+// NewFromReaders creates a lexer that reads rs one after the other, as if they
+// were a single input, so lexemes can span from one reader to the next.
+// Offsets keep counting across readers. What is read is kept in memory, so
+// the readers do not need to support seeking and SeekTo can be used.
+func NewFromReaders(rs []io.RuneReader, opts ...Option) *TextLexer {
+	lx := New(newMultiReader(rs), opts...)
+	lx.boundaries = []boundary{{last: RuneEOF}}
+	return lx
 }
 
+// NewFromSymbols creates a lexer that reads the runes of the given symbols.
+// Rules added with AddSymbolRule are given the symbols as they are, so their
+// positions (and Prev) can be set by the caller. The input ends after the last symbol.
+func NewFromSymbols(symbols []Symbol, opts ...Option) *TextLexer {
+	lx := New(&symbolReader{symbols: symbols}, opts...)
+	lx.symbols = symbols
+	lx.boundaries = []boundary{{last: RuneEOF}}
+	return lx
+}
+
+// ContextRule is a rule that is also given the lexeme that was produced right
+// before the one being matched, or nil at the start of the input.
+type ContextRule func(prev *Lexeme, r rune) (Rule, State)
+
 func (lx *TextLexer) AddRule(lexType LexemeType, lexRule Rule) error {
+	return lx.AddRules(RuleSet{{lexType, lexRule}})
+}
+
+// AddRules adds all the rules in rs, in order. If any of them can't be added
+// none is. Rules can be added while another goroutine calls Next, a lexeme
+// that is being matched is only matched by the rules that were there when it
+// started, the new rules are tried from the next lexeme on.
+func (lx *TextLexer) AddRules(rs RuleSet) error {
+	return lx.addRules(rs, nil)
+}
+
+// addRules adds the rules in rs and calls setup, if given, before the rules
+// can be seen by Next.
+func (lx *TextLexer) addRules(rs RuleSet, setup func()) error {
 	lx.rulesMu.Lock()
 	defer lx.rulesMu.Unlock()
 
-	if _, ok := lx.rulesMap[lexType]; ok {
-		return fmt.Errorf("rule %q already exists", lexType)
+	added := make(map[LexemeType]bool, len(rs))
+	for i, def := range rs {
+		if _, ok := lx.rulesMap[def.Type]; ok || added[def.Type] {
+			return fmt.Errorf("rule %q already exists", def.Type)
+		}
+
+		if lx.maxRules > 0 && len(lx.rules)+i >= lx.maxRules {
+			return fmt.Errorf("rule %q exceeds the limit of %d rules", def.Type, lx.maxRules)
+		}
+
+		added[def.Type] = true
+	}
+
+	for _, def := range rs {
+		lx.rulesMap[def.Type] = def.Rule
+		lx.rules = append(lx.rules, def.Type)
+	}
+
+	if setup != nil {
+		setup()
 	}
+	lx.resetDispatch()
 
-	lx.rulesMap[lexType] = lexRule
-	lx.rules = append(lx.rules, lexType)
 	return nil
 }
 
+func (lx *TextLexer) resetDispatch() {
+	lx.dispatch = map[rune][]LexemeType{}
+	lx.dispatchGen++
+}
+
+// AddRuleWithContext adds a rule that can look at the previous lexeme to decide
+// whether to match, the previous lexeme must not be modified.
+func (lx *TextLexer) AddRuleWithContext(lexType LexemeType, lexRule ContextRule) error {
+	rule := func(r rune) (Rule, State) {
+		return lexRule(lx.prev, r)
+	}
+
+	return lx.addRules(RuleSet{{lexType, rule}}, func() {
+		lx.contextual[lexType] = true
+	})
+}
+
+// AddSymbolRule adds a rule that is given the position of each rune, so it can
+// match depending on where the lexeme is, such as at the start of a line.
+func (lx *TextLexer) AddSymbolRule(lexType LexemeType, lexRule SymbolRule) error {
+	rule := func(r rune) (Rule, State) {
+		start := Symbol{Offset: lx.offset, Line: lx.line, Col: lx.col, Prev: lx.last}
+		return symbolRule(lexRule, start, lx.byteOffset, lx.symbols)(r)
+	}
+
+	return lx.addRules(RuleSet{{lexType, rule}}, func() {
+		lx.contextual[lexType] = true
+	})
+}
+
+// AddRuleWithPriority adds a rule that wins over rules with a lower priority
+// when both match lexemes of the same length. Rules added with AddRule have
+// priority 0, ties between rules with the same priority go to the rule added
+// last.
+func (lx *TextLexer) AddRuleWithPriority(lexType LexemeType, lexRule Rule, priority int) error {
+	return lx.addRules(RuleSet{{lexType, lexRule}}, func() {
+		lx.priorities[lexType] = priority
+	})
+}
+
+// AddRuleWithFirstRunes adds a rule that is only tried on lexemes starting
+// with one of the runes in firstRunes.
+func (lx *TextLexer) AddRuleWithFirstRunes(lexType LexemeType, lexRule Rule, firstRunes []rune) error {
+	runes := make(map[rune]bool, len(firstRunes))
+	for _, r := range firstRunes {
+		runes[r] = true
+	}
+
+	return lx.addRules(RuleSet{{lexType, lexRule}}, func() {
+		lx.firstRunes[lexType] = runes
+	})
+}
+
+// AddClassifyingRule adds a rule whose matches are given the type returned by
+// classify, or defaultType if classify returns an empty type.
+func (lx *TextLexer) AddClassifyingRule(defaultType LexemeType, lexRule Rule, classify func(text []rune) LexemeType) error {
+	return lx.addRules(RuleSet{{defaultType, lexRule}}, func() {
+		lx.classifiers[defaultType] = classify
+	})
+}
+
+// AddSplitRule adds a rule whose matches are split into several lexemes, one
+// for each of the given types. split is given the text of a match and returns
+// the length of each part, parts with an empty type are dropped. If the
+// lengths do not add up to the length of the match, or there is not one for
+// each type, the match is returned as a single lexeme of type lexType.
+func (lx *TextLexer) AddSplitRule(lexType LexemeType, lexRule Rule, split func(text []rune) []int, types ...LexemeType) error {
+	return lx.addRules(RuleSet{{lexType, lexRule}}, func() {
+		lx.splitters[lexType] = splitter{split: split, types: types}
+	})
+}
+
+// AddCompoundRule adds a rule whose matches are made of several lexemes, such
+// as qualified names like "a.b.c". split is given the text of a match and
+// returns the lexemes it is made of, which Next returns one at a time with
+// their positions set. Only the type and the text of the returned lexemes are
+// used, lexemes with an empty type are dropped. If their texts do not make up
+// the match, the match is returned as a single lexeme of type lexType.
+func (lx *TextLexer) AddCompoundRule(lexType LexemeType, lexRule Rule, split func(text []rune) []Lexeme) error {
+	return lx.addRules(RuleSet{{lexType, lexRule}}, func() {
+		lx.splitters[lexType] = splitter{compound: split}
+	})
+}
+
 func (lx *TextLexer) MustAddRule(lexType LexemeType, lexRule Rule) {
 	if err := lx.AddRule(lexType, lexRule); err != nil {
 		panic(fmt.Sprintf("MustAddRule: %v", err))
 	}
 }
 
+// Rules returns the types of the registered rules, in the order they were
+// added.
+func (lx *TextLexer) Rules() []LexemeType {
+	lx.rulesMu.RLock()
+	defer lx.rulesMu.RUnlock()
+
+	rules := make([]LexemeType, len(lx.rules))
+	copy(rules, lx.rules)
+
+	return rules
+}
+
+func (lx *TextLexer) HasRule(lexType LexemeType) bool {
+	lx.rulesMu.RLock()
+	defer lx.rulesMu.RUnlock()
+
+	_, ok := lx.rulesMap[lexType]
+	return ok
+}
+
+// SeekTo moves the lexer back (or forward) to the given rune offset, which must
+// be the start or the end of a lexeme already produced. Rules added with
+// AddRuleWithContext see the lexeme that was produced before that offset.
+// Only lexers created with NewFromString, NewFromBytes, NewFromReaders or
+// NewFromSymbols support seeking.
+func (lx *TextLexer) SeekTo(offset int) error {
+	lx.mu.Lock()
+	defer lx.mu.Unlock()
+
+	if lx.boundaries == nil {
+		return errors.New("seek: input does not support seeking")
+	}
+
+	i, ok := lx.findBoundary(offset)
+	if !ok {
+		return fmt.Errorf("seek: offset %d is not at a lexeme boundary", offset)
+	}
+	b := lx.boundaries[i]
+
+	if err := lx.seek(int64(b.byteOffset), io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %v", err)
+	}
+
+	lx.offset = offset - b.skipped
+	lx.byteOffset = b.byteOffset
+	lx.line, lx.col = b.line, b.col
+	lx.last = b.last
+	lx.indents = b.indents
+	lx.prev = b.prev
+	lx.pending = nil
+
+	return nil
+}
+
 func (lx *TextLexer) Next() (*Lexeme, error) {
-	scanners := map[LexemeType]Rule{}
+	return lx.NextContext(context.Background())
+}
 
-	lx.rulesMu.Lock()
-	for _, lexType := range lx.rules {
-		scanners[lexType] = lx.rulesMap[lexType]
+// NextContext works like Next but gives up when ctx is done, returning
+// ctx.Err(). The lexer is left at the start of the lexeme it was matching, so
+// it can be called again.
+func (lx *TextLexer) NextContext(ctx context.Context) (*Lexeme, error) {
+	lx.mu.Lock()
+	defer lx.mu.Unlock()
+
+	if len(lx.pending) > 0 {
+		lex := lx.pending[0]
+		lx.pending = lx.pending[1:]
+
+		lx.transformText(lex)
+		lx.typeCounts[lex.Type]++
+		lx.prev = lex
+
+		return lex, nil
+	}
+
+	lex, err := lx.next(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if lx.mergeUnknown && lex.Type == LexemeTypeUnknown {
+		if err := lx.mergeUnknownRun(ctx, lex); err != nil {
+			return nil, err
+		}
+	}
+
+	lx.transformText(lex)
+	lx.typeCounts[lex.Type]++
+	lx.prev = lex
+
+	return lex, nil
+}
+
+// mergeUnknownRun appends the UNKNOWN lexemes that follow to lex.
+func (lx *TextLexer) mergeUnknownRun(ctx context.Context, lex *Lexeme) error {
+	for {
+		offset, byteOffset := lx.offset, lx.byteOffset
+		line, col, last := lx.line, lx.col, lx.last
+		indents := lx.indents
+		stats := lx.copyStats()
+
+		next, err := lx.next(ctx)
+		if err == nil && next.Type == LexemeTypeUnknown {
+			lex.text = append(lex.text[:len(lex.text):len(lex.text)], next.text...)
+			lex.end = next.end
+			if next.unknownReason == UnknownReasonNoMatch {
+				lex.unknownReason = UnknownReasonNoMatch
+			}
+			continue
+		}
+
+		// boundaries inside the merged lexeme and past it were never the
+		// start or the end of a lexeme that was returned
+		lx.dropBoundaries(lex.offset, lx.offset)
+
+		// whatever comes next is read again by the next call
+		lx.offset, lx.byteOffset = offset, byteOffset
+		lx.line, lx.col, lx.last = line, col, last
+		lx.indents = indents
+		lx.stats = stats
+		lx.pending = nil
+
+		if err := lx.seek(int64(byteOffset), io.SeekStart); err != nil {
+			return fmt.Errorf("seek: %v", err)
+		}
+
+		lx.recordBoundary(lex)
+
+		return nil
+	}
+}
+
+// Flush returns the input left to read as a single UNKNOWN lexeme and moves
+// the lexer past it. After Next returns io.EOF, this is the trailing input no
+// rule could complete, as an unterminated comment. Flush returns io.EOF if
+// there is no input left.
+func (lx *TextLexer) Flush() (*Lexeme, error) {
+	lx.mu.Lock()
+	defer lx.mu.Unlock()
+
+	if err := lx.skipBOM(); err != nil {
+		return nil, err
+	}
+
+	if err := lx.seek(int64(lx.byteOffset), io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek: %v", err)
+	}
+
+	var text []rune
+	var sizes []int
+	for {
+		r, size, err := lx.readRune()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("read error: %w", err)
+		}
+
+		if err := lx.checkInputLimit(len(text)); err != nil {
+			return nil, err
+		}
+
+		text = append(text, r)
+		sizes = append(sizes, size)
+	}
+
+	if len(text) == 0 {
+		return nil, io.EOF
+	}
+
+	lex := lx.newLexeme(LexemeTypeUnknown, text)
+	lex.unknownReason = UnknownReasonNoMatch
+
+	start := lx.byteOffset
+	if err := lx.advance(text, sizes, lex); err != nil {
+		return nil, err
+	}
+	lx.attachSource(lex, start)
+	lx.buffered = nil
+
+	lx.transformText(lex)
+	lx.typeCounts[lex.Type]++
+	lx.prev = lex
+
+	return lex, nil
+}
+
+// Position returns the rune offset, the line and the column where the next
+// lexeme starts. Lines and columns are zero-based.
+func (lx *TextLexer) Position() (offset, line, col int) {
+	lx.mu.Lock()
+	defer lx.mu.Unlock()
+
+	return lx.offset, lx.line, lx.col
+}
+
+// Buffered returns the input that was read past the last lexeme while looking
+// for it, and that will be read again by the next call to Next. After Next
+// returns io.EOF it holds the trailing input that no rule could complete.
+func (lx *TextLexer) Buffered() string {
+	lx.mu.Lock()
+	defer lx.mu.Unlock()
+
+	return string(lx.buffered)
+}
+
+// TypeCounts returns how many lexemes of each type were produced so far.
+func (lx *TextLexer) TypeCounts() map[LexemeType]int {
+	lx.mu.Lock()
+	defer lx.mu.Unlock()
+
+	counts := make(map[LexemeType]int, len(lx.typeCounts))
+	for lexType, n := range lx.typeCounts {
+		counts[lexType] = n
+	}
+
+	return counts
+}
+
+func (lx *TextLexer) next(ctx context.Context) (*Lexeme, error) {
+	if err := lx.skipBOM(); err != nil {
+		return nil, err
+	}
+
+	if lx.skipWhitespace {
+		if err := lx.skipLeadingWhitespace(); err != nil {
+			return nil, err
+		}
+	}
+
+	if lx.indentation {
+		lex, err := lx.indent()
+		if err != nil || lex != nil {
+			return lex, err
+		}
+	}
+
+	// the lexeme starts here, past any input that was skipped
+	lx.recordBoundary(lx.prev)
+
+	if lexType, rule, ok := lx.singleRule(); ok {
+		return lx.nextSingle(ctx, lexType, rule)
 	}
-	lx.rulesMu.Unlock()
+
+	// set after reading the first rune
+	var scanners map[LexemeType]Rule
+	var ruleTypes []LexemeType
+
+	// rules that did not reject the first rune, when probing them
+	var candidates []LexemeType
+	var dispatchGen int
 
 	var lastLexeme *Lexeme
 	var isEOF bool
 
 	var buf []rune
+	var sizes []int
+
+	// whether any rule got past the first rune
+	started := false
+
+	pushBacks := map[LexemeType]int{}
+
+	accept := func(lexType LexemeType, n int) {
+		if n < 1 {
+			// pushed back the whole match
+			return
+		}
+
+		if lastLexeme != nil {
+			if n < len(lastLexeme.text) {
+				// a longer match was already found
+				return
+			}
+
+			if n == len(lastLexeme.text) && lx.priority(lexType) < lx.priority(lastLexeme.Type) {
+				// ties go to the rule with the highest priority
+				return
+			}
+		}
+
+		lastLexeme = lx.newLexeme(lexType, buf[:n])
+	}
 
 	offset := 0
 	for {
-
-		r, _, err := lx.r.ReadRune()
-		if err != nil && err != io.EOF {
-			return nil, fmt.Errorf("read error: %v", err)
+		r, size, err := lx.scanRune(ctx, offset)
+		if err != nil {
+			return nil, err
 		}
 
-		isEOF = err == io.EOF
-		if isEOF {
-			r = RuneEOF
+		isEOF = r == RuneEOF
+		if len(buf) == 0 && isEOF {
+			return lx.endOfInput()
 		}
 
-		if len(buf) == 0 && r == RuneEOF {
-			return nil, io.EOF
+		buf = append(buf, r)
+		sizes = append(sizes, size)
+
+		if offset == 0 {
+			var probe bool
+			ruleTypes, probe, dispatchGen = lx.firstRuneRules(r)
+			if probe {
+				candidates = []LexemeType{}
+			}
+
+			scanners = make(map[LexemeType]Rule, len(ruleTypes))
+
+			lx.rulesMu.RLock()
+			for _, lexType := range ruleTypes {
+				scanners[lexType] = lx.rulesMap[lexType]
+			}
+			lx.rulesMu.RUnlock()
+
+			if lx.stats != nil {
+				for _, lexType := range ruleTypes {
+					lx.ruleStats(lexType).Activations++
+				}
+			}
 		}
 
-		for _, lexType := range lx.rules {
+		for _, lexType := range ruleTypes {
 			scanner := scanners[lexType]
 			if scanner == nil {
 				continue
 			}
 
 			next, state := scanner(r)
+			lx.trace(r, lexType, state)
+			for state == StatePushBack && next != nil {
+				pushBacks[lexType]++
+				if lx.maxPushback > 0 && pushBacks[lexType] > lx.maxPushback {
+					next, state = nil, StateReject
+					break
+				}
+				next, state = next(r)
+				lx.trace(r, lexType, state)
+			}
+
+			if !state.valid() {
+				return nil, lx.invalidState(lexType, state)
+			}
+
 			scanners[lexType] = next
 
-			if state == StateReject {
+			if state == StateContinue && offset == 0 {
+				started = true
+			}
+
+			if candidates != nil && offset == 0 {
+				lx.rulesMu.RLock()
+				contextual := lx.contextual[lexType]
+				lx.rulesMu.RUnlock()
+
+				if contextual || (state != StateReject && state != StatePushBack) {
+					candidates = append(candidates, lexType)
+				}
+			}
+
+			if state == StateReject || state == StatePushBack {
 				delete(scanners, lexType)
 			}
 
 			if state == StateAccept {
 				delete(scanners, lexType)
 
-				if offset > 0 {
-					lastLexeme = &Lexeme{
-						Type:   lexType,
-						text:   buf,
-						offset: lx.offset + offset,
-					}
+				if offset > 0 || pushBacks[lexType] > 0 {
+					accept(lexType, offset-pushBacks[lexType])
 				} else {
-					lastLexeme = &Lexeme{
-						Type:   lexType,
-						text:   []rune{r},
-						offset: lx.offset + 1,
-					}
+					accept(lexType, 1)
 				}
 			}
 		}
 
-		buf = append(buf, r)
+		if isEOF && lx.acceptInconclusiveAtEOF {
+			// rules that are still expecting input accept what they have
+			for _, lexType := range ruleTypes {
+				if scanners[lexType] == nil {
+					continue
+				}
+
+				accept(lexType, offset-pushBacks[lexType])
+			}
+		}
+
+		if candidates != nil && offset == 0 {
+			lx.rulesMu.Lock()
+			if lx.dispatchGen == dispatchGen {
+				lx.dispatch[r] = candidates
+			}
+			lx.rulesMu.Unlock()
+		}
+
 		offset++
 
 		if len(scanners) == 0 || isEOF {
 			// no scanners left
 			break
 		}
+
+		if lx.maxReadAhead > 0 && offset >= lx.maxReadAhead {
+			// give up on the rules that are still running
+			break
+		}
+	}
+
+	return lx.finish(ctx, lastLexeme, buf, sizes, isEOF, started)
+}
+
+// finish moves the lexer past the lexeme found by next, or past what was read
+// as an UNKNOWN lexeme if lastLexeme is nil. started tells whether a rule got
+// past the first rune.
+func (lx *TextLexer) finish(ctx context.Context, lastLexeme *Lexeme, buf []rune, sizes []int, isEOF, started bool) (*Lexeme, error) {
+	// keep what was read past the lexeme
+	rest := buf
+	if lastLexeme != nil {
+		rest = buf[len(lastLexeme.text):]
+	} else if !isEOF {
+		rest = nil
 	}
+	if isEOF && len(rest) > 0 {
+		rest = rest[:len(rest)-1]
+	}
+	lx.buffered = append([]rune(nil), rest...)
 
 	if lastLexeme != nil {
-		lx.offset = lastLexeme.offset
+		if n := len(lastLexeme.text); cap(lastLexeme.text) > bufCompactMin && cap(lastLexeme.text) > n*bufCompactFactor {
+			// the lexeme is much shorter than what was read ahead, copy it so
+			// the read buffer is not kept alive by the lexeme
+			lastLexeme.text = append(make([]rune, 0, n), lastLexeme.text...)
+		}
 
-		if _, err := lx.r.Seek(int64(lx.offset), io.SeekStart); err != nil {
-			return nil, fmt.Errorf("seek: %v", err)
+		if lx.stats != nil {
+			s := lx.ruleStats(lastLexeme.Type)
+			s.Matches++
+			s.TotalRunes += len(lastLexeme.text)
 		}
 
+		lx.rulesMu.RLock()
+		classify := lx.classifiers[lastLexeme.Type]
+		sp, split := lx.splitters[lastLexeme.Type]
+		lx.rulesMu.RUnlock()
+
+		if classify != nil {
+			if lexType := classify(lastLexeme.text); lexType != "" {
+				lastLexeme.Type = lexType
+			}
+		}
+
+		if split {
+			if parts, types := sp.parts(lastLexeme.text); parts != nil {
+				return lx.advanceParts(ctx, lastLexeme.text, sizes, parts, types)
+			}
+		}
+
+		start := lx.byteOffset
+		if err := lx.advance(lastLexeme.text, sizes[:len(lastLexeme.text)], lastLexeme); err != nil {
+			return nil, err
+		}
+		lx.attachSource(lastLexeme, start)
+
 		return lastLexeme, nil
 	}
 
 	if !isEOF {
-		lastLexeme = &Lexeme{
-			Type:   LexemeTypeUnknown,
-			text:   buf,
-			offset: lx.offset + offset,
-		}
+		lastLexeme = lx.newLexeme(LexemeTypeUnknown, buf)
+		lastLexeme.unknownReason = UnknownReasonNoRule
 
-		lx.offset = lastLexeme.offset
+		if started {
+			lastLexeme.unknownReason = UnknownReasonNoMatch
+		}
 
-		if _, err := lx.r.Seek(int64(lx.offset), io.SeekStart); err != nil {
-			return nil, fmt.Errorf("seek: %v", err)
+		start := lx.byteOffset
+		if err := lx.advance(buf, sizes, lastLexeme); err != nil {
+			return nil, err
 		}
+		lx.attachSource(lastLexeme, start)
 
 		return lastLexeme, nil
 	}
 
 	return nil, io.EOF
 }
+
+// singleRule returns the rule of a lexer that has a single rule tried on
+// every rune, which nextSingle runs without the bookkeeping needed to run
+// several rules side by side.
+func (lx *TextLexer) singleRule() (LexemeType, Rule, bool) {
+	lx.rulesMu.RLock()
+	defer lx.rulesMu.RUnlock()
+
+	if len(lx.rules) != 1 || len(lx.firstRunes) != 0 {
+		return "", nil, false
+	}
+
+	lexType := lx.rules[0]
+	return lexType, lx.rulesMap[lexType], true
+}
+
+// nextSingle works like next for a lexer with a single rule.
+func (lx *TextLexer) nextSingle(ctx context.Context, lexType LexemeType, rule Rule) (*Lexeme, error) {
+	var lastLexeme *Lexeme
+	var isEOF bool
+
+	var buf []rune
+	var sizes []int
+
+	started := false
+	// whether the rule has yet to accept or reject
+	running := true
+
+	pushBacks := 0
+
+	accept := func(n int) {
+		if n > 0 {
+			lastLexeme = lx.newLexeme(lexType, buf[:n])
+		}
+	}
+
+	offset := 0
+	for {
+		r, size, err := lx.scanRune(ctx, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		isEOF = r == RuneEOF
+		if len(buf) == 0 && isEOF {
+			return lx.endOfInput()
+		}
+
+		buf = append(buf, r)
+		sizes = append(sizes, size)
+
+		if offset == 0 && lx.stats != nil {
+			lx.ruleStats(lexType).Activations++
+		}
+
+		if rule != nil {
+			next, state := rule(r)
+			lx.trace(r, lexType, state)
+			for state == StatePushBack && next != nil {
+				pushBacks++
+				if lx.maxPushback > 0 && pushBacks > lx.maxPushback {
+					next, state = nil, StateReject
+					break
+				}
+				next, state = next(r)
+				lx.trace(r, lexType, state)
+			}
+
+			if !state.valid() {
+				return nil, lx.invalidState(lexType, state)
+			}
+
+			rule = next
+
+			if state == StateContinue && offset == 0 {
+				started = true
+			}
+
+			switch state {
+			case StateReject, StatePushBack:
+				running = false
+			case StateAccept:
+				running = false
+				if offset > 0 || pushBacks > 0 {
+					accept(offset - pushBacks)
+				} else {
+					accept(1)
+				}
+			}
+		}
+
+		if isEOF && lx.acceptInconclusiveAtEOF && running && rule != nil {
+			accept(offset - pushBacks)
+		}
+
+		offset++
+
+		if !running || isEOF {
+			break
+		}
+
+		if lx.maxReadAhead > 0 && offset >= lx.maxReadAhead {
+			break
+		}
+	}
+
+	return lx.finish(ctx, lastLexeme, buf, sizes, isEOF, started)
+}
+
+// scanRune reads the rune offset runes past the start of the lexeme being
+// matched, it returns RuneEOF at the end of the input.
+func (lx *TextLexer) scanRune(ctx context.Context, offset int) (rune, int, error) {
+	if err := ctx.Err(); err != nil {
+		if err := lx.seek(int64(lx.byteOffset), io.SeekStart); err != nil {
+			return 0, 0, fmt.Errorf("seek: %v", err)
+		}
+		return 0, 0, err
+	}
+
+	r, size, err := lx.readRune()
+	if err == io.EOF {
+		return RuneEOF, size, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("read error: %w", err)
+	}
+
+	if err := lx.checkInputLimit(offset); err != nil {
+		return 0, 0, err
+	}
+
+	return r, size, nil
+}
+
+// endOfInput is what next returns when there is nothing left to read.
+func (lx *TextLexer) endOfInput() (*Lexeme, error) {
+	if len(lx.indents) > 0 {
+		// blocks still open are closed at the end of the input
+		return lx.dedent(0), nil
+	}
+
+	return nil, io.EOF
+}
+
+// invalidState returns the error for a rule that returned a state that is not
+// defined, or panics with WithStrictStates.
+func (lx *TextLexer) invalidState(lexType LexemeType, state State) error {
+	if lx.strictStates {
+		panic(fmt.Sprintf("rule %s returned %v", lexType, state))
+	}
+
+	if err := lx.seek(int64(lx.byteOffset), io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %v", err)
+	}
+
+	return fmt.Errorf("rule %s: %w: %v", lexType, ErrInvalidState, state)
+}
+
+// firstRuneRules returns the rules to try on a lexeme starting with r, and
+// whether they have yet to be probed.
+func (lx *TextLexer) firstRuneRules(r rune) ([]LexemeType, bool, int) {
+	lx.rulesMu.RLock()
+	ruleTypes, ok := lx.dispatch[r]
+	gen := lx.dispatchGen
+	lx.rulesMu.RUnlock()
+
+	if ok {
+		return ruleTypes, false, gen
+	}
+
+	lx.rulesMu.Lock()
+	defer lx.rulesMu.Unlock()
+
+	ruleTypes = make([]LexemeType, 0, len(lx.rules))
+	for _, lexType := range lx.rules {
+		if runes, ok := lx.firstRunes[lexType]; ok && !runes[r] {
+			continue
+		}
+		ruleTypes = append(ruleTypes, lexType)
+	}
+
+	if len(ruleTypes) == len(lx.rules) {
+		// share the list when no rule was left out
+		ruleTypes = lx.rules[:len(lx.rules):len(lx.rules)]
+	}
+
+	if !lx.probeFirstRunes {
+		lx.dispatch[r] = ruleTypes
+	}
+
+	return ruleTypes, lx.probeFirstRunes, lx.dispatchGen
+}
+
+func (lx *TextLexer) priority(lexType LexemeType) int {
+	lx.rulesMu.RLock()
+	defer lx.rulesMu.RUnlock()
+
+	return lx.priorities[lexType]
+}
+
+func (lx *TextLexer) trace(r rune, lexType LexemeType, state State) {
+	if lx.tracer == nil {
+		return
+	}
+
+	input := "EOF"
+	if !IsEOF(r) {
+		input = strconv.QuoteRune(r)
+	}
+
+	fmt.Fprintf(lx.tracer, "%s\t%s\t%s\n", input, lexType, state)
+}
+
+// readRune reads the next rune of the input, joining lines when
+// WithLineContinuation is set. The size of a rune that comes after joined
+// lines includes the escapes and the line breaks.
+func (lx *TextLexer) readRune() (rune, int, error) {
+	r, size, err := lx.readLineRune()
+	if err != nil || lx.lineContinuation == 0 || r != lx.lineContinuation {
+		return r, size, err
+	}
+
+	var joined continuation
+	skipped := 0
+	start := int64(0)
+
+	for err == nil && r == lx.lineContinuation {
+		next, nextSize, nextRunes, nextErr := lx.readLineBreak()
+		if nextErr != nil || next != '\n' {
+			// not a continuation, the escape is returned as is
+			if nextErr == nil {
+				if err := lx.seek(-int64(nextSize), io.SeekCurrent); err != nil {
+					return 0, 0, fmt.Errorf("seek: %v", err)
+				}
+			} else if nextErr != io.EOF {
+				lx.readErr = nextErr
+			}
+			break
+		}
+
+		if joined.lines == 0 {
+			pos, err := lx.r.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return 0, 0, fmt.Errorf("seek: %v", err)
+			}
+			start = pos - int64(size+nextSize)
+		}
+
+		joined.lines++
+		joined.runes += 1 + nextRunes
+		skipped += size + nextSize
+
+		r, size, err = lx.readLineRune()
+	}
+
+	if joined.lines > 0 {
+		lx.continuations[int(start)] = joined
+		size += skipped
+	}
+
+	return r, size, err
+}
+
+// continuation tells how many lines were joined before a rune and how many
+// runes were skipped to join them.
+type continuation struct {
+	lines, runes int
+}
+
+// readLineBreak works like readLineRune, and also reads a "\r\n" as a single
+// "\n" when newlines are not normalized. It returns how many runes were read.
+func (lx *TextLexer) readLineBreak() (rune, int, int, error) {
+	r, size, err := lx.readLineRune()
+	if err != nil || r != '\r' || lx.normalizeNewlines {
+		return r, size, 1, err
+	}
+
+	next, nextSize, err := lx.readRawRune()
+	if err != nil {
+		if err != io.EOF {
+			lx.readErr = err
+		}
+		return r, size, 1, nil
+	}
+
+	if next == '\n' {
+		return '\n', size + nextSize, 2, nil
+	}
+
+	if err := lx.seek(-int64(nextSize), io.SeekCurrent); err != nil {
+		return 0, 0, 0, fmt.Errorf("seek: %v", err)
+	}
+
+	return r, size, 1, nil
+}
+
+// readLineRune reads the next rune of the input, replacing line breaks when
+// NormalizeNewlines is set.
+func (lx *TextLexer) readLineRune() (rune, int, error) {
+	r, size, err := lx.readRawRune()
+	if err != nil || r != '\r' || !lx.normalizeNewlines {
+		return r, size, err
+	}
+
+	// "\r\n" and a lone "\r" become a single "\n"
+	next, nextSize, err := lx.readRawRune()
+	if err != nil {
+		if err != io.EOF {
+			lx.readErr = err
+		}
+		return '\n', size, nil
+	}
+
+	if next == '\n' {
+		return '\n', size + nextSize, nil
+	}
+
+	if err := lx.seek(-int64(nextSize), io.SeekCurrent); err != nil {
+		return 0, 0, fmt.Errorf("seek: %v", err)
+	}
+
+	return '\n', size, nil
+}
+
+// readRawRune reads a rune from the input. A rune returned along with an error
+// is kept and the error is returned on the next read. Invalid UTF-8 is handled
+// as set with WithInvalidUTF8Policy.
+func (lx *TextLexer) readRawRune() (rune, int, error) {
+	if err := lx.readErr; err != nil {
+		lx.readErr = nil
+		return 0, 0, err
+	}
+
+	r, size, err := lx.r.ReadRune()
+
+	skipped := 0
+	for err == nil && r == utf8.RuneError && size == 1 && lx.invalidUTF8 != InvalidUTF8Replace {
+		if lx.invalidUTF8 == InvalidUTF8Error {
+			pos, err := lx.r.Seek(-1, io.SeekCurrent)
+			if err != nil {
+				return 0, 0, fmt.Errorf("seek: %v", err)
+			}
+			return 0, 0, fmt.Errorf("byte %d: %w", pos, ErrInvalidUTF8)
+		}
+
+		// the invalid byte is counted along with the rune that follows it
+		skipped += size
+		r, size, err = lx.r.ReadRune()
+	}
+	if size > 0 {
+		size += skipped
+	}
+
+	if err != nil && size > 0 {
+		lx.readErr = err
+		return r, size, nil
+	}
+
+	return r, size, err
+}
+
+// seek moves the input to the given byte offset, any pending read error is
+// dropped as the input will be read again.
+func (lx *TextLexer) seek(offset int64, whence int) error {
+	lx.readErr = nil
+
+	_, err := lx.r.Seek(offset, whence)
+	return err
+}
+
+// advance moves the lexer past the given runes, sizes holds their length in
+// bytes and prev is the last lexeme produced up to there.
+func (lx *TextLexer) advance(text []rune, sizes []int, prev *Lexeme) error {
+	lx.move(text, sizes)
+	lx.recordBoundary(prev)
+
+	if err := lx.seek(int64(lx.byteOffset), io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %v", err)
+	}
+
+	return nil
+}
+
+// recordBoundary records the current position as a lexeme boundary SeekTo can
+// go back to, prev is the lexeme right before it.
+func (lx *TextLexer) recordBoundary(prev *Lexeme) {
+	if lx.boundaries == nil {
+		return
+	}
+
+	b := boundary{
+		offset:     lx.offset,
+		byteOffset: lx.byteOffset,
+		line:       lx.line,
+		col:        lx.col,
+		last:       lx.last,
+		indents:    lx.indents,
+		prev:       prev,
+	}
+
+	i, ok := lx.findBoundary(lx.offset)
+	if ok {
+		lx.boundaries[i] = b
+		return
+	}
+
+	// boundaries are found in order, unless the input is lexed again after
+	// SeekTo
+	lx.boundaries = slices.Insert(lx.boundaries, i, b)
+}
+
+// dropBoundaries removes the boundaries after start and up to end.
+func (lx *TextLexer) dropBoundaries(start, end int) {
+	i, _ := lx.findBoundary(start + 1)
+	j, _ := lx.findBoundary(end + 1)
+	lx.boundaries = slices.Delete(lx.boundaries, i, j)
+}
+
+// findBoundary returns the index of the boundary at offset, or where it
+// would go if there is none.
+func (lx *TextLexer) findBoundary(offset int) (int, bool) {
+	return slices.BinarySearchFunc(lx.boundaries, offset, func(b boundary, offset int) int {
+		return b.offset - offset
+	})
+}
+
+// newLexeme returns a lexeme that starts at the current position.
+func (lx *TextLexer) newLexeme(lexType LexemeType, text []rune) *Lexeme {
+	lex := &Lexeme{
+		Type:   lexType,
+		text:   text,
+		offset: lx.offset,
+		line:   lx.line,
+		col:    lx.col,
+	}
+
+	if joined, ok := lx.continuations[lx.byteOffset]; ok {
+		// the lexeme starts after joined lines
+		lex.offset += joined.runes
+		lex.line += joined.lines
+		lex.col = 0
+
+		lx.aliasBoundary(lx.offset, lex.offset)
+	}
+
+	return lex
+}
+
+// aliasBoundary makes the boundary at offset reachable from the start of a
+// lexeme that comes after joined lines.
+func (lx *TextLexer) aliasBoundary(offset, start int) {
+	i, ok := lx.findBoundary(offset)
+	if !ok {
+		return
+	}
+
+	b := lx.boundaries[i]
+	b.offset, b.skipped = start, start-offset
+
+	i, ok = lx.findBoundary(start)
+	if ok {
+		lx.boundaries[i] = b
+		return
+	}
+	lx.boundaries = slices.Insert(lx.boundaries, i, b)
+}
+
+// advanceParts moves the lexer past the parts of a split lexeme, it returns
+// the first part and keeps the others to be returned by the next calls.
+func (lx *TextLexer) advanceParts(ctx context.Context, text []rune, sizes []int, parts []int, types []LexemeType) (*Lexeme, error) {
+	var lexemes []*Lexeme
+
+	prev := lx.prev
+
+	start := 0
+	for i, n := range parts {
+		part := text[start : start+n]
+
+		lex := lx.newLexeme(types[i], part)
+		if types[i] != "" && n > 0 {
+			lexemes = append(lexemes, lex)
+			prev = lex
+		}
+
+		byteOffset := lx.byteOffset
+		if err := lx.advance(part, sizes[start:start+n], prev); err != nil {
+			return nil, err
+		}
+		lx.attachSource(lex, byteOffset)
+
+		start += n
+	}
+
+	if len(lexemes) == 0 {
+		// all the parts were dropped
+		return lx.next(ctx)
+	}
+
+	lx.pending = lexemes[1:]
+
+	return lexemes[0], nil
+}
+
+// attachSource makes lex refer to the input between the start byte offset and
+// the current one, when the input is kept with WithZeroCopy.
+func (lx *TextLexer) attachSource(lex *Lexeme, start int) {
+	if !lx.hasSource || lx.normalizeNewlines || lx.lineContinuation != 0 || lx.invalidUTF8 == InvalidUTF8Skip {
+		return
+	}
+
+	if !utf8.ValidString(lx.source[start:lx.byteOffset]) {
+		// invalid bytes were read as U+FFFD
+		return
+	}
+
+	lex.source = lx.source
+	lex.start, lex.end = start, lx.byteOffset
+	lex.hasSource = true
+}
+
+// transformText sets the text lex is returned with, with WithTextTransform.
+// The transform is given a copy of the runes matched, which lex keeps.
+func (lx *TextLexer) transformText(lex *Lexeme) {
+	if lx.transform == nil || len(lex.text) == 0 {
+		return
+	}
+
+	lex.transformed = lx.transform(append([]rune(nil), lex.text...))
+	lex.hasTransformed = true
+}
+
+// move updates the position of the lexer past the given runes, without
+// moving the input.
+func (lx *TextLexer) move(text []rune, sizes []int) {
+	if len(text) > 0 {
+		lx.last = text[len(text)-1]
+	}
+
+	if len(lx.continuations) == 0 {
+		for _, size := range sizes {
+			lx.byteOffset += size
+		}
+		lx.offset += len(sizes)
+		lx.advanceColumns(text)
+		return
+	}
+
+	start := 0
+	for i, size := range sizes {
+		if joined, ok := lx.continuations[lx.byteOffset]; ok {
+			// lines joined right before the rune
+			delete(lx.continuations, lx.byteOffset)
+
+			lx.advanceColumns(text[start:i])
+			lx.offset += joined.runes
+			lx.line += joined.lines
+			lx.col = 0
+			start = i
+		}
+
+		lx.byteOffset += size
+	}
+	lx.offset += len(sizes)
+	lx.advanceColumns(text[start:])
+}
+
+func (lx *TextLexer) advanceColumns(text []rune) {
+	start := 0
+	for i, r := range text {
+		if r == '\n' {
+			lx.line++
+			lx.col = 0
+			start = i + 1
+		}
+	}
+
+	line := text[start:]
+	for lx.tabWidth > 1 {
+		i := slices.Index(line, '\t')
+		if i < 0 {
+			break
+		}
+
+		lx.col += lx.columns(line[:i])
+		lx.col += lx.tabWidth - lx.col%lx.tabWidth
+		line = line[i+1:]
+	}
+
+	lx.col += lx.columns(line)
+}
+
+// columns returns the number of columns taken by text, which holds no new
+// lines.
+func (lx *TextLexer) columns(text []rune) int {
+	if lx.graphemeColumns {
+		return graphemeLen(text)
+	}
+
+	return len(text)
+}
+
+// skipBOM moves the lexer past a byte order mark at the start of the input,
+// the mark is not counted in rune offsets or columns.
+func (lx *TextLexer) skipBOM() error {
+	if !lx.stripBOM || lx.byteOffset != 0 {
+		return nil
+	}
+
+	r, size, err := lx.readRawRune()
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("read error: %w", err)
+	}
+
+	if err == nil && r == '\uFEFF' {
+		lx.byteOffset = size
+		lx.recordBoundary(nil)
+	}
+
+	if err := lx.seek(int64(lx.byteOffset), io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %v", err)
+	}
+
+	return nil
+}
+
+func (lx *TextLexer) skipLeadingWhitespace() error {
+	for {
+		r, size, err := lx.readRune()
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("read error: %w", err)
+		}
+
+		if err == io.EOF || !isWhitespace(r) {
+			break
+		}
+
+		if err := lx.checkInputLimit(0); err != nil {
+			return err
+		}
+
+		lx.move([]rune{r}, []int{size})
+
+		if r == '\n' && lx.indentation {
+			// the indentation of the next line is measured by indent
+			break
+		}
+	}
+
+	if err := lx.seek(int64(lx.byteOffset), io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %v", err)
+	}
+
+	return nil
+}
+
+// checkInputLimit returns ErrInputLimitExceeded when the rune n runes past
+// the current position is beyond the limit set with WithMaxInput, moving the
+// input back to the current position.
+func (lx *TextLexer) checkInputLimit(n int) error {
+	if lx.maxInput < 1 || lx.offset+n < lx.maxInput {
+		return nil
+	}
+
+	if err := lx.seek(int64(lx.byteOffset), io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %v", err)
+	}
+
+	return ErrInputLimitExceeded
+}
+
+func isWhitespace(r rune) bool {
+	switch r {
+	case ' ', '\t', '\r', '\n', '\f':
+		return true
+	}
+	return false
+}
@@ -1,12 +1,23 @@
 package textlexer_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	crand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"math/rand"
+	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unicode"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -708,3 +719,3700 @@ func TestChaosRules(t *testing.T) {
 	assert.True(t, seen[lexTypeChaos2])
 	assert.True(t, seen[lexTypeChaos3])
 }
+
+func TestPushBack(t *testing.T) {
+	const (
+		lexTypeName       = textlexer.LexemeType("NAME")
+		lexTypeColon      = textlexer.LexemeType("COLON")
+		lexTypeWhitespace = textlexer.LexemeType("WHITESPACE")
+	)
+
+	in := `xml:lang a: :kw b:`
+
+	out := []struct {
+		Type textlexer.LexemeType
+		Text string
+	}{
+		{lexTypeName, "xml:lang"},
+		{lexTypeWhitespace, " "},
+		{lexTypeName, "a"},
+		{lexTypeColon, ":"},
+		{lexTypeWhitespace, " "},
+		{lexTypeName, ":kw"},
+		{lexTypeWhitespace, " "},
+		{lexTypeName, "b"},
+		{lexTypeColon, ":"},
+	}
+
+	lx := textlexer.New(strings.NewReader(in))
+
+	lx.MustAddRule(lexTypeName, rules.NewNamespacedIdentifier(':'))
+	lx.MustAddRule(lexTypeColon, rules.Colon)
+	lx.MustAddRule(lexTypeWhitespace, rules.Whitespace)
+
+	for _, expected := range out {
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, expected.Type, lex.Type)
+		assert.Equal(t, expected.Text, lex.Text())
+	}
+
+	_, err := lx.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestLexemeOffset(t *testing.T) {
+	lx := textlexer.NewFromString("ab 123")
+	lx.MustAddRule("WORD", rules.Word)
+	lx.MustAddRule("INT", rules.UnsignedInteger)
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+	// the offset is where the lexeme starts, not where it ends
+	for _, expected := range []struct {
+		Text        string
+		Offset, End int
+	}{
+		{"ab", 0, 2},
+		{" ", 2, 3},
+		{"123", 3, 6},
+	} {
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, expected.Text, lex.Text())
+		assert.Equal(t, expected.Offset, lex.Offset())
+		assert.Equal(t, expected.End, lex.Offset()+lex.Len())
+	}
+}
+
+func TestLexemeJSON(t *testing.T) {
+	t.Run("marshal", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader("ab 123\n4"))
+
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("INT", rules.UnsignedInteger)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		expected := []string{
+			`{"type":"WORD","text":"ab","offset":0,"len":2,"line":0,"col":0}`,
+			`{"type":"WHITESPACE","text":" ","offset":2,"len":1,"line":0,"col":2}`,
+			`{"type":"INT","text":"123","offset":3,"len":3,"line":0,"col":3}`,
+			`{"type":"WHITESPACE","text":"\n","offset":6,"len":1,"line":0,"col":6}`,
+			`{"type":"INT","text":"4","offset":7,"len":1,"line":1,"col":0}`,
+		}
+
+		for i := range expected {
+			lex, err := lx.Next()
+			require.NoError(t, err)
+
+			buf, err := json.Marshal(lex)
+			require.NoError(t, err)
+
+			assert.Equal(t, expected[i], string(buf))
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		texts := []string{
+			"",
+			"123",
+			"héllo wörld",
+			"世界",
+			"👨‍👩‍👧",
+			"\"quoted\"\n\t\x00\x1b",
+			`back\slash`,
+		}
+
+		for _, text := range texts {
+			lex := textlexer.NewLexeme("STRING", text)
+
+			buf, err := json.Marshal(lex)
+			require.NoError(t, err)
+
+			var decoded textlexer.Lexeme
+			require.NoError(t, json.Unmarshal(buf, &decoded))
+
+			assert.Equal(t, lex.Type, decoded.Type)
+			assert.Equal(t, text, decoded.Text())
+			assert.Equal(t, lex.Offset(), decoded.Offset())
+			assert.Equal(t, lex.Len(), decoded.Len())
+		}
+	})
+
+	t.Run("position", func(t *testing.T) {
+		lx := textlexer.NewFromString("a\n  b")
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		var lex *textlexer.Lexeme
+		for i := 0; i < 3; i++ {
+			var err error
+			lex, err = lx.Next()
+			require.NoError(t, err)
+		}
+		require.Equal(t, "b", lex.Text())
+
+		buf, err := json.Marshal(lex)
+		require.NoError(t, err)
+
+		var decoded textlexer.Lexeme
+		require.NoError(t, json.Unmarshal(buf, &decoded))
+
+		assert.Equal(t, 4, decoded.Offset())
+		assert.Equal(t, 1, decoded.Line())
+		assert.Equal(t, 2, decoded.Col())
+	})
+
+	t.Run("EOF rune", func(t *testing.T) {
+		lex := textlexer.NewLexeme(textlexer.LexemeTypeUnknown, string(rune(textlexer.RuneEOF)))
+
+		buf, err := json.Marshal(lex)
+		require.NoError(t, err)
+		assert.True(t, json.Valid(buf))
+
+		var decoded textlexer.Lexeme
+		require.NoError(t, json.Unmarshal(buf, &decoded))
+		assert.Equal(t, "�", decoded.Text())
+		assert.Equal(t, 1, decoded.Len())
+	})
+
+	t.Run("length mismatch", func(t *testing.T) {
+		var decoded textlexer.Lexeme
+		err := json.Unmarshal([]byte(`{"type":"INT","text":"123","offset":0,"len":2}`), &decoded)
+		assert.Error(t, err)
+	})
+}
+
+func TestAcceptInconclusiveAtEOF(t *testing.T) {
+	const (
+		lexTypeRest = textlexer.LexemeType("REST")
+		lexTypeWord = textlexer.LexemeType("WORD")
+	)
+
+	in := `abc def`
+
+	t.Run("default", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader(in))
+
+		lx.MustAddRule(lexTypeRest, rules.AlwaysContinue)
+
+		_, err := lx.Next()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("default with other rules", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader(in))
+
+		lx.MustAddRule(lexTypeRest, rules.AlwaysContinue)
+		lx.MustAddRule(lexTypeWord, rules.Word)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, lexTypeWord, lex.Type)
+		assert.Equal(t, "abc", lex.Text())
+	})
+
+	t.Run("accept", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader(in), textlexer.AcceptInconclusiveAtEOF(true))
+
+		lx.MustAddRule(lexTypeRest, rules.AlwaysContinue)
+		lx.MustAddRule(lexTypeWord, rules.Word)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, lexTypeRest, lex.Type)
+		assert.Equal(t, "abc def", lex.Text())
+		assert.Equal(t, 0, lex.Offset())
+
+		_, err = lx.Next()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("accept unterminated string", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader(`"abc`), textlexer.AcceptInconclusiveAtEOF(true))
+
+		lx.MustAddRule(lexTypeRest, rules.Compose(rules.NewSingleMatch('"'), rules.AlwaysContinue))
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, lexTypeRest, lex.Type)
+		assert.Equal(t, `"abc`, lex.Text())
+	})
+}
+
+func TestStream(t *testing.T) {
+	const (
+		lexTypeWord       = textlexer.LexemeType("WORD")
+		lexTypeWhitespace = textlexer.LexemeType("WHITESPACE")
+	)
+
+	newLexer := func(in string) *textlexer.TextLexer {
+		lx := textlexer.New(strings.NewReader(in))
+
+		lx.MustAddRule(lexTypeWord, rules.Word)
+		lx.MustAddRule(lexTypeWhitespace, rules.Whitespace)
+
+		return lx
+	}
+
+	t.Run("until EOF", func(t *testing.T) {
+		lx := newLexer("a b c")
+
+		var texts []string
+		for res := range lx.Stream(context.Background()) {
+			require.NoError(t, res.Err)
+			texts = append(texts, res.Lexeme.Text())
+		}
+
+		assert.Equal(t, []string{"a", " ", "b", " ", "c"}, texts)
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		lx := textlexer.New(&failingReader{Reader: strings.NewReader("a b"), failAt: 2})
+		lx.MustAddRule(lexTypeWord, rules.Word)
+
+		var results []textlexer.Result
+		for res := range lx.Stream(context.Background()) {
+			results = append(results, res)
+		}
+
+		require.NotEmpty(t, results)
+		assert.Error(t, results[len(results)-1].Err)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+
+		lx := newLexer(strings.Repeat("word ", 10000))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := lx.Stream(ctx)
+
+		for i := 0; i < 10; i++ {
+			res, ok := <-ch
+			require.True(t, ok)
+			require.NoError(t, res.Err)
+		}
+
+		cancel()
+
+		// the consumer stops reading, the goroutine must exit anyway
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+
+		// and the channel must be closed
+		_, ok := <-ch
+		assert.False(t, ok)
+	})
+
+	t.Run("cancel while matching", func(t *testing.T) {
+		var slow textlexer.Rule
+		slow = func(r rune) (textlexer.Rule, textlexer.State) {
+			if r != 'a' {
+				return nil, textlexer.StateAccept
+			}
+			time.Sleep(5 * time.Millisecond)
+			return slow, textlexer.StateContinue
+		}
+
+		lx := textlexer.NewFromString(strings.Repeat("a", 400))
+		lx.MustAddRule("SLOW", slow)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		for res := range lx.Stream(ctx) {
+			t.Fatalf("unexpected result %v", res)
+		}
+		assert.Less(t, time.Since(start), 150*time.Millisecond)
+	})
+}
+
+type failingReader struct {
+	*strings.Reader
+
+	failAt int
+	reads  int
+}
+
+func (r *failingReader) ReadRune() (rune, int, error) {
+	r.reads++
+	if r.reads > r.failAt {
+		return 0, 0, errors.New("device error")
+	}
+	return r.Reader.ReadRune()
+}
+
+func TestSkipLeadingWhitespace(t *testing.T) {
+	const (
+		lexTypeInteger = textlexer.LexemeType("INT")
+	)
+
+	t.Run("default", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader("  12  34"))
+		lx.MustAddRule(lexTypeInteger, rules.UnsignedInteger)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, textlexer.LexemeTypeUnknown, lex.Type)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader("  12  34\n\t"), textlexer.WithSkipLeadingWhitespace())
+		lx.MustAddRule(lexTypeInteger, rules.UnsignedInteger)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, lexTypeInteger, lex.Type)
+		assert.Equal(t, "12", lex.Text())
+		assert.Equal(t, 2, lex.Offset())
+
+		lex, err = lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, lexTypeInteger, lex.Type)
+		assert.Equal(t, "34", lex.Text())
+		assert.Equal(t, 6, lex.Offset())
+
+		_, err = lx.Next()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("skip with whitespace rule", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader(" a b"), textlexer.WithSkipLeadingWhitespace())
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+		lx.MustAddRule("WORD", rules.Word)
+
+		for _, expected := range []string{"a", "b"} {
+			lex, err := lx.Next()
+			require.NoError(t, err)
+			assert.Equal(t, textlexer.LexemeType("WORD"), lex.Type)
+			assert.Equal(t, expected, lex.Text())
+		}
+	})
+}
+
+func TestSeekTo(t *testing.T) {
+	const (
+		lexTypeWord       = textlexer.LexemeType("WORD")
+		lexTypeWhitespace = textlexer.LexemeType("WHITESPACE")
+	)
+
+	setupRules := func(lx *textlexer.TextLexer) {
+		lx.MustAddRule(lexTypeWord, rules.MustCompile(`[^ ]+`))
+		lx.MustAddRule(lexTypeWhitespace, rules.Whitespace)
+	}
+
+	readAll := func(lx *textlexer.TextLexer) []*textlexer.Lexeme {
+		var lexemes []*textlexer.Lexeme
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			lexemes = append(lexemes, lex)
+		}
+		return lexemes
+	}
+
+	in := "héllo wörld 世界 again"
+
+	t.Run("re-lex a suffix", func(t *testing.T) {
+		lx := textlexer.NewFromString(in)
+		setupRules(lx)
+
+		all := readAll(lx)
+		require.Len(t, all, 7)
+
+		for _, from := range all {
+			require.NoError(t, lx.SeekTo(from.Offset()))
+			relexed := readAll(lx)
+
+			suffix := string([]rune(in)[from.Offset():])
+			fresh := textlexer.NewFromBytes([]byte(suffix))
+			setupRules(fresh)
+			expected := readAll(fresh)
+
+			require.Equal(t, len(expected), len(relexed))
+			for i := range expected {
+				assert.Equal(t, expected[i].Type, relexed[i].Type)
+				assert.Equal(t, expected[i].Text(), relexed[i].Text())
+				assert.Equal(t, expected[i].Offset()+from.Offset(), relexed[i].Offset())
+			}
+		}
+	})
+
+	t.Run("not a boundary", func(t *testing.T) {
+		lx := textlexer.NewFromString(in)
+		setupRules(lx)
+
+		_, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Error(t, lx.SeekTo(2))
+		assert.Error(t, lx.SeekTo(100))
+		assert.NoError(t, lx.SeekTo(5))
+		assert.NoError(t, lx.SeekTo(0))
+	})
+
+	t.Run("not seekable", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader(in))
+		setupRules(lx)
+
+		assert.Error(t, lx.SeekTo(0))
+	})
+
+	t.Run("skipped whitespace", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab   cd", textlexer.WithSkipLeadingWhitespace())
+		lx.MustAddRule("WORD", rules.Word)
+
+		all := readAll(lx)
+		require.Len(t, all, 2)
+
+		require.NoError(t, lx.SeekTo(5))
+		relexed := readAll(lx)
+		require.Len(t, relexed, 1)
+		assert.Equal(t, "cd", relexed[0].Text())
+		assert.Equal(t, 5, relexed[0].Offset())
+
+		// the end of "ab" is a boundary too
+		require.NoError(t, lx.SeekTo(2))
+		assert.Len(t, readAll(lx), 1)
+	})
+
+	t.Run("indentation", func(t *testing.T) {
+		lx := textlexer.NewFromString("a\n  b\nc", textlexer.WithIndentation())
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("NEWLINE", rules.Newline)
+
+		all := readAll(lx)
+		require.Len(t, all, 7)
+		require.Equal(t, "b", all[3].Text())
+
+		require.NoError(t, lx.SeekTo(all[3].Offset()))
+
+		var types []textlexer.LexemeType
+		for _, lex := range readAll(lx) {
+			types = append(types, lex.Type)
+		}
+		assert.Equal(t, []textlexer.LexemeType{"WORD", "NEWLINE", textlexer.LexemeTypeDedent, "WORD"}, types)
+	})
+
+	t.Run("previous lexeme", func(t *testing.T) {
+		lx := textlexer.NewFromString("a b c", textlexer.WithSkipLeadingWhitespace())
+		require.NoError(t, lx.AddRuleWithPriority("WORD", rules.Word, -1))
+		require.NoError(t, lx.AddRuleWithContext("AFTER_A", func(prev *textlexer.Lexeme, r rune) (textlexer.Rule, textlexer.State) {
+			if prev != nil && prev.Text() == "a" {
+				return rules.Word(r)
+			}
+			return nil, textlexer.StateReject
+		}))
+
+		all := readAll(lx)
+		require.Len(t, all, 3)
+		require.Equal(t, textlexer.LexemeType("AFTER_A"), all[1].Type)
+
+		require.NoError(t, lx.SeekTo(2))
+		relexed := readAll(lx)
+		require.Len(t, relexed, 2)
+		assert.Equal(t, textlexer.LexemeType("AFTER_A"), relexed[0].Type)
+	})
+	t.Run("merged unknown", func(t *testing.T) {
+		lx := textlexer.NewFromString("@#$abc", textlexer.WithMergedUnknown())
+		lx.MustAddRule("WORD", rules.Word)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		require.Equal(t, `UNKNOWN("@#$")@0+3`, lex.String())
+
+		// inside the merged lexeme and past the word that was looked at
+		assert.Error(t, lx.SeekTo(1))
+		assert.Error(t, lx.SeekTo(6))
+
+		require.NoError(t, lx.SeekTo(3))
+		relexed := readAll(lx)
+		require.Len(t, relexed, 1)
+		assert.Equal(t, `WORD("abc")@3+3`, relexed[0].String())
+
+		for _, offset := range []int{1, 2} {
+			assert.Error(t, lx.SeekTo(offset))
+		}
+		for _, offset := range []int{0, 3, 6} {
+			assert.NoError(t, lx.SeekTo(offset))
+		}
+	})
+}
+
+func TestKeywordsAndIdentifiers(t *testing.T) {
+	const (
+		lexTypeKeyword    = textlexer.LexemeType("KEYWORD")
+		lexTypeIdentifier = textlexer.LexemeType("IDENTIFIER")
+		lexTypeWhitespace = textlexer.LexemeType("WHITESPACE")
+	)
+
+	in := `if iffy in int interface inter`
+
+	out := []struct {
+		Type textlexer.LexemeType
+		Text string
+	}{
+		{lexTypeKeyword, "if"},
+		{lexTypeIdentifier, "iffy"},
+		{lexTypeKeyword, "in"},
+		{lexTypeKeyword, "int"},
+		{lexTypeKeyword, "interface"},
+		{lexTypeIdentifier, "inter"},
+	}
+
+	lx := textlexer.New(strings.NewReader(in))
+
+	lx.MustAddRule(lexTypeIdentifier, rules.Word)
+	lx.MustAddRule(lexTypeKeyword, rules.AnyOfStrings("if", "in", "int", "interface"))
+	lx.MustAddRule(lexTypeWhitespace, rules.Whitespace)
+
+	for _, expected := range out {
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		if lex.Type == lexTypeWhitespace {
+			lex, err = lx.Next()
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, expected.Type, lex.Type)
+		assert.Equal(t, expected.Text, lex.Text())
+	}
+}
+
+func TestTypeCounts(t *testing.T) {
+	const (
+		lexTypeInteger    = textlexer.LexemeType("INT")
+		lexTypeWord       = textlexer.LexemeType("WORD")
+		lexTypeWhitespace = textlexer.LexemeType("WHITESPACE")
+	)
+
+	lx := textlexer.New(strings.NewReader("a 1 bb 22 ccc ! ?"))
+
+	lx.MustAddRule(lexTypeInteger, rules.UnsignedInteger)
+	lx.MustAddRule(lexTypeWord, rules.Word)
+	lx.MustAddRule(lexTypeWhitespace, rules.Whitespace)
+
+	assert.Empty(t, lx.TypeCounts())
+
+	for i := 0; i < 3; i++ {
+		_, err := lx.Next()
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, map[textlexer.LexemeType]int{
+		lexTypeWord:       1,
+		lexTypeWhitespace: 1,
+		lexTypeInteger:    1,
+	}, lx.TypeCounts())
+
+	for {
+		_, err := lx.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	counts := lx.TypeCounts()
+	assert.Equal(t, map[textlexer.LexemeType]int{
+		lexTypeWord:                 3,
+		lexTypeWhitespace:           6,
+		lexTypeInteger:              2,
+		textlexer.LexemeTypeUnknown: 2,
+	}, counts)
+
+	// the returned map is a copy
+	counts[lexTypeWord] = 100
+	assert.Equal(t, 3, lx.TypeCounts()[lexTypeWord])
+}
+
+func TestUnknownReason(t *testing.T) {
+	const (
+		lexTypeWord  = textlexer.LexemeType("WORD")
+		lexTypeArrow = textlexer.LexemeType("ARROW")
+	)
+
+	lx := textlexer.New(strings.NewReader("ab!-x->"))
+
+	lx.MustAddRule(lexTypeWord, rules.Word)
+	lx.MustAddRule(lexTypeArrow, rules.NewLiteralMatch("->"))
+
+	out := []struct {
+		Type   textlexer.LexemeType
+		Text   string
+		Reason textlexer.UnknownReason
+	}{
+		{lexTypeWord, "ab", textlexer.UnknownReasonNone},
+		{textlexer.LexemeTypeUnknown, "!", textlexer.UnknownReasonNoRule},
+		{textlexer.LexemeTypeUnknown, "-x", textlexer.UnknownReasonNoMatch},
+		{lexTypeArrow, "->", textlexer.UnknownReasonNone},
+	}
+
+	for _, expected := range out {
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, expected.Type, lex.Type)
+		assert.Equal(t, expected.Text, lex.Text())
+		assert.Equal(t, expected.Reason, lex.UnknownReason())
+	}
+}
+
+func TestNormalizeNewlines(t *testing.T) {
+	const (
+		lexTypeWord    = textlexer.LexemeType("WORD")
+		lexTypeNewline = textlexer.LexemeType("NEWLINE")
+	)
+
+	testCases := []struct {
+		Name      string
+		Input     string
+		Normalize bool
+		Output    []string
+		Offsets   []int
+	}{
+		{"LF", "a\nb\n\nc", true, []string{"a", "\n", "b", "\n", "\n", "c"}, []int{0, 1, 2, 3, 4, 5}},
+		{"CRLF", "a\r\nb\r\n\r\nc", true, []string{"a", "\n", "b", "\n", "\n", "c"}, []int{0, 1, 2, 3, 4, 5}},
+		{"CR", "a\rb\r\rc", true, []string{"a", "\n", "b", "\n", "\n", "c"}, []int{0, 1, 2, 3, 4, 5}},
+		{"mixed", "a\r\nb\r\n\rc\r", true, []string{"a", "\n", "b", "\n", "\n", "c", "\n"}, []int{0, 1, 2, 3, 4, 5, 6}},
+		{"disabled", "a\r\nb", false, []string{"a", "\r", "\n", "b"}, []int{0, 1, 2, 3}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			lx := textlexer.New(strings.NewReader(tc.Input), textlexer.NormalizeNewlines(tc.Normalize))
+
+			lx.MustAddRule(lexTypeWord, rules.Word)
+			lx.MustAddRule(lexTypeNewline, rules.NewSingleMatch('\n'))
+
+			var texts []string
+			var offsets []int
+			for {
+				lex, err := lx.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+
+				texts = append(texts, lex.Text())
+				offsets = append(offsets, lex.Offset())
+			}
+
+			assert.Equal(t, tc.Output, texts)
+			assert.Equal(t, tc.Offsets, offsets)
+		})
+	}
+}
+
+func TestTrace(t *testing.T) {
+	const (
+		lexTypeWhitespace = textlexer.LexemeType("WHITESPACE")
+		lexTypeWord       = textlexer.LexemeType("WORD")
+		lexTypeInteger    = textlexer.LexemeType("INT")
+	)
+
+	var trace bytes.Buffer
+
+	lx := textlexer.New(strings.NewReader("SELECT 1"), textlexer.WithTrace(&trace))
+
+	lx.MustAddRule(lexTypeWhitespace, rules.Whitespace)
+	lx.MustAddRule(lexTypeWord, rules.Word)
+	lx.MustAddRule(lexTypeInteger, rules.UnsignedInteger)
+
+	for {
+		_, err := lx.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+
+	assert.Equal(t, []string{
+		"'S'\tWHITESPACE\tREJECT",
+		"'S'\tWORD\tCONTINUE",
+		"'S'\tINT\tREJECT",
+		"'E'\tWORD\tCONTINUE",
+		"'L'\tWORD\tCONTINUE",
+		"'E'\tWORD\tCONTINUE",
+		"'C'\tWORD\tCONTINUE",
+		"'T'\tWORD\tCONTINUE",
+		"' '\tWORD\tACCEPT",
+		"' '\tWHITESPACE\tCONTINUE",
+		"' '\tWORD\tREJECT",
+		"' '\tINT\tREJECT",
+		"'1'\tWHITESPACE\tACCEPT",
+		"'1'\tWHITESPACE\tREJECT",
+		"'1'\tWORD\tREJECT",
+		"'1'\tINT\tCONTINUE",
+		"EOF\tINT\tACCEPT",
+	}, lines)
+
+	assert.Equal(t, "State(42)", textlexer.State(42).String())
+}
+
+func TestMatchLongest(t *testing.T) {
+	testCases := []struct {
+		Input string
+		Rule  textlexer.Rule
+
+		Match string
+		N     int
+		OK    bool
+	}{
+		{"12.34abc", rules.UnsignedFloat, "12.34", 5, true},
+		{"12.34", rules.UnsignedFloat, "12.34", 5, true},
+		{"12abc", rules.UnsignedFloat, "", 0, false},
+		{"", rules.UnsignedFloat, "", 0, false},
+		{"héllo wörld", rules.MustCompile(`\S+`), "héllo", 5, true},
+		{"a:", rules.NewNamespacedIdentifier(':'), "a", 1, true},
+		{"abc", rules.AlwaysContinue, "", 0, false},
+		{"x12", rules.Optional(rules.UnsignedInteger), "", 0, false},
+		{"12x", rules.Optional(rules.UnsignedInteger), "12", 2, true},
+	}
+
+	for _, tc := range testCases {
+		match, n, ok, err := textlexer.MatchLongest(strings.NewReader(tc.Input), tc.Rule)
+		require.NoError(t, err)
+
+		assert.Equal(t, tc.Match, match, "input: %q", tc.Input)
+		assert.Equal(t, tc.N, n, "input: %q", tc.Input)
+		assert.Equal(t, tc.OK, ok, "input: %q", tc.Input)
+	}
+
+	_, _, _, err := textlexer.MatchLongest(&failingReader{Reader: strings.NewReader("123"), failAt: 1}, rules.UnsignedInteger)
+	assert.Error(t, err)
+}
+
+func TestEmptyMatch(t *testing.T) {
+	lexAll := func(lx *textlexer.TextLexer) []string {
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				return out
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.String())
+		}
+	}
+
+	for name, rule := range map[string]textlexer.Rule{
+		"optional": rules.Optional(rules.UnsignedInteger),
+		"repeat":   rules.Repeat(rules.UnsignedInteger, 0, -1),
+		"compose":  rules.Compose(rules.Optional(rules.Minus), rules.Optional(rules.UnsignedInteger)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			expected := []string{
+				`UNKNOWN("x")@0+1`,
+				`OPT("12")@1+2`,
+				`UNKNOWN("y")@3+1`,
+			}
+
+			lx := textlexer.NewFromString("x12y")
+			lx.MustAddRule("OPT", rule)
+			assert.Equal(t, expected, lexAll(lx))
+
+			// along with other rules
+			lx = textlexer.NewFromString("x12y")
+			lx.MustAddRule("OPT", rule)
+			lx.MustAddRule("WHITESPACE", rules.Whitespace)
+			assert.Equal(t, expected, lexAll(lx))
+		})
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	const (
+		lexTypeFlag       = textlexer.LexemeType("FLAG")
+		lexTypeEndOfFlags = textlexer.LexemeType("END-OF-FLAGS")
+		lexTypeStdin      = textlexer.LexemeType("STDIN")
+		lexTypeArg        = textlexer.LexemeType("ARG")
+	)
+
+	in := `grep -in --color=auto -- -pattern - file.txt`
+
+	out := []struct {
+		Type textlexer.LexemeType
+		Text string
+	}{
+		{lexTypeArg, "grep"},
+		{lexTypeFlag, "-in"},
+		{lexTypeFlag, "--color=auto"},
+		{lexTypeEndOfFlags, "--"},
+		{lexTypeFlag, "-pattern"},
+		{lexTypeStdin, "-"},
+		{lexTypeArg, "file.txt"},
+	}
+
+	lx := textlexer.New(strings.NewReader(in), textlexer.WithSkipLeadingWhitespace())
+
+	lx.MustAddRule(lexTypeArg, rules.NewShellWord())
+	lx.MustAddRule(lexTypeFlag, rules.NewCommandLineFlag())
+	lx.MustAddRule(lexTypeEndOfFlags, rules.EndOfFlags)
+	lx.MustAddRule(lexTypeStdin, rules.StdinFlag)
+
+	for _, expected := range out {
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, expected.Type, lex.Type)
+		assert.Equal(t, expected.Text, lex.Text())
+	}
+}
+
+func TestLexemeString(t *testing.T) {
+	lx := textlexer.New(strings.NewReader(`123 "a \"b\""`))
+
+	lx.MustAddRule("INT", rules.UnsignedInteger)
+	lx.MustAddRule("STRING", rules.DoubleQuotedFormattedString)
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+	expected := []string{
+		`INT("123")@0+3`,
+		`WHITESPACE(" ")@3+1`,
+		`STRING("\"a \\\"b\\\"\"")@4+9`,
+	}
+
+	for i := range expected {
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, expected[i], lex.String())
+		assert.Equal(t, expected[i], fmt.Sprintf("%v", lex))
+	}
+
+	assert.Equal(t, `UNKNOWN("")@0+0`, textlexer.NewLexeme(textlexer.LexemeTypeUnknown, "").String())
+}
+
+func TestSubLex(t *testing.T) {
+	const (
+		lexTypeString        = textlexer.LexemeType("STRING")
+		lexTypeWhitespace    = textlexer.LexemeType("WHITESPACE")
+		lexTypeWord          = textlexer.LexemeType("WORD")
+		lexTypeText          = textlexer.LexemeType("TEXT")
+		lexTypeInterpolation = textlexer.LexemeType("INTERPOLATION")
+	)
+
+	lx := textlexer.New(strings.NewReader(`say "hi ${name}, it is ${time}!"`))
+
+	lx.MustAddRule(lexTypeWord, rules.Word)
+	lx.MustAddRule(lexTypeWhitespace, rules.Whitespace)
+	lx.MustAddRule(lexTypeString, rules.DoubleQuotedString)
+
+	var str *textlexer.Lexeme
+	for {
+		lex, err := lx.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		if lex.Type == lexTypeString {
+			str = lex
+		}
+	}
+	require.NotNil(t, str)
+
+	lexemes, err := str.SubLex(textlexer.RuleSet{
+		{lexTypeText, rules.MustCompile(`[^$]+`)},
+		{lexTypeInterpolation, rules.MustCompile(`\$\{[a-z]+\}`)},
+	})
+	require.NoError(t, err)
+
+	expected := []string{
+		`TEXT("\"hi ")@4+4`,
+		`INTERPOLATION("${name}")@8+7`,
+		`TEXT(", it is ")@15+8`,
+		`INTERPOLATION("${time}")@23+7`,
+		`TEXT("!\"")@30+2`,
+	}
+
+	require.Len(t, lexemes, len(expected))
+	for i := range expected {
+		assert.Equal(t, expected[i], lexemes[i].String())
+	}
+
+	_, err = str.SubLex(textlexer.RuleSet{
+		{lexTypeText, rules.Word},
+		{lexTypeText, rules.Whitespace},
+	})
+	assert.Error(t, err)
+}
+
+func TestMaxPushback(t *testing.T) {
+	const lexTypeTrimmed = textlexer.LexemeType("TRIMMED")
+
+	var pushBack func(n int) textlexer.Rule
+	pushBack = func(n int) textlexer.Rule {
+		return func(r rune) (textlexer.Rule, textlexer.State) {
+			if n == 0 {
+				return nil, textlexer.StateAccept
+			}
+			return pushBack(n - 1), textlexer.StatePushBack
+		}
+	}
+
+	// consumes letters and then gives the last three back
+	var trimmed textlexer.Rule
+	trimmed = func(r rune) (textlexer.Rule, textlexer.State) {
+		if unicode.IsLetter(r) {
+			return trimmed, textlexer.StateContinue
+		}
+		return pushBack(3)(r)
+	}
+
+	t.Run("default", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader("abcde "))
+		lx.MustAddRule(lexTypeTrimmed, trimmed)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, lexTypeTrimmed, lex.Type)
+		assert.Equal(t, "ab", lex.Text())
+	})
+
+	t.Run("within limit", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader("abcde "), textlexer.WithMaxPushback(3))
+		lx.MustAddRule(lexTypeTrimmed, trimmed)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, lexTypeTrimmed, lex.Type)
+		assert.Equal(t, "ab", lex.Text())
+	})
+
+	t.Run("over limit", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader("abcde "), textlexer.WithMaxPushback(2))
+		lx.MustAddRule(lexTypeTrimmed, trimmed)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, textlexer.LexemeTypeUnknown, lex.Type)
+		assert.Equal(t, "abcde ", lex.Text())
+		assert.Equal(t, textlexer.UnknownReasonNoMatch, lex.UnknownReason())
+
+		_, err = lx.Next()
+		assert.Equal(t, io.EOF, err)
+	})
+}
+
+type countingReader struct {
+	*strings.Reader
+	reads int
+}
+
+func (cr *countingReader) ReadRune() (rune, int, error) {
+	cr.reads++
+	return cr.Reader.ReadRune()
+}
+
+func TestMaxReadAhead(t *testing.T) {
+	var forever textlexer.Rule
+	forever = func(r rune) (textlexer.Rule, textlexer.State) {
+		return forever, textlexer.StateContinue
+	}
+
+	in := strings.Repeat("a", 1000)
+
+	t.Run("best match", func(t *testing.T) {
+		cr := &countingReader{Reader: strings.NewReader(in)}
+
+		lx := textlexer.New(cr, textlexer.WithMaxReadAhead(10))
+		lx.MustAddRule("FOREVER", forever)
+		lx.MustAddRule("LETTER", rules.NewSingleMatch('a'))
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, `LETTER("a")@0+1`, lex.String())
+		assert.LessOrEqual(t, cr.reads, 10)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		cr := &countingReader{Reader: strings.NewReader(in)}
+
+		lx := textlexer.New(cr, textlexer.WithMaxReadAhead(10))
+		lx.MustAddRule("FOREVER", forever)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, textlexer.LexemeTypeUnknown, lex.Type)
+		assert.Equal(t, 10, lex.Len())
+		assert.LessOrEqual(t, cr.reads, 10)
+
+		lex, err = lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, 10, lex.Offset())
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		cr := &countingReader{Reader: strings.NewReader(in)}
+
+		lx := textlexer.New(cr)
+		lx.MustAddRule("FOREVER", forever)
+		lx.MustAddRule("LETTER", rules.NewSingleMatch('a'))
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Equal(t, `LETTER("a")@0+1`, lex.String())
+		assert.Greater(t, cr.reads, len(in))
+	})
+}
+
+func TestMaxInput(t *testing.T) {
+	t.Run("mid lexeme", func(t *testing.T) {
+		lx := textlexer.NewFromString("abc 12345 def", textlexer.WithMaxInput(6))
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("INT", rules.UnsignedInteger)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err != nil {
+				assert.ErrorIs(t, err, textlexer.ErrInputLimitExceeded)
+				break
+			}
+			out = append(out, lex.String())
+		}
+
+		assert.Equal(t, []string{`WORD("abc")@0+3`, `WHITESPACE(" ")@3+1`}, out)
+
+		// the lexer stays where it stopped
+		_, err := lx.Next()
+		assert.ErrorIs(t, err, textlexer.ErrInputLimitExceeded)
+
+		offset, _, _ := lx.Position()
+		assert.Equal(t, 4, offset)
+	})
+
+	t.Run("skipped whitespace", func(t *testing.T) {
+		lx := textlexer.NewFromString("a"+strings.Repeat(" ", 100), textlexer.WithMaxInput(10), textlexer.WithSkipLeadingWhitespace())
+		lx.MustAddRule("WORD", rules.Word)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "a", lex.Text())
+
+		_, err = lx.Next()
+		assert.ErrorIs(t, err, textlexer.ErrInputLimitExceeded)
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		lx := textlexer.NewFromString("abcdef", textlexer.WithMaxInput(3))
+
+		_, err := lx.Flush()
+		assert.ErrorIs(t, err, textlexer.ErrInputLimitExceeded)
+	})
+
+	t.Run("within limit", func(t *testing.T) {
+		lx := textlexer.NewFromString("abc", textlexer.WithMaxInput(3))
+		lx.MustAddRule("WORD", rules.Word)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "abc", lex.Text())
+
+		_, err = lx.Next()
+		assert.Equal(t, io.EOF, err)
+	})
+}
+
+func TestRules(t *testing.T) {
+	lx := textlexer.New(strings.NewReader(""))
+
+	assert.Empty(t, lx.Rules())
+	assert.False(t, lx.HasRule("WORD"))
+
+	lx.MustAddRule("WORD", rules.Word)
+	lx.MustAddRule("INT", rules.UnsignedInteger)
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+	assert.Equal(t, []textlexer.LexemeType{"WORD", "INT", "WHITESPACE"}, lx.Rules())
+
+	assert.True(t, lx.HasRule("WORD"))
+	assert.True(t, lx.HasRule("WHITESPACE"))
+	assert.False(t, lx.HasRule("FLOAT"))
+
+	ruleTypes := lx.Rules()
+	ruleTypes[0] = "FLOAT"
+
+	assert.Equal(t, []textlexer.LexemeType{"WORD", "INT", "WHITESPACE"}, lx.Rules())
+	assert.False(t, lx.HasRule("FLOAT"))
+}
+
+func TestAddRuleWithContext(t *testing.T) {
+	const (
+		lexTypeIdent  = textlexer.LexemeType("IDENT")
+		lexTypeNumber = textlexer.LexemeType("NUMBER")
+		lexTypeAssign = textlexer.LexemeType("ASSIGN")
+		lexTypeSlash  = textlexer.LexemeType("SLASH")
+		lexTypeRegexp = textlexer.LexemeType("REGEXP")
+	)
+
+	regexp := rules.MustCompile(`/[^/]*/`)
+
+	// a slash after an operand is a division
+	regexpAfterOperator := func(prev *textlexer.Lexeme, r rune) (textlexer.Rule, textlexer.State) {
+		if prev != nil && (prev.Type == lexTypeIdent || prev.Type == lexTypeNumber) {
+			return nil, textlexer.StateReject
+		}
+		return regexp(r)
+	}
+
+	testCases := []struct {
+		in  string
+		out []string
+	}{
+		{
+			in:  "a / b",
+			out: []string{`IDENT("a")@0+1`, `SLASH("/")@2+1`, `IDENT("b")@4+1`},
+		},
+		{
+			in:  "a / b / 2",
+			out: []string{`IDENT("a")@0+1`, `SLASH("/")@2+1`, `IDENT("b")@4+1`, `SLASH("/")@6+1`, `NUMBER("2")@8+1`},
+		},
+		{
+			in:  "= /re/",
+			out: []string{`ASSIGN("=")@0+1`, `REGEXP("/re/")@2+4`},
+		},
+		{
+			in:  "/re/ / 2",
+			out: []string{`REGEXP("/re/")@0+4`, `SLASH("/")@5+1`, `NUMBER("2")@7+1`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			lx := textlexer.NewFromString(tc.in, textlexer.WithSkipLeadingWhitespace())
+
+			lx.MustAddRule(lexTypeIdent, rules.Word)
+			lx.MustAddRule(lexTypeNumber, rules.UnsignedInteger)
+			lx.MustAddRule(lexTypeAssign, rules.Equal)
+			lx.MustAddRule(lexTypeSlash, rules.Slash)
+			require.NoError(t, lx.AddRuleWithContext(lexTypeRegexp, regexpAfterOperator))
+
+			var out []string
+			for {
+				lex, err := lx.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+
+				out = append(out, lex.String())
+			}
+
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestLineContinuation(t *testing.T) {
+	lexAll := func(t *testing.T, lx *textlexer.TextLexer) []string {
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, fmt.Sprintf("%v:%d:%d", lex, lex.Line(), lex.Col()))
+		}
+		return out
+	}
+
+	t.Run("joined word", func(t *testing.T) {
+		lx := textlexer.NewFromString("foo\\\nbar baz\n\\x", textlexer.WithLineContinuation('\\'))
+
+		assert.Equal(t, []string{
+			`WORD("foobar")@0+6:0:0`,
+			`WHITESPACE(" ")@8+1:1:3`,
+			`WORD("baz")@9+3:1:4`,
+			`WHITESPACE("\n")@12+1:1:7`,
+			`UNKNOWN("\\")@13+1:2:0`,
+			`WORD("x")@14+1:2:1`,
+		}, lexAll(t, lx))
+
+		offset, line, col := lx.Position()
+		assert.Equal(t, []int{15, 2, 2}, []int{offset, line, col})
+	})
+
+	t.Run("source offsets", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab\\\ncd ef", textlexer.WithLineContinuation('\\'))
+
+		assert.Equal(t, []string{
+			`WORD("abcd")@0+4:0:0`,
+			`WHITESPACE(" ")@6+1:1:2`,
+			`WORD("ef")@7+2:1:3`,
+		}, lexAll(t, lx))
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		lx := textlexer.NewFromString("a \\\nb c", textlexer.WithLineContinuation('\\'))
+		assert.Equal(t, []string{
+			`WORD("a")@0+1:0:0`,
+			`WHITESPACE(" ")@1+1:0:1`,
+			`WORD("b")@4+1:1:0`,
+			`WHITESPACE(" ")@5+1:1:1`,
+			`WORD("c")@6+1:1:2`,
+		}, lexAll(t, lx))
+
+		// the end of the whitespace and the start of the word after the
+		// joined lines
+		for _, offset := range []int{2, 4} {
+			require.NoError(t, lx.SeekTo(offset))
+
+			lex, err := lx.Next()
+			require.NoError(t, err)
+			assert.Equal(t, `WORD("b")@4+1:1:0`, fmt.Sprintf("%v:%d:%d", lex, lex.Line(), lex.Col()))
+		}
+	})
+
+	t.Run("several lines", func(t *testing.T) {
+		lx := textlexer.NewFromString("a\\\n\\\nb\\\n  c", textlexer.WithLineContinuation('\\'))
+
+		assert.Equal(t, []string{
+			`WORD("ab")@0+2:0:0`,
+			`WHITESPACE("  ")@8+2:3:0`,
+			`WORD("c")@10+1:3:2`,
+		}, lexAll(t, lx))
+	})
+
+	t.Run("skipped whitespace", func(t *testing.T) {
+		lx := textlexer.NewFromString("a \\\n b", textlexer.WithLineContinuation('\\'), textlexer.WithSkipLeadingWhitespace())
+
+		assert.Equal(t, []string{`WORD("a")@0+1:0:0`, `WORD("b")@5+1:1:1`}, lexAll(t, lx))
+	})
+
+	t.Run("crlf", func(t *testing.T) {
+		lx := textlexer.NewFromString("a\\\r\nb", textlexer.WithLineContinuation('\\'), textlexer.NormalizeNewlines(true))
+
+		assert.Equal(t, []string{`WORD("ab")@0+2:0:0`}, lexAll(t, lx))
+	})
+
+	t.Run("crlf offsets", func(t *testing.T) {
+		in := "ab\\\r\n\\\r\ncd ef\\\n\\\r\ng"
+
+		lx := textlexer.NewFromString(in, textlexer.WithLineContinuation('\\'))
+		assert.Equal(t, []string{
+			`WORD("abcd")@0+4:0:0`,
+			`WHITESPACE(" ")@10+1:2:2`,
+			`WORD("efg")@11+3:2:3`,
+		}, lexAll(t, lx))
+
+		// "\r\n" counts as one rune once normalized
+		lx = textlexer.NewFromString(in, textlexer.WithLineContinuation('\\'), textlexer.NormalizeNewlines(true))
+		assert.Equal(t, []string{
+			`WORD("abcd")@0+4:0:0`,
+			`WHITESPACE(" ")@8+1:2:2`,
+			`WORD("efg")@9+3:2:3`,
+		}, lexAll(t, lx))
+	})
+
+	t.Run("lone cr", func(t *testing.T) {
+		lx := textlexer.NewFromString("a\\\rb", textlexer.WithLineContinuation('\\'))
+
+		assert.Equal(t, []string{
+			`WORD("a")@0+1:0:0`,
+			`UNKNOWN("\\")@1+1:0:1`,
+			`WHITESPACE("\r")@2+1:0:2`,
+			`WORD("b")@3+1:0:3`,
+		}, lexAll(t, lx))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		lx := textlexer.NewFromString("a\\\nb")
+
+		assert.Equal(t, []string{
+			`WORD("a")@0+1:0:0`,
+			`UNKNOWN("\\")@1+1:0:1`,
+			`WHITESPACE("\n")@2+1:0:2`,
+			`WORD("b")@3+1:1:0`,
+		}, lexAll(t, lx))
+	})
+}
+
+func TestIndentation(t *testing.T) {
+	lexAll := func(lx *textlexer.TextLexer) ([]string, error) {
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if errors.Is(err, io.EOF) {
+				return out, nil
+			}
+			if err != nil {
+				return out, err
+			}
+
+			switch lex.Type {
+			case "WHITESPACE":
+			case textlexer.LexemeTypeIndent, textlexer.LexemeTypeDedent, "NEWLINE":
+				out = append(out, fmt.Sprintf("%s:%d:%d", lex.Type, lex.Line(), lex.Col()))
+			default:
+				out = append(out, lex.Text())
+			}
+		}
+	}
+
+	newLexer := func(in string, opts ...textlexer.Option) *textlexer.TextLexer {
+		lx := textlexer.NewFromString(in, append(opts, textlexer.WithIndentation())...)
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("COLON", rules.Colon)
+		lx.MustAddRule("NEWLINE", rules.Newline)
+		lx.MustAddRule("WHITESPACE", rules.HorizontalWhitespace)
+		return lx
+	}
+
+	t.Run("blocks", func(t *testing.T) {
+		in := "if a:\n" +
+			"    b\n" +
+			"    if c:\n" +
+			"        d\n" +
+			"    e\n" +
+			"f\n"
+
+		out, err := lexAll(newLexer(in))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"if", "a", ":", "NEWLINE:0:5",
+			"INDENT:1:4", "b", "NEWLINE:1:5",
+			"if", "c", ":", "NEWLINE:2:9",
+			"INDENT:3:8", "d", "NEWLINE:3:9",
+			"DEDENT:4:4", "e", "NEWLINE:4:5",
+			"DEDENT:5:0", "f", "NEWLINE:5:1",
+		}, out)
+	})
+
+	t.Run("closed at EOF", func(t *testing.T) {
+		out, err := lexAll(newLexer("a\n  b\n    c"))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"a", "NEWLINE:0:1",
+			"INDENT:1:2", "b", "NEWLINE:1:3",
+			"INDENT:2:4", "c",
+			"DEDENT:2:5", "DEDENT:2:5",
+		}, out)
+	})
+
+	t.Run("skipped whitespace", func(t *testing.T) {
+		lx := textlexer.NewFromString("a:  \n\n   \n  b c\n\n  d\ne", textlexer.WithIndentation(), textlexer.WithSkipLeadingWhitespace())
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("COLON", rules.Colon)
+
+		out, err := lexAll(lx)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"a", ":", "INDENT:3:2", "b", "c", "d", "DEDENT:6:0", "e"}, out)
+	})
+
+	t.Run("tabs", func(t *testing.T) {
+		out, err := lexAll(newLexer("a\n\tb\n        c\n  \td"))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"a", "NEWLINE:0:1",
+			"INDENT:1:1", "b", "NEWLINE:1:2",
+			"c", "NEWLINE:2:9",
+			"d",
+			"DEDENT:3:4",
+		}, out)
+
+		out, err = lexAll(newLexer("a\n\tb\n    c", textlexer.WithTabWidth(4)))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"a", "NEWLINE:0:1",
+			"INDENT:1:4", "b", "NEWLINE:1:5",
+			"c",
+			"DEDENT:2:5",
+		}, out)
+	})
+
+	t.Run("inconsistent dedent", func(t *testing.T) {
+		out, err := lexAll(newLexer("a\n    b\n  c\n"))
+		assert.ErrorIs(t, err, textlexer.ErrInconsistentDedent)
+
+		assert.Equal(t, []string{"a", "NEWLINE:0:1", "INDENT:1:4", "b", "NEWLINE:1:5"}, out)
+	})
+}
+
+func TestInvalidUTF8Policy(t *testing.T) {
+	in := []byte("ab \xffcd\xfe\xfe ef \uFFFD")
+
+	lexAll := func(lx *textlexer.TextLexer) ([]string, error) {
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if errors.Is(err, io.EOF) {
+				return out, nil
+			}
+			if err != nil {
+				return out, err
+			}
+			out = append(out, lex.String())
+		}
+	}
+
+	t.Run("replace", func(t *testing.T) {
+		out, err := lexAll(textlexer.NewFromBytes(in))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			`WORD("ab")@0+2`,
+			`WHITESPACE(" ")@2+1`,
+			"UNKNOWN(\"\uFFFD\")@3+1",
+			`WORD("cd")@4+2`,
+			"UNKNOWN(\"\uFFFD\")@6+1",
+			"UNKNOWN(\"\uFFFD\")@7+1",
+			`WHITESPACE(" ")@8+1`,
+			`WORD("ef")@9+2`,
+			`WHITESPACE(" ")@11+1`,
+			"UNKNOWN(\"\uFFFD\")@12+1",
+		}, out)
+
+		lx := textlexer.NewFromBytes(in, textlexer.WithInvalidUTF8Policy(textlexer.InvalidUTF8Replace))
+		replaced, err := lexAll(lx)
+		require.NoError(t, err)
+		assert.Equal(t, out, replaced)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		lx := textlexer.NewFromBytes(in, textlexer.WithInvalidUTF8Policy(textlexer.InvalidUTF8Skip), textlexer.WithZeroCopy())
+		out, err := lexAll(lx)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			`WORD("ab")@0+2`,
+			`WHITESPACE(" ")@2+1`,
+			`WORD("cd")@3+2`,
+			`WHITESPACE(" ")@5+1`,
+			`WORD("ef")@6+2`,
+			`WHITESPACE(" ")@8+1`,
+			"UNKNOWN(\"\uFFFD\")@9+1",
+		}, out)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		lx := textlexer.NewFromBytes(in, textlexer.WithInvalidUTF8Policy(textlexer.InvalidUTF8Error))
+		out, err := lexAll(lx)
+		assert.ErrorIs(t, err, textlexer.ErrInvalidUTF8)
+		assert.ErrorContains(t, err, "byte 3")
+
+		// the invalid byte is found while looking past the whitespace
+		assert.Equal(t, []string{`WORD("ab")@0+2`}, out)
+	})
+}
+
+func TestTabWidth(t *testing.T) {
+	in := "\tab\tc  \td\n\t\te"
+
+	columns := func(opts ...textlexer.Option) [][2]int {
+		lx := textlexer.NewFromString(in, append(opts, textlexer.WithSkipLeadingWhitespace())...)
+		lx.MustAddRule("WORD", rules.Word)
+
+		var out [][2]int
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, [2]int{lex.Line(), lex.Col()})
+		}
+
+		return out
+	}
+
+	assert.Equal(t, [][2]int{{0, 1}, {0, 4}, {0, 8}, {1, 2}}, columns())
+	assert.Equal(t, [][2]int{{0, 1}, {0, 4}, {0, 8}, {1, 2}}, columns(textlexer.WithTabWidth(1)))
+	assert.Equal(t, [][2]int{{0, 4}, {0, 8}, {0, 12}, {1, 8}}, columns(textlexer.WithTabWidth(4)))
+	assert.Equal(t, [][2]int{{0, 8}, {0, 16}, {0, 24}, {1, 16}}, columns(textlexer.WithTabWidth(8)))
+
+	t.Run("within a lexeme", func(t *testing.T) {
+		lx := textlexer.NewFromString("a\tb c", textlexer.WithTabWidth(4))
+		lx.MustAddRule("TEXT", rules.MustCompile(`[a-z\t]+`))
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		_, err := lx.Next()
+		require.NoError(t, err)
+
+		_, line, col := lx.Position()
+		assert.Equal(t, []int{0, 5}, []int{line, col})
+	})
+}
+
+func TestGraphemeColumns(t *testing.T) {
+	// a family emoji joined with zero width joiners and an "e" followed by a
+	// combining acute accent
+	in := "\U0001F468\u200d\U0001F469\u200d\U0001F467 e\u0301x\ny"
+
+	lexemes := func(opts ...textlexer.Option) []*textlexer.Lexeme {
+		lx := textlexer.NewFromString(in, opts...)
+
+		lx.MustAddRule("WORD", rules.MustCompile(`[^ \n]+`))
+		lx.MustAddRule("WHITESPACE", rules.MustCompile(`[ \n]+`))
+
+		var out []*textlexer.Lexeme
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex)
+		}
+
+		return out
+	}
+
+	t.Run("runes", func(t *testing.T) {
+		out := lexemes()
+		require.Len(t, out, 5)
+
+		expected := [][2]int{{0, 0}, {0, 5}, {0, 6}, {0, 9}, {1, 0}}
+		for i := range expected {
+			assert.Equal(t, expected[i], [2]int{out[i].Line(), out[i].Col()}, "lexeme %v", out[i])
+		}
+	})
+
+	t.Run("graphemes", func(t *testing.T) {
+		out := lexemes(textlexer.WithGraphemeColumns())
+		require.Len(t, out, 5)
+
+		expected := [][2]int{{0, 0}, {0, 1}, {0, 2}, {0, 4}, {1, 0}}
+		for i := range expected {
+			assert.Equal(t, expected[i], [2]int{out[i].Line(), out[i].Col()}, "lexeme %v", out[i])
+		}
+
+		assert.Equal(t, 5, out[0].Len())
+		assert.Equal(t, 1, out[0].GraphemeLen())
+
+		assert.Equal(t, 3, out[2].Len())
+		assert.Equal(t, 2, out[2].GraphemeLen())
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		lex := textlexer.NewLexeme("WORD", "\U0001F1FA\U0001F1F8\U0001F1EB\U0001F1F7")
+
+		assert.Equal(t, 4, lex.Len())
+		assert.Equal(t, 2, lex.GraphemeLen())
+	})
+}
+
+func TestAddClassifyingRule(t *testing.T) {
+	const (
+		lexTypeKeyword    = textlexer.LexemeType("KEYWORD")
+		lexTypeIdentifier = textlexer.LexemeType("IDENTIFIER")
+		lexTypeWhitespace = textlexer.LexemeType("WHITESPACE")
+	)
+
+	keywords := map[string]bool{
+		"if":    true,
+		"while": true,
+	}
+
+	lx := textlexer.NewFromString("if iffy while whiles x")
+
+	err := lx.AddClassifyingRule(lexTypeIdentifier, rules.Word, func(text []rune) textlexer.LexemeType {
+		if keywords[string(text)] {
+			return lexTypeKeyword
+		}
+		return ""
+	})
+	require.NoError(t, err)
+
+	lx.MustAddRule(lexTypeWhitespace, rules.Whitespace)
+
+	expected := []string{
+		`KEYWORD("if")@0+2`,
+		`IDENTIFIER("iffy")@3+4`,
+		`KEYWORD("while")@8+5`,
+		`IDENTIFIER("whiles")@14+6`,
+		`IDENTIFIER("x")@21+1`,
+	}
+
+	var out []string
+	for {
+		lex, err := lx.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		if lex.Type != lexTypeWhitespace {
+			out = append(out, lex.String())
+		}
+	}
+
+	assert.Equal(t, expected, out)
+
+	assert.Equal(t, map[textlexer.LexemeType]int{
+		lexTypeKeyword:    2,
+		lexTypeIdentifier: 3,
+		lexTypeWhitespace: 4,
+	}, lx.TypeCounts())
+
+	err = lx.AddClassifyingRule(lexTypeIdentifier, rules.Word, nil)
+	assert.Error(t, err)
+}
+
+func TestNextContext(t *testing.T) {
+	const lexTypeSlow = textlexer.LexemeType("SLOW")
+
+	var slow textlexer.Rule
+	slow = func(r rune) (textlexer.Rule, textlexer.State) {
+		if r != 'a' {
+			return nil, textlexer.StateAccept
+		}
+		time.Sleep(5 * time.Millisecond)
+		return slow, textlexer.StateContinue
+	}
+
+	in := strings.Repeat("a", 40) + " b"
+
+	lx := textlexer.NewFromString(in)
+	lx.MustAddRule(lexTypeSlow, slow)
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := lx.NextContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+
+	_, err = lx.NextContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// a retry starts over from the same position
+	lex, err := lx.NextContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("SLOW(%q)@0+40", strings.Repeat("a", 40)), lex.String())
+
+	lex, err = lx.Next()
+	require.NoError(t, err)
+	assert.Equal(t, `WHITESPACE(" ")@40+1`, lex.String())
+}
+
+func TestBuffered(t *testing.T) {
+	t.Run("lookahead", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab cd")
+
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		assert.Equal(t, "", lx.Buffered())
+
+		expected := []struct {
+			lexeme   string
+			buffered string
+		}{
+			{`WORD("ab")@0+2`, " "},
+			{`WHITESPACE(" ")@2+1`, "c"},
+			{`WORD("cd")@3+2`, ""},
+		}
+
+		for _, e := range expected {
+			lex, err := lx.Next()
+			require.NoError(t, err)
+
+			assert.Equal(t, e.lexeme, lex.String())
+			assert.Equal(t, e.buffered, lx.Buffered())
+		}
+
+		_, err := lx.Next()
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, "", lx.Buffered())
+	})
+
+	t.Run("incomplete at EOF", func(t *testing.T) {
+		lx := textlexer.NewFromString(`a "bc d`)
+
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+		lx.MustAddRule("STRING", rules.DoubleQuotedString)
+
+		for i := 0; i < 2; i++ {
+			_, err := lx.Next()
+			require.NoError(t, err)
+		}
+
+		_, err := lx.Next()
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, `"bc d`, lx.Buffered())
+	})
+}
+
+func TestFlush(t *testing.T) {
+	t.Run("unterminated comment", func(t *testing.T) {
+		lx := textlexer.NewFromString("a /* b\nc")
+
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+		lx.MustAddRule("COMMENT", rules.SlashStarComment)
+
+		for i := 0; i < 2; i++ {
+			_, err := lx.Next()
+			require.NoError(t, err)
+		}
+
+		_, err := lx.Next()
+		assert.Equal(t, io.EOF, err)
+
+		lex, err := lx.Flush()
+		require.NoError(t, err)
+		assert.Equal(t, `UNKNOWN("/* b\nc")@2+6`, lex.String())
+		assert.Equal(t, textlexer.UnknownReasonNoMatch, lex.UnknownReason())
+
+		offset, line, col := lx.Position()
+		assert.Equal(t, []int{8, 1, 1}, []int{offset, line, col})
+		assert.Equal(t, "", lx.Buffered())
+
+		_, err = lx.Next()
+		assert.Equal(t, io.EOF, err)
+
+		_, err = lx.Flush()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		lx := textlexer.NewFromString("")
+
+		_, err := lx.Flush()
+		assert.Equal(t, io.EOF, err)
+	})
+}
+
+func TestStats(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab")
+		lx.MustAddRule("WORD", rules.Word)
+
+		_, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Nil(t, lx.Stats())
+	})
+
+	t.Run("counts", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab 12 cde", textlexer.WithStats())
+
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("INT", rules.UnsignedInteger)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+		lx.MustAddRule("FLOAT", rules.UnsignedFloat)
+
+		expected := map[textlexer.LexemeType]textlexer.RuleStats{}
+		for {
+			lex, err := lx.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+
+			s := expected[lex.Type]
+			s.Matches++
+			s.TotalRunes += lex.Len()
+			expected[lex.Type] = s
+		}
+
+		for _, lexType := range lx.Rules() {
+			s := expected[lexType]
+			s.Activations = 5
+			expected[lexType] = s
+		}
+
+		assert.Equal(t, expected, lx.Stats())
+		assert.Equal(t, textlexer.RuleStats{Matches: 2, TotalRunes: 5, Activations: 5}, expected["WORD"])
+		assert.Equal(t, textlexer.RuleStats{Activations: 5}, expected["FLOAT"])
+	})
+
+	t.Run("merged unknown", func(t *testing.T) {
+		lx := textlexer.NewFromString("a??b", textlexer.WithStats(), textlexer.WithMergedUnknown())
+		lx.MustAddRule("WORD", rules.Word)
+
+		for {
+			_, err := lx.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, map[textlexer.LexemeType]textlexer.RuleStats{
+			"WORD": {Matches: 2, TotalRunes: 2, Activations: 4},
+		}, lx.Stats())
+	})
+}
+
+func TestInvalidState(t *testing.T) {
+	invalid := func(r rune) (textlexer.Rule, textlexer.State) {
+		if r == '!' {
+			return nil, textlexer.State(42)
+		}
+		return nil, textlexer.StateReject
+	}
+
+	newLexer := func(opts ...textlexer.Option) *textlexer.TextLexer {
+		lx := textlexer.NewFromString("ab!", opts...)
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("BANG", invalid)
+		return lx
+	}
+
+	t.Run("error", func(t *testing.T) {
+		lx := newLexer()
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "ab", lex.Text())
+
+		_, err = lx.Next()
+		assert.ErrorIs(t, err, textlexer.ErrInvalidState)
+		assert.Contains(t, err.Error(), "BANG")
+		assert.Contains(t, err.Error(), "State(42)")
+
+		offset, _, _ := lx.Position()
+		assert.Equal(t, 2, offset)
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		lx := newLexer(textlexer.WithStrictStates())
+
+		_, err := lx.Next()
+		require.NoError(t, err)
+
+		assert.Panics(t, func() {
+			_, _ = lx.Next()
+		})
+	})
+}
+
+func TestConcurrentAddRule(t *testing.T) {
+	const (
+		workers = 4
+		perWork = 50
+	)
+
+	in := strings.Repeat("ab 12 + ", 500)
+
+	lx := textlexer.NewFromString(in)
+	lx.MustAddRule("WORD", rules.Word)
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+	// a rule added while a number is being matched only sees its last digit
+	valid := map[textlexer.LexemeType]*regexp.Regexp{
+		"WORD":       regexp.MustCompile(`^ab$`),
+		"WHITESPACE": regexp.MustCompile(`^ $`),
+		"INT":        regexp.MustCompile(`^(12|2)$`),
+		"PLUS":       regexp.MustCompile(`^\+$`),
+		"UNKNOWN":    regexp.MustCompile(`^(1|2|\+)$`),
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		for {
+			lex, err := lx.Next()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				done <- err
+				return
+			}
+
+			if re, ok := valid[lex.Type]; !ok || !re.MatchString(lex.Text()) {
+				done <- fmt.Errorf("unexpected lexeme %v", lex)
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < perWork; j++ {
+				lexType := textlexer.LexemeType(fmt.Sprintf("KW%d_%d", i, j))
+				rule := rules.NewLiteralMatch(fmt.Sprintf("kw%d_%d", i, j))
+
+				var err error
+				switch j % 3 {
+				case 0:
+					err = lx.AddRule(lexType, rule)
+				case 1:
+					err = lx.AddRuleWithPriority(lexType, rule, j)
+				case 2:
+					err = lx.AddRuleWithFirstRunes(lexType, rule, []rune{'k'})
+				}
+				assert.NoError(t, err)
+			}
+
+			switch i {
+			case 0:
+				assert.NoError(t, lx.AddRuleWithPriority("INT", rules.UnsignedInteger, 1))
+			case 1:
+				assert.NoError(t, lx.AddClassifyingRule("SYMBOL", rules.NewSingleMatch('+'), func([]rune) textlexer.LexemeType {
+					return "PLUS"
+				}))
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	require.NoError(t, <-done)
+
+	assert.Len(t, lx.Rules(), 2+workers*perWork+2)
+
+	// all the rules are used once they are added
+	require.NoError(t, lx.SeekTo(0))
+
+	counts := map[textlexer.LexemeType]int{}
+	for {
+		lex, err := lx.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+
+		counts[lex.Type]++
+	}
+
+	assert.Equal(t, map[textlexer.LexemeType]int{
+		"WORD":       500,
+		"WHITESPACE": 1500,
+		"INT":        500,
+		"PLUS":       500,
+	}, counts)
+}
+
+func TestSplitRule(t *testing.T) {
+	// splits "a:b" at the colon
+	pair := rules.MustCompile(`[a-z]+:[a-z]+`)
+	split := func(text []rune) []int {
+		for i, r := range text {
+			if r == ':' {
+				return []int{i, 1, len(text) - i - 1}
+			}
+		}
+		return nil
+	}
+
+	lexAll := func(lx *textlexer.TextLexer) []string {
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, fmt.Sprintf("%v:%d:%d", lex, lex.Line(), lex.Col()))
+		}
+		return out
+	}
+
+	t.Run("parts", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab:c\nd:ef", textlexer.WithSkipLeadingWhitespace())
+		require.NoError(t, lx.AddSplitRule("PAIR", pair, split, "KEY", "COLON", "VALUE"))
+
+		assert.Equal(t, []string{
+			`KEY("ab")@0+2:0:0`,
+			`COLON(":")@2+1:0:2`,
+			`VALUE("c")@3+1:0:3`,
+			`KEY("d")@5+1:1:0`,
+			`COLON(":")@6+1:1:1`,
+			`VALUE("ef")@7+2:1:2`,
+		}, lexAll(lx))
+
+		assert.Equal(t, map[textlexer.LexemeType]int{"KEY": 2, "COLON": 2, "VALUE": 2}, lx.TypeCounts())
+	})
+
+	t.Run("dropped parts", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab:c", textlexer.WithSkipLeadingWhitespace())
+		require.NoError(t, lx.AddSplitRule("PAIR", pair, split, "KEY", "", "VALUE"))
+
+		assert.Equal(t, []string{`KEY("ab")@0+2:0:0`, `VALUE("c")@3+1:0:3`}, lexAll(lx))
+	})
+
+	t.Run("all parts dropped", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab:c d", textlexer.WithSkipLeadingWhitespace())
+		require.NoError(t, lx.AddSplitRule("PAIR", pair, split, "", "", ""))
+		lx.MustAddRule("WORD", rules.Word)
+
+		assert.Equal(t, []string{`WORD("d")@5+1:0:5`}, lexAll(lx))
+	})
+
+	t.Run("invalid split", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab:c")
+		require.NoError(t, lx.AddSplitRule("PAIR", pair, func([]rune) []int {
+			return []int{1, 1}
+		}, "KEY", "COLON", "VALUE"))
+
+		assert.Equal(t, []string{`PAIR("ab:c")@0+4:0:0`}, lexAll(lx))
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab:c")
+		require.NoError(t, lx.AddSplitRule("PAIR", pair, split, "KEY", "COLON", "VALUE"))
+
+		_, err := lx.Next()
+		require.NoError(t, err)
+
+		require.NoError(t, lx.SeekTo(0))
+		assert.Equal(t, []string{
+			`KEY("ab")@0+2:0:0`,
+			`COLON(":")@2+1:0:2`,
+			`VALUE("c")@3+1:0:3`,
+		}, lexAll(lx))
+	})
+}
+
+func TestCompoundRule(t *testing.T) {
+	qualified := rules.MustCompile(`[a-z]+(\.[a-z]+)+`)
+	split := func(text []rune) []textlexer.Lexeme {
+		var lexemes []textlexer.Lexeme
+		for _, name := range strings.Split(string(text), ".") {
+			if len(lexemes) > 0 {
+				lexemes = append(lexemes, *textlexer.NewLexeme("DOT", "."))
+			}
+			lexemes = append(lexemes, *textlexer.NewLexeme("IDENT", name))
+		}
+		return lexemes
+	}
+
+	lexAll := func(lx *textlexer.TextLexer) []string {
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, fmt.Sprintf("%v:%d:%d", lex, lex.Line(), lex.Col()))
+		}
+		return out
+	}
+
+	t.Run("qualified name", func(t *testing.T) {
+		lx := textlexer.NewFromString("x = a.bc.d", textlexer.WithSkipLeadingWhitespace())
+		require.NoError(t, lx.AddCompoundRule("QUALIFIED", qualified, split))
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("EQUAL", rules.NewSingleMatch('='))
+
+		assert.Equal(t, []string{
+			`WORD("x")@0+1:0:0`,
+			`EQUAL("=")@2+1:0:2`,
+			`IDENT("a")@4+1:0:4`,
+			`DOT(".")@5+1:0:5`,
+			`IDENT("bc")@6+2:0:6`,
+			`DOT(".")@8+1:0:8`,
+			`IDENT("d")@9+1:0:9`,
+		}, lexAll(lx))
+
+		assert.Equal(t, map[textlexer.LexemeType]int{"WORD": 1, "EQUAL": 1, "IDENT": 3, "DOT": 2}, lx.TypeCounts())
+	})
+
+	t.Run("mismatched text", func(t *testing.T) {
+		lx := textlexer.NewFromString("a.b")
+		require.NoError(t, lx.AddCompoundRule("QUALIFIED", qualified, func([]rune) []textlexer.Lexeme {
+			return []textlexer.Lexeme{*textlexer.NewLexeme("IDENT", "a"), *textlexer.NewLexeme("IDENT", "b")}
+		}))
+
+		assert.Equal(t, []string{`QUALIFIED("a.b")@0+3:0:0`}, lexAll(lx))
+	})
+}
+
+func TestSingleRule(t *testing.T) {
+	// a rule that never matches keeps the lexer off the single rule path
+	never := func(r rune) (textlexer.Rule, textlexer.State) {
+		return nil, textlexer.StateReject
+	}
+
+	lexAll := func(lx *textlexer.TextLexer) []string {
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err != nil {
+				out = append(out, err.Error())
+				return out
+			}
+			out = append(out, fmt.Sprintf("%v:%d:%d:%v", lex, lex.Line(), lex.Col(), lex.UnknownReason()))
+		}
+	}
+
+	testCases := []struct {
+		name string
+		in   string
+		rule textlexer.Rule
+		opts []textlexer.Option
+	}{
+		{"words", "hello, wörld 12", rules.Word, nil},
+		{"push back", "1.5 2. 3..4", rules.UnsignedFloat, nil},
+		{"no match", "abc", rules.NewLiteralMatch("abd"), nil},
+		{"unterminated", `"abc`, rules.DoubleQuotedString, []textlexer.Option{textlexer.AcceptInconclusiveAtEOF(true)}},
+		{"read ahead", "aaaa", rules.NewLiteralMatch("aaaaa"), []textlexer.Option{textlexer.WithMaxReadAhead(2)}},
+		{"skip whitespace", " a\n  b ", rules.Word, []textlexer.Option{textlexer.WithSkipLeadingWhitespace()}},
+		{"max input", "abc def", rules.Word, []textlexer.Option{textlexer.WithMaxInput(5)}},
+		{"empty", "", rules.Word, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			single := textlexer.NewFromString(tc.in, tc.opts...)
+			single.MustAddRule("RULE", tc.rule)
+
+			general := textlexer.NewFromString(tc.in, tc.opts...)
+			general.MustAddRule("RULE", tc.rule)
+			general.MustAddRule("NEVER", never)
+
+			assert.Equal(t, lexAll(general), lexAll(single))
+		})
+	}
+}
+
+func TestZeroCopy(t *testing.T) {
+	in := "héllo wörld 12 ?? a:b\r\n"
+
+	lexAll := func(lx *textlexer.TextLexer) []*textlexer.Lexeme {
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("INT", rules.UnsignedInteger)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+		require.NoError(t, lx.AddSplitRule("PAIR", rules.MustCompile(`[a-z]+:[a-z]+`), func(text []rune) []int {
+			return []int{1, 1, len(text) - 2}
+		}, "KEY", "", "VALUE"))
+
+		var out []*textlexer.Lexeme
+		for {
+			lex, err := lx.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex)
+		}
+		return out
+	}
+
+	strs := func(lexemes []*textlexer.Lexeme) []string {
+		out := make([]string, len(lexemes))
+		for i := range lexemes {
+			out[i] = lexemes[i].String()
+		}
+		return out
+	}
+
+	expected := strs(lexAll(textlexer.NewFromString(in)))
+
+	for name, newLexer := range map[string]func(opts ...textlexer.Option) *textlexer.TextLexer{
+		"string": func(opts ...textlexer.Option) *textlexer.TextLexer {
+			return textlexer.NewFromString(in, opts...)
+		},
+		"bytes": func(opts ...textlexer.Option) *textlexer.TextLexer {
+			return textlexer.NewFromBytes([]byte(in), opts...)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			lexemes := lexAll(newLexer(textlexer.WithZeroCopy()))
+			assert.Equal(t, expected, strs(lexemes))
+
+			for _, lex := range lexemes {
+				assert.Equal(t, string(lex.Runes()), lex.Text())
+				assert.Zero(t, testing.AllocsPerRun(10, func() {
+					textSink = lex.Text()
+				}), "lexeme %v", lex)
+			}
+
+			merged := lexAll(newLexer(textlexer.WithZeroCopy(), textlexer.WithMergedUnknown()))
+			assert.Equal(t, strs(lexAll(newLexer(textlexer.WithMergedUnknown()))), strs(merged))
+			assert.Contains(t, strs(merged), `UNKNOWN("??")@15+2`)
+		})
+	}
+
+	t.Run("normalized newlines", func(t *testing.T) {
+		lexemes := lexAll(textlexer.NewFromString(in, textlexer.WithZeroCopy(), textlexer.NormalizeNewlines(true)))
+		assert.Equal(t, "\n", lexemes[len(lexemes)-1].Text())
+	})
+
+	t.Run("invalid UTF-8", func(t *testing.T) {
+		in := "ab \xff"
+
+		expected := strs(lexAll(textlexer.NewFromString(in)))
+		lexemes := lexAll(textlexer.NewFromString(in, textlexer.WithZeroCopy()))
+		assert.Equal(t, expected, strs(lexemes))
+
+		last := lexemes[len(lexemes)-1]
+		assert.Equal(t, "\uFFFD", last.Text())
+		assert.Equal(t, string(last.Runes()), last.Text())
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	nextN := func(t *testing.T, lx *textlexer.TextLexer, n int) []string {
+		var out []string
+		for i := 0; i < n; i++ {
+			lex, err := lx.Next()
+			require.NoError(t, err)
+
+			out = append(out, fmt.Sprintf("%v:%d:%d", lex, lex.Line(), lex.Col()))
+		}
+		return out
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader("ab 12\ncd ef"))
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("INT", rules.UnsignedInteger)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		nextN(t, lx, 2)
+
+		snap := lx.Snapshot()
+		first := nextN(t, lx, 3)
+		assert.Equal(t, []string{`INT("12")@3+2:0:3`, `WHITESPACE("\n")@5+1:0:5`, `WORD("cd")@6+2:1:0`}, first)
+
+		require.NoError(t, lx.Restore(snap))
+		assert.Equal(t, first, nextN(t, lx, 3))
+
+		// a snapshot can be restored more than once
+		require.NoError(t, lx.Restore(snap))
+		assert.Equal(t, first, nextN(t, lx, 3))
+	})
+
+	t.Run("previous lexeme", func(t *testing.T) {
+		lx := textlexer.NewFromString("a b", textlexer.WithSkipLeadingWhitespace())
+		lx.MustAddRule("WORD", rules.Word)
+		require.NoError(t, lx.AddRuleWithContext("SECOND", func(prev *textlexer.Lexeme, r rune) (textlexer.Rule, textlexer.State) {
+			if prev != nil && prev.Text() == "a" {
+				return rules.Word(r)
+			}
+			return nil, textlexer.StateReject
+		}))
+
+		nextN(t, lx, 1)
+		snap := lx.Snapshot()
+
+		assert.Equal(t, []string{`SECOND("b")@2+1:0:2`}, nextN(t, lx, 1))
+
+		require.NoError(t, lx.Restore(snap))
+		assert.Equal(t, []string{`SECOND("b")@2+1:0:2`}, nextN(t, lx, 1))
+	})
+
+	t.Run("split lexemes", func(t *testing.T) {
+		lx := textlexer.NewFromString("a:b c:d")
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+		require.NoError(t, lx.AddSplitRule("PAIR", rules.MustCompile(`[a-z]:[a-z]`), func(text []rune) []int {
+			return []int{1, 1, 1}
+		}, "KEY", "COLON", "VALUE"))
+
+		nextN(t, lx, 1)
+		snap := lx.Snapshot()
+
+		first := nextN(t, lx, 4)
+		require.NoError(t, lx.Restore(snap))
+		assert.Equal(t, first, nextN(t, lx, 4))
+	})
+}
+
+func TestMaxRules(t *testing.T) {
+	lx := textlexer.New(strings.NewReader(""), textlexer.WithMaxRules(2))
+
+	require.NoError(t, lx.AddRule("WORD", rules.Word))
+	require.NoError(t, lx.AddRule("WHITESPACE", rules.Whitespace))
+
+	err := lx.AddRule("INT", rules.UnsignedInteger)
+	assert.Error(t, err)
+
+	assert.Equal(t, []textlexer.LexemeType{"WORD", "WHITESPACE"}, lx.Rules())
+}
+
+func TestFirstRuneIndex(t *testing.T) {
+	in := "if x = 12 / 3 while /re/ { y = -4.5 }"
+
+	lexAll := func(opts ...textlexer.Option) []string {
+		lx := textlexer.NewFromString(in, append(opts, textlexer.WithSkipLeadingWhitespace())...)
+
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("KEYWORD", rules.AnyOfStrings("if", "while"))
+		lx.MustAddRule("NUMBER", rules.Numeric)
+		lx.MustAddRule("OPERATOR", rules.BasicMathOperator)
+		lx.MustAddRule("EQUAL", rules.Equal)
+		lx.MustAddRule("BRACE", rules.NewMatchAnyOf(rules.LBrace, rules.RBrace))
+
+		regexp := rules.MustCompile(`/[^/]*/`)
+		err := lx.AddRuleWithContext("REGEXP", func(prev *textlexer.Lexeme, r rune) (textlexer.Rule, textlexer.State) {
+			if prev != nil && prev.Type == "NUMBER" {
+				return nil, textlexer.StateReject
+			}
+			return regexp(r)
+		})
+		require.NoError(t, err)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.String())
+		}
+
+		return out
+	}
+
+	expected := lexAll()
+	assert.Contains(t, expected, `REGEXP("/re/")@20+4`)
+	assert.Contains(t, expected, `OPERATOR("/")@10+1`)
+	assert.Contains(t, expected, `KEYWORD("while")@14+5`)
+
+	assert.Equal(t, expected, lexAll(textlexer.WithFirstRuneIndex()))
+}
+
+// newCompactionLexer returns a lexer where LT matches "<" or "<a...a>", on
+// the given input it reads each chunk before pushing it back, leaving a one
+// rune lexeme behind a large read buffer.
+func newCompactionLexer(chunkSize, numChunks int) *textlexer.TextLexer {
+	lt := rules.Compose(
+		rules.NewSingleMatch('<'),
+		rules.Optional(rules.Compose(
+			rules.Repeat(rules.NewSingleMatch('a'), 1, -1),
+			rules.NewSingleMatch('>'),
+		)),
+	)
+
+	chunk := "<" + strings.Repeat("a", chunkSize) + ";"
+
+	lx := textlexer.NewFromString(strings.Repeat(chunk, numChunks))
+	lx.MustAddRule("LT", lt)
+	lx.MustAddRule("WORD", rules.Word)
+	lx.MustAddRule("SEMICOLON", rules.NewSingleMatch(';'))
+
+	return lx
+}
+
+// retainedLTs lexes the input of lx and returns the LT lexemes along with the
+// heap they keep alive.
+func retainedLTs(lx *textlexer.TextLexer) ([]*textlexer.Lexeme, uint64, error) {
+	var stats runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	before := stats.HeapAlloc
+
+	var kept []*textlexer.Lexeme
+	for {
+		lex, err := lx.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, 0, err
+		}
+
+		if lex.Type == "LT" {
+			kept = append(kept, lex)
+		}
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+
+	var retained uint64
+	if stats.HeapAlloc > before {
+		retained = stats.HeapAlloc - before
+	}
+
+	return kept, retained, nil
+}
+
+func TestLexerBufferCompaction(t *testing.T) {
+	const (
+		chunkSize = 100_000
+		numChunks = 50
+	)
+
+	kept, retained, err := retainedLTs(newCompactionLexer(chunkSize, numChunks))
+	require.NoError(t, err)
+	require.Len(t, kept, numChunks)
+
+	// without compaction each kept lexeme would pin at least chunkSize runes
+	assert.Less(t, retained, uint64(numChunks*chunkSize))
+	runtime.KeepAlive(kept)
+}
+
+func BenchmarkLexerBufferCompaction(b *testing.B) {
+	b.ReportAllocs()
+
+	var retained uint64
+	for i := 0; i < b.N; i++ {
+		kept, n, err := retainedLTs(newCompactionLexer(10_000, 20))
+		if err != nil {
+			b.Fatal(err)
+		}
+		retained += n
+		runtime.KeepAlive(kept)
+	}
+
+	b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+}
+
+var textSink string
+
+func BenchmarkZeroCopy(b *testing.B) {
+	in := strings.Repeat("lorem ipsum 1234 dolor ", 500)
+
+	run := func(b *testing.B, opts ...textlexer.Option) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			lx := textlexer.NewFromString(in, opts...)
+			lx.MustAddRule("WORD", rules.Word)
+			lx.MustAddRule("INT", rules.UnsignedInteger)
+			lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+			for {
+				lex, err := lx.Next()
+				if err != nil {
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					b.Fatal(err)
+				}
+				textSink = lex.Text()
+			}
+		}
+	}
+
+	b.Run("copy", func(b *testing.B) {
+		run(b)
+	})
+
+	b.Run("zero copy", func(b *testing.B) {
+		run(b, textlexer.WithZeroCopy())
+	})
+}
+
+func BenchmarkSingleRule(b *testing.B) {
+	in := strings.Repeat("lorem ipsum 1234 dolor ", 500)
+
+	never := func(r rune) (textlexer.Rule, textlexer.State) {
+		return nil, textlexer.StateReject
+	}
+
+	run := func(b *testing.B, extra bool) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			lx := textlexer.NewFromString(in)
+			lx.MustAddRule("WORD", rules.Word)
+			if extra {
+				lx.MustAddRule("NEVER", never)
+			}
+
+			for {
+				_, err := lx.Next()
+				if err != nil {
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+
+	b.Run("single rule", func(b *testing.B) {
+		run(b, false)
+	})
+
+	b.Run("general", func(b *testing.B) {
+		run(b, true)
+	})
+}
+
+func BenchmarkManyRules(b *testing.B) {
+	b.Run("default", func(b *testing.B) {
+		benchmarkManyRules(b, false)
+	})
+
+	b.Run("first rune index", func(b *testing.B) {
+		benchmarkManyRules(b, false, textlexer.WithFirstRuneIndex())
+	})
+
+	b.Run("first rune hints", func(b *testing.B) {
+		benchmarkManyRules(b, true)
+	})
+}
+
+func benchmarkManyRules(b *testing.B, hints bool, opts ...textlexer.Option) {
+	var in strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&in, "kw%d ident%d %d ", i*7, i, i)
+	}
+
+	for i := 0; i < b.N; i++ {
+		lx := textlexer.NewFromString(in.String(), opts...)
+
+		for j := 0; j < 1000; j++ {
+			lexType := textlexer.LexemeType(fmt.Sprintf("KW%d", j))
+			rule := rules.NewLiteralMatch(fmt.Sprintf("kw%d", j))
+
+			if hints {
+				if err := lx.AddRuleWithFirstRunes(lexType, rule, []rune{'k'}); err != nil {
+					b.Fatal(err)
+				}
+				continue
+			}
+
+			lx.MustAddRule(lexType, rule)
+		}
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("INT", rules.UnsignedInteger)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		for {
+			_, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestFirstRunes(t *testing.T) {
+	calls := map[rune]int{}
+
+	counted := func(rule textlexer.Rule) textlexer.Rule {
+		return func(r rune) (textlexer.Rule, textlexer.State) {
+			calls[r]++
+			return rule(r)
+		}
+	}
+
+	lx := textlexer.NewFromString("if x1 in 12 ifs", textlexer.WithSkipLeadingWhitespace())
+
+	lx.MustAddRule("WORD", rules.Word)
+	lx.MustAddRule("INT", rules.UnsignedInteger)
+	require.NoError(t, lx.AddRuleWithFirstRunes("KEYWORD", counted(rules.AnyOfStrings("if", "in")), []rune{'i'}))
+
+	err := lx.AddRuleWithFirstRunes("KEYWORD", rules.Word, []rune{'i'})
+	assert.Error(t, err)
+
+	var out []string
+	for {
+		lex, err := lx.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		out = append(out, lex.String())
+	}
+
+	expected := []string{
+		`KEYWORD("if")@0+2`,
+		`WORD("x1")@3+2`,
+		`KEYWORD("in")@6+2`,
+		`INT("12")@9+2`,
+		`WORD("ifs")@12+3`,
+	}
+	assert.Equal(t, expected, out)
+
+	// the keyword rule was never tried on lexemes starting with other runes
+	assert.Zero(t, calls['x'])
+	assert.Zero(t, calls['1'])
+	assert.Equal(t, 3, calls['i'])
+}
+
+func TestPosition(t *testing.T) {
+	lx := textlexer.NewFromString("ab cd\nef\n\ngh")
+
+	lx.MustAddRule("WORD", rules.Word)
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+	offset, line, col := lx.Position()
+	assert.Equal(t, [3]int{0, 0, 0}, [3]int{offset, line, col})
+
+	expected := [][3]int{
+		{2, 0, 2},  // "ab"
+		{3, 0, 3},  // " "
+		{5, 0, 5},  // "cd"
+		{6, 1, 0},  // "\n"
+		{8, 1, 2},  // "ef"
+		{10, 3, 0}, // "\n\n"
+		{12, 3, 2}, // "gh"
+	}
+
+	for _, e := range expected {
+		_, err := lx.Next()
+		require.NoError(t, err)
+
+		offset, line, col := lx.Position()
+		assert.Equal(t, e, [3]int{offset, line, col})
+	}
+
+	_, err := lx.Next()
+	assert.Equal(t, io.EOF, err)
+
+	offset, line, col = lx.Position()
+	assert.Equal(t, [3]int{12, 3, 2}, [3]int{offset, line, col})
+
+	require.NoError(t, lx.SeekTo(6))
+
+	offset, line, col = lx.Position()
+	assert.Equal(t, [3]int{6, 1, 0}, [3]int{offset, line, col})
+}
+
+// eagerEOFReader returns io.EOF along with the last rune of the input.
+type eagerEOFReader struct {
+	*strings.Reader
+}
+
+func (r *eagerEOFReader) ReadRune() (rune, int, error) {
+	ch, size, err := r.Reader.ReadRune()
+	if err == nil && r.Reader.Len() == 0 {
+		return ch, size, io.EOF
+	}
+	return ch, size, err
+}
+
+func TestRuneAlongWithEOF(t *testing.T) {
+	for _, normalize := range []bool{false, true} {
+		t.Run(fmt.Sprintf("normalize newlines %v", normalize), func(t *testing.T) {
+			lx := textlexer.New(&eagerEOFReader{strings.NewReader("ab cd\r")}, textlexer.NormalizeNewlines(normalize))
+
+			lx.MustAddRule("WORD", rules.Word)
+			lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+			var out []string
+			for {
+				lex, err := lx.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+
+				out = append(out, lex.Text())
+			}
+
+			newline := "\r"
+			if normalize {
+				newline = "\n"
+			}
+			assert.Equal(t, []string{"ab", " ", "cd", newline}, out)
+		})
+	}
+
+	t.Run("last rune of a lexeme", func(t *testing.T) {
+		lx := textlexer.New(&eagerEOFReader{strings.NewReader("ab cd")})
+
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.Text())
+		}
+
+		assert.Equal(t, []string{"ab", " ", "cd"}, out)
+	})
+}
+
+func TestAddRules(t *testing.T) {
+	t.Run("in order", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab 12")
+
+		err := lx.AddRules(textlexer.RuleSet{
+			{"WORD", rules.Word},
+			{"INT", rules.UnsignedInteger},
+			{"WHITESPACE", rules.Whitespace},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []textlexer.LexemeType{"WORD", "INT", "WHITESPACE"}, lx.Rules())
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.String())
+		}
+
+		assert.Equal(t, []string{`WORD("ab")@0+2`, `WHITESPACE(" ")@2+1`, `INT("12")@3+2`}, out)
+	})
+
+	t.Run("duplicate in the batch", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab 12")
+		lx.MustAddRule("WORD", rules.Word)
+
+		err := lx.AddRules(textlexer.RuleSet{
+			{"INT", rules.UnsignedInteger},
+			{"WHITESPACE", rules.Whitespace},
+			{"INT", rules.SignedInteger},
+			{"FLOAT", rules.UnsignedFloat},
+		})
+		assert.Error(t, err)
+
+		assert.Equal(t, []textlexer.LexemeType{"WORD"}, lx.Rules())
+		assert.False(t, lx.HasRule("INT"))
+		assert.False(t, lx.HasRule("WHITESPACE"))
+	})
+
+	t.Run("duplicate of a registered rule", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab 12")
+		lx.MustAddRule("WORD", rules.Word)
+
+		err := lx.AddRules(textlexer.RuleSet{
+			{"INT", rules.UnsignedInteger},
+			{"WORD", rules.Word},
+		})
+		assert.Error(t, err)
+
+		assert.Equal(t, []textlexer.LexemeType{"WORD"}, lx.Rules())
+	})
+
+	t.Run("over the limit", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab 12", textlexer.WithMaxRules(2))
+		lx.MustAddRule("WORD", rules.Word)
+
+		err := lx.AddRules(textlexer.RuleSet{
+			{"INT", rules.UnsignedInteger},
+			{"WHITESPACE", rules.Whitespace},
+		})
+		assert.Error(t, err)
+
+		assert.Equal(t, []textlexer.LexemeType{"WORD"}, lx.Rules())
+	})
+}
+
+func TestBOM(t *testing.T) {
+	in := "\uFEFFab \uFEFF\ncd"
+
+	lexAll := func(t *testing.T, lx *textlexer.TextLexer) []string {
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, fmt.Sprintf("%v:%d:%d", lex, lex.Line(), lex.Col()))
+		}
+		return out
+	}
+
+	t.Run("default", func(t *testing.T) {
+		out := lexAll(t, textlexer.NewFromString(in))
+		assert.Equal(t, `UNKNOWN("\ufeff")@0+1:0:0`, out[0])
+	})
+
+	t.Run("rule", func(t *testing.T) {
+		lx := textlexer.NewFromString(in)
+		require.NoError(t, lx.AddSymbolRule("BOM", rules.BOM))
+
+		// only the mark at the start of the input is matched
+		assert.Equal(t, []string{
+			`BOM("\ufeff")@0+1:0:0`,
+			`WORD("ab")@1+2:0:1`,
+			`WHITESPACE(" ")@3+1:0:3`,
+			`UNKNOWN("\ufeff")@4+1:0:4`,
+			`WHITESPACE("\n")@5+1:0:5`,
+			`WORD("cd")@6+2:1:0`,
+		}, lexAll(t, lx))
+	})
+
+	t.Run("strip", func(t *testing.T) {
+		lx := textlexer.NewFromString(in, textlexer.WithStripBOM(), textlexer.WithZeroCopy())
+
+		out := lexAll(t, lx)
+		assert.Equal(t, []string{
+			`WORD("ab")@0+2:0:0`,
+			`WHITESPACE(" ")@2+1:0:2`,
+			`UNKNOWN("\ufeff")@3+1:0:3`,
+			`WHITESPACE("\n")@4+1:0:4`,
+			`WORD("cd")@5+2:1:0`,
+		}, out)
+
+		require.NoError(t, lx.SeekTo(0))
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, `WORD("ab")@0+2`, lex.String())
+	})
+
+	t.Run("strip without mark", func(t *testing.T) {
+		lx := textlexer.New(strings.NewReader("ab"), textlexer.WithStripBOM())
+		assert.Equal(t, []string{`WORD("ab")@0+2:0:0`}, lexAll(t, lx))
+	})
+}
+
+func TestSymbolRuleFunc(t *testing.T) {
+	in := "ab 12.5 + /* c */ 7"
+
+	lexAll := func(add func(lx *textlexer.TextLexer, lexType textlexer.LexemeType, rule textlexer.Rule)) []string {
+		lx := textlexer.NewFromString(in)
+
+		add(lx, "WORD", rules.Word)
+		add(lx, "FLOAT", rules.UnsignedFloat)
+		add(lx, "INT", rules.UnsignedInteger)
+		add(lx, "WHITESPACE", rules.Whitespace)
+		add(lx, "COMMENT", rules.SlashStarComment)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.String())
+		}
+		return out
+	}
+
+	expected := lexAll(func(lx *textlexer.TextLexer, lexType textlexer.LexemeType, rule textlexer.Rule) {
+		lx.MustAddRule(lexType, rule)
+	})
+	assert.Contains(t, expected, `COMMENT("/* c */")@10+7`)
+
+	actual := lexAll(func(lx *textlexer.TextLexer, lexType textlexer.LexemeType, rule textlexer.Rule) {
+		require.NoError(t, lx.AddSymbolRule(lexType, textlexer.SymbolRuleFunc(rule)))
+	})
+	assert.Equal(t, expected, actual)
+}
+
+func TestAddSymbolRule(t *testing.T) {
+	lx := textlexer.NewFromString("# a\n b # c\n#\n", textlexer.WithFirstRuneIndex())
+
+	lx.MustAddRule("WORD", rules.Word)
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+	lx.MustAddRule("HASH", rules.NewSingleMatch('#'))
+
+	err := lx.AddSymbolRule("DIRECTIVE", rules.NewSymbolFunctionMatcher(func(s textlexer.Symbol) bool {
+		return s.Rune == '#' && s.IsBOL()
+	}))
+	require.NoError(t, err)
+
+	var out []string
+	for {
+		lex, err := lx.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		if lex.Type != "WHITESPACE" {
+			out = append(out, lex.String())
+		}
+	}
+
+	expected := []string{
+		`DIRECTIVE("#")@0+1`,
+		`WORD("a")@2+1`,
+		`WORD("b")@5+1`,
+		`HASH("#")@7+1`,
+		`WORD("c")@9+1`,
+		`DIRECTIVE("#")@11+1`,
+	}
+
+	assert.Equal(t, expected, out)
+}
+
+func TestSymbolPositions(t *testing.T) {
+	var symbols []textlexer.Symbol
+
+	var record textlexer.SymbolRule
+	record = func(s textlexer.Symbol) (textlexer.SymbolRule, textlexer.State) {
+		if s.IsEOF() {
+			return nil, textlexer.StateAccept
+		}
+		symbols = append(symbols, s)
+		return record, textlexer.StateContinue
+	}
+
+	lx := textlexer.NewFromString("a\nbc")
+	require.NoError(t, lx.AddSymbolRule("ALL", record))
+
+	lex, err := lx.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "a\nbc", lex.Text())
+
+	expected := []textlexer.Symbol{
+		{Rune: 'a', Offset: 0, Line: 0, Col: 0, Prev: textlexer.RuneEOF},
+		{Rune: '\n', Offset: 1, Line: 0, Col: 1, Prev: 'a'},
+		{Rune: 'b', Offset: 2, Line: 1, Col: 0, Prev: '\n'},
+		{Rune: 'c', Offset: 3, Line: 1, Col: 1, Prev: 'b'},
+	}
+	assert.Equal(t, expected, symbols)
+
+	assert.True(t, symbols[0].IsBOF())
+	assert.True(t, symbols[0].IsBOL())
+	assert.True(t, symbols[1].IsEOL())
+	assert.True(t, symbols[2].IsBOL())
+	assert.False(t, symbols[2].IsBOF())
+	assert.False(t, symbols[3].IsBOL())
+}
+
+func TestAddRuleWithPriority(t *testing.T) {
+	lexAll := func(lx *textlexer.TextLexer) []string {
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.String())
+		}
+		return out
+	}
+
+	t.Run("insertion order", func(t *testing.T) {
+		lx := textlexer.NewFromString("if iffy", textlexer.WithSkipLeadingWhitespace())
+
+		lx.MustAddRule("KEYWORD", rules.NewLiteralMatch("if"))
+		lx.MustAddRule("IDENTIFIER", rules.Word)
+
+		assert.Equal(t, []string{`IDENTIFIER("if")@0+2`, `IDENTIFIER("iffy")@3+4`}, lexAll(lx))
+	})
+
+	t.Run("higher priority added first", func(t *testing.T) {
+		lx := textlexer.NewFromString("if iffy", textlexer.WithSkipLeadingWhitespace())
+
+		require.NoError(t, lx.AddRuleWithPriority("KEYWORD", rules.NewLiteralMatch("if"), 1))
+		lx.MustAddRule("IDENTIFIER", rules.Word)
+
+		assert.Equal(t, []string{`KEYWORD("if")@0+2`, `IDENTIFIER("iffy")@3+4`}, lexAll(lx))
+	})
+
+	t.Run("lower priority added last", func(t *testing.T) {
+		lx := textlexer.NewFromString("+ ++", textlexer.WithSkipLeadingWhitespace())
+
+		lx.MustAddRule("PLUS", rules.Plus)
+		require.NoError(t, lx.AddRuleWithPriority("OPERATOR", rules.BasicMathOperator, -1))
+
+		assert.Equal(t, []string{`PLUS("+")@0+1`, `PLUS("+")@2+1`, `PLUS("+")@3+1`}, lexAll(lx))
+	})
+}
+
+func TestMergedUnknown(t *testing.T) {
+	lexAll := func(in string, opts ...textlexer.Option) []string {
+		lx := textlexer.NewFromString(in, opts...)
+
+		lx.MustAddRule("INT", rules.UnsignedInteger)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.String())
+		}
+
+		return out
+	}
+
+	assert.Equal(t, []string{
+		`UNKNOWN("@")@0+1`,
+		`UNKNOWN("#")@1+1`,
+		`UNKNOWN("$")@2+1`,
+	}, lexAll("@#$"))
+
+	assert.Equal(t, []string{
+		`UNKNOWN("@#$")@0+3`,
+	}, lexAll("@#$", textlexer.WithMergedUnknown()))
+
+	assert.Equal(t, []string{
+		`INT("12")@0+2`,
+		`UNKNOWN("ab€")@2+3`,
+		`INT("3")@5+1`,
+		`WHITESPACE(" ")@6+1`,
+		`UNKNOWN("x")@7+1`,
+	}, lexAll("12ab€3 x", textlexer.WithMergedUnknown()))
+
+	t.Run("position", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab 1", textlexer.WithMergedUnknown())
+		lx.MustAddRule("INT", rules.UnsignedInteger)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, `UNKNOWN("ab")@0+2`, lex.String())
+		assert.Equal(t, textlexer.UnknownReasonNoRule, lex.UnknownReason())
+
+		offset, line, col := lx.Position()
+		assert.Equal(t, [3]int{2, 0, 2}, [3]int{offset, line, col})
+
+		lex, err = lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, `WHITESPACE(" ")@2+1`, lex.String())
+	})
+}
+
+func TestLexemeRunes(t *testing.T) {
+	lx := textlexer.NewFromString("héllo wörld")
+	lx.MustAddRule("WORD", rules.Word)
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+	lex, err := lx.Next()
+	require.NoError(t, err)
+
+	runes := lex.Runes()
+	assert.Equal(t, []rune(lex.Text()), runes)
+
+	runes[0] = 'j'
+	assert.Equal(t, "héllo", lex.Text())
+	assert.Equal(t, []rune("héllo"), lex.Runes())
+
+	assert.Empty(t, textlexer.NewLexeme("EMPTY", "").Runes())
+}
+
+func TestLexemeEqual(t *testing.T) {
+	lx := textlexer.NewFromString("ab ab")
+	lx.MustAddRule("WORD", rules.Word)
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+	first, err := lx.Next()
+	require.NoError(t, err)
+	_, err = lx.Next()
+	require.NoError(t, err)
+	second, err := lx.Next()
+	require.NoError(t, err)
+
+	same := textlexer.NewLexeme("WORD", "ab")
+
+	assert.True(t, first.Equal(same))
+	assert.True(t, first.Equal(first))
+	assert.False(t, first.Equal(second), "different offsets")
+	assert.True(t, first.EqualIgnoreOffset(second))
+
+	assert.False(t, first.Equal(textlexer.NewLexeme("WORD", "abc")))
+	assert.False(t, first.Equal(textlexer.NewLexeme("IDENT", "ab")))
+	assert.False(t, first.EqualIgnoreOffset(textlexer.NewLexeme("IDENT", "ab")))
+
+	assert.False(t, first.Equal(nil))
+	assert.True(t, (*textlexer.Lexeme)(nil).Equal(nil))
+}
+
+func TestLexemeValues(t *testing.T) {
+	t.Run("int", func(t *testing.T) {
+		n, err := textlexer.NewLexeme("INT", "123").Int()
+		require.NoError(t, err)
+		assert.Equal(t, int64(123), n)
+
+		_, err = textlexer.NewLexeme("INT", "12a").Int()
+		assert.Error(t, err)
+	})
+
+	t.Run("float", func(t *testing.T) {
+		f, err := textlexer.NewLexeme("FLOAT", "12.5").Float()
+		require.NoError(t, err)
+		assert.Equal(t, 12.5, f)
+
+		_, err = textlexer.NewLexeme("FLOAT", "1.2.3").Float()
+		assert.Error(t, err)
+	})
+
+	t.Run("unquote", func(t *testing.T) {
+		testCases := []struct {
+			in  string
+			out string
+		}{
+			{`'a\'b'`, `a'b`},
+			{`"a\tb\""`, "a\tb\""},
+			{"`a\\n`", `a\n`},
+			{`'日本'`, "日本"},
+			{`""`, ""},
+		}
+
+		for _, tc := range testCases {
+			out, err := textlexer.NewLexeme("STRING", tc.in).Unquote()
+			require.NoError(t, err, "input: %q", tc.in)
+			assert.Equal(t, tc.out, out)
+		}
+
+		for _, in := range []string{``, `"`, `abc`, `"abc'`, `'it''`, `"a\"`} {
+			_, err := textlexer.NewLexeme("STRING", in).Unquote()
+			assert.Error(t, err, "input: %q", in)
+		}
+	})
+}
+
+func TestLines(t *testing.T) {
+	newLexer := func(in string) *textlexer.TextLexer {
+		lx := textlexer.NewFromString(in, textlexer.WithSkipLeadingWhitespace(), textlexer.WithStats())
+
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("COMMENT", rules.SlashStarComment)
+
+		return lx
+	}
+
+	t.Run("comment across lines", func(t *testing.T) {
+		lx := newLexer("a b /* one\ntwo */ c\nd\n\n\ne")
+
+		lines := map[int][]string{}
+		var order []int
+
+		err := lx.Lines(func(line int, lexemes []*textlexer.Lexeme) bool {
+			order = append(order, line)
+			for _, lex := range lexemes {
+				lines[line] = append(lines[line], lex.Text())
+			}
+			return true
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{0, 1, 2, 5}, order)
+		assert.Equal(t, map[int][]string{
+			0: {"a", "b", "/* one\ntwo */"},
+			1: {"c"},
+			2: {"d"},
+			5: {"e"},
+		}, lines)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		lx := newLexer("a\nb\nc")
+
+		var order []int
+		err := lx.Lines(func(line int, lexemes []*textlexer.Lexeme) bool {
+			order = append(order, line)
+			return line < 1
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{0, 1}, order)
+
+		// the lexer is where it was after reading the last line
+		read := newLexer("a\nb\nc")
+		for i := 0; i < 2; i++ {
+			_, err := read.Next()
+			require.NoError(t, err)
+		}
+
+		offset, line, col := lx.Position()
+		assert.Equal(t, []int{3, 1, 1}, []int{offset, line, col})
+		assert.Equal(t, read.Stats(), lx.Stats())
+		assert.Equal(t, read.TypeCounts(), lx.TypeCounts())
+
+		// the lexeme that starts the next line is not lost
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, `WORD("c")@4+1`, lex.String())
+		assert.Equal(t, 3, lx.TypeCounts()["WORD"])
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		lx := textlexer.New(&failingReader{Reader: strings.NewReader("a\nb c"), failAt: 4})
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		err := lx.Lines(func(line int, lexemes []*textlexer.Lexeme) bool {
+			return true
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestNewFromReaders(t *testing.T) {
+	lexAll := func(lx *textlexer.TextLexer) []string {
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, fmt.Sprintf("%v:%d:%d", lex, lex.Line(), lex.Col()))
+		}
+		return out
+	}
+
+	readers := func(chunks ...string) []io.RuneReader {
+		var rs []io.RuneReader
+		for _, chunk := range chunks {
+			rs = append(rs, strings.NewReader(chunk))
+		}
+		return rs
+	}
+
+	t.Run("lexeme across readers", func(t *testing.T) {
+		lx := textlexer.NewFromReaders(readers("hel", "lo"))
+		lx.MustAddRule("IDENT", rules.Word)
+
+		assert.Equal(t, []string{`IDENT("hello")@0+5:0:0`}, lexAll(lx))
+	})
+
+	t.Run("positions", func(t *testing.T) {
+		lx := textlexer.NewFromReaders(readers("hé", "", "llo 1\n", "2", "3 wörld"), textlexer.WithSkipLeadingWhitespace())
+		lx.MustAddRule("IDENT", rules.Word)
+		lx.MustAddRule("INT", rules.UnsignedInteger)
+
+		assert.Equal(t, []string{
+			`IDENT("héllo")@0+5:0:0`,
+			`INT("1")@6+1:0:6`,
+			`INT("23")@8+2:1:0`,
+			`IDENT("wörld")@11+5:1:3`,
+		}, lexAll(lx))
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		lx := textlexer.NewFromReaders(readers("ab", "c d"), textlexer.WithSkipLeadingWhitespace())
+		lx.MustAddRule("IDENT", rules.Word)
+
+		assert.Equal(t, []string{`IDENT("abc")@0+3:0:0`, `IDENT("d")@4+1:0:4`}, lexAll(lx))
+
+		require.NoError(t, lx.SeekTo(0))
+		assert.Equal(t, []string{`IDENT("abc")@0+3:0:0`, `IDENT("d")@4+1:0:4`}, lexAll(lx))
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		lx := textlexer.NewFromReaders([]io.RuneReader{
+			strings.NewReader("ab"),
+			bufio.NewReader(iotest.ErrReader(errors.New("broken"))),
+		})
+		lx.MustAddRule("IDENT", rules.Word)
+
+		_, err := lx.Next()
+		assert.ErrorContains(t, err, "broken")
+	})
+
+	t.Run("no readers", func(t *testing.T) {
+		lx := textlexer.NewFromReaders(nil)
+		lx.MustAddRule("IDENT", rules.Word)
+
+		_, err := lx.Next()
+		assert.Equal(t, io.EOF, err)
+	})
+}
+
+func TestNewFromSymbols(t *testing.T) {
+	// "#" only counts as the beginning of a line where the caller says so
+	symbols := []textlexer.Symbol{
+		{Rune: '#', Offset: 0, Line: 0, Col: 4},
+		{Rune: 'a', Offset: 1, Line: 0, Col: 5},
+		{Rune: ' ', Offset: 2, Line: 0, Col: 6},
+		{Rune: '#', Offset: 3, Line: 1, Col: 0},
+		{Rune: 'b', Offset: 4, Line: 1, Col: 1},
+	}
+
+	t.Run("flags", func(t *testing.T) {
+		lx := textlexer.NewFromSymbols(symbols)
+
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+		lx.MustAddRule("HASH", rules.NewSingleMatch('#'))
+
+		err := lx.AddSymbolRule("DIRECTIVE", rules.NewSymbolFunctionMatcher(func(s textlexer.Symbol) bool {
+			return s.Rune == '#' && s.IsBOL()
+		}))
+		require.NoError(t, err)
+
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.String())
+		}
+
+		expected := []string{
+			`HASH("#")@0+1`,
+			`WORD("a")@1+1`,
+			`WHITESPACE(" ")@2+1`,
+			`DIRECTIVE("#")@3+1`,
+			`WORD("b")@4+1`,
+		}
+		assert.Equal(t, expected, out)
+	})
+
+	t.Run("EOF", func(t *testing.T) {
+		var seen []textlexer.Symbol
+
+		var record textlexer.SymbolRule
+		record = func(s textlexer.Symbol) (textlexer.SymbolRule, textlexer.State) {
+			seen = append(seen, s)
+			if s.IsEOF() {
+				return nil, textlexer.StateAccept
+			}
+			return record, textlexer.StateContinue
+		}
+
+		lx := textlexer.NewFromSymbols(symbols)
+		require.NoError(t, lx.AddSymbolRule("ALL", record))
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "#a #b", lex.Text())
+
+		require.Len(t, seen, len(symbols)+1)
+		assert.Equal(t, symbols, seen[:len(symbols)])
+		assert.True(t, seen[len(symbols)].IsEOF())
+
+		_, err = lx.Next()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		lx := textlexer.NewFromSymbols(symbols)
+		lx.MustAddRule("ANY", rules.NoneOf(' '))
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		for i := 0; i < 3; i++ {
+			_, err := lx.Next()
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, lx.SeekTo(1))
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, `ANY("a")@1+1`, lex.String())
+	})
+}
+
+func TestGrammar(t *testing.T) {
+	g := textlexer.NewGrammar()
+
+	g.MustAddRule("WORD", rules.Word)
+	g.MustAddRule("INT", rules.UnsignedInteger)
+	g.MustAddRule("WHITESPACE", rules.Whitespace)
+
+	assert.Error(t, g.AddRule("WORD", rules.Word))
+
+	lexAll := func(lx *textlexer.TextLexer) ([]string, error) {
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				return out, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, lex.String())
+		}
+	}
+
+	t.Run("independent lexers", func(t *testing.T) {
+		a, err := g.NewLexer(strings.NewReader("ab 12"))
+		require.NoError(t, err)
+
+		b, err := g.NewLexer(strings.NewReader("34 cd"))
+		require.NoError(t, err)
+
+		lex, err := a.Next()
+		require.NoError(t, err)
+		assert.Equal(t, `WORD("ab")@0+2`, lex.String())
+
+		lex, err = b.Next()
+		require.NoError(t, err)
+		assert.Equal(t, `INT("34")@0+2`, lex.String())
+
+		lex, err = a.Next()
+		require.NoError(t, err)
+		assert.Equal(t, `WHITESPACE(" ")@2+1`, lex.String())
+
+		assert.Equal(t, []textlexer.LexemeType{"WORD", "INT", "WHITESPACE"}, b.Rules())
+	})
+
+	t.Run("concurrent lexers", func(t *testing.T) {
+		const workers = 8
+
+		in := strings.Repeat("word 123 ", 200)
+
+		lx, err := g.NewLexer(strings.NewReader(in))
+		require.NoError(t, err)
+
+		expected, err := lexAll(lx)
+		require.NoError(t, err)
+
+		results := make(chan []string, workers)
+		errs := make(chan error, workers)
+
+		for i := 0; i < workers; i++ {
+			go func() {
+				lx, err := g.NewLexer(strings.NewReader(in))
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				out, err := lexAll(lx)
+				if err != nil {
+					errs <- err
+					return
+				}
+				results <- out
+			}()
+		}
+
+		for i := 0; i < workers; i++ {
+			select {
+			case out := <-results:
+				assert.Equal(t, expected, out)
+			case err := <-errs:
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("lexer rules", func(t *testing.T) {
+		lx, err := g.NewLexer(strings.NewReader("ab"))
+		require.NoError(t, err)
+		lx.MustAddRule("PLUS", rules.Plus)
+
+		assert.Equal(t, []textlexer.LexemeType{"WORD", "INT", "WHITESPACE", "PLUS"}, lx.Rules())
+
+		lx, err = g.NewLexer(strings.NewReader("ab"))
+		require.NoError(t, err)
+		assert.False(t, lx.HasRule("PLUS"))
+	})
+
+	t.Run("too many rules", func(t *testing.T) {
+		_, err := g.NewLexer(strings.NewReader("ab"), textlexer.WithMaxRules(2))
+		assert.Error(t, err)
+	})
+}
+
+func TestSwitchGrammar(t *testing.T) {
+	raw := textlexer.NewGrammar()
+	raw.MustAddRule("RAW", rules.Until(rules.NewLiteralMatch("</raw>")))
+
+	lx := textlexer.NewFromString("a <raw>if (x < 1) { y(); }</raw> b")
+	lx.MustAddRule("OPEN", rules.NewLiteralMatch("<raw>"))
+	lx.MustAddRule("CLOSE", rules.NewLiteralMatch("</raw>"))
+	lx.MustAddRule("WHITESPACE", rules.Whitespace)
+	require.NoError(t, lx.AddClassifyingRule("WORD", rules.Word, func(text []rune) textlexer.LexemeType {
+		if string(text) == "b" {
+			return "KEYWORD"
+		}
+		return "WORD"
+	}))
+
+	var markup *textlexer.Grammar
+
+	var out []string
+	for {
+		lex, err := lx.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		out = append(out, lex.String())
+
+		switch lex.Type {
+		case "OPEN":
+			markup, err = lx.SwitchGrammar(raw)
+			require.NoError(t, err)
+		case "RAW":
+			_, err = lx.SwitchGrammar(markup)
+			require.NoError(t, err)
+		}
+	}
+
+	assert.Equal(t, []string{
+		`WORD("a")@0+1`,
+		`WHITESPACE(" ")@1+1`,
+		`OPEN("<raw>")@2+5`,
+		`RAW("if (x < 1) { y(); }")@7+19`,
+		`CLOSE("</raw>")@26+6`,
+		`WHITESPACE(" ")@32+1`,
+		`KEYWORD("b")@33+1`,
+	}, out)
+
+	t.Run("restored grammar", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab")
+		require.NoError(t, lx.AddRuleWithPriority("A", rules.NewLiteralMatch("ab"), 1))
+		lx.MustAddRule("B", rules.NewLiteralMatch("ab"))
+
+		prev, err := lx.SwitchGrammar(raw)
+		require.NoError(t, err)
+
+		// a rule added to the returned grammar is restored too
+		prev.MustAddRule("C", rules.NewLiteralMatch("c"))
+
+		_, err = lx.SwitchGrammar(prev)
+		require.NoError(t, err)
+		assert.True(t, lx.HasRule("C"))
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, `A("ab")@0+2`, lex.String())
+	})
+
+	t.Run("max rules", func(t *testing.T) {
+		markup := textlexer.NewGrammar()
+		markup.MustAddRule("OPEN", rules.NewLiteralMatch("<raw>"))
+		markup.MustAddRule("CLOSE", rules.NewLiteralMatch("</raw>"))
+
+		lx, err := raw.NewLexer(strings.NewReader(""), textlexer.WithMaxRules(1))
+		require.NoError(t, err)
+
+		_, err = lx.SwitchGrammar(markup)
+		assert.Error(t, err)
+	})
+}
+
+func TestTextTransform(t *testing.T) {
+	keywords := map[string]bool{"select": true, "from": true}
+
+	upperKeywords := func(text []rune) []rune {
+		if keywords[strings.ToLower(string(text))] {
+			return []rune(strings.ToUpper(string(text)))
+		}
+		return text
+	}
+
+	lexAll := func(lx *textlexer.TextLexer) []string {
+		var out []string
+		for {
+			lex, err := lx.Next()
+			if err == io.EOF {
+				return out
+			}
+			require.NoError(t, err)
+
+			out = append(out, lex.String())
+		}
+	}
+
+	expected := []string{
+		`WORD("SELECT")@0+6`,
+		`WHITESPACE(" ")@6+1`,
+		`WORD("name")@7+4`,
+		`WHITESPACE(" ")@11+1`,
+		`WORD("FROM")@12+4`,
+		`WHITESPACE(" ")@16+1`,
+		`WORD("users")@17+5`,
+	}
+
+	for _, opts := range [][]textlexer.Option{
+		{textlexer.WithTextTransform(upperKeywords)},
+		{textlexer.WithTextTransform(upperKeywords), textlexer.WithZeroCopy()},
+	} {
+		lx := textlexer.NewFromString("select name From users", opts...)
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+
+		assert.Equal(t, expected, lexAll(lx))
+	}
+
+	t.Run("length changes", func(t *testing.T) {
+		lx := textlexer.NewFromString("straße 1", textlexer.WithTextTransform(func(text []rune) []rune {
+			return []rune(strings.ReplaceAll(string(text), "ß", "ss"))
+		}))
+		lx.MustAddRule("WORD", rules.Word)
+		lx.MustAddRule("WHITESPACE", rules.Whitespace)
+		lx.MustAddRule("INT", rules.UnsignedInteger)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "strasse", lex.Text())
+		assert.Equal(t, 6, lex.Len())
+
+		assert.Equal(t, []string{`WHITESPACE(" ")@6+1`, `INT("1")@7+1`}, lexAll(lx))
+	})
+
+	t.Run("rules see the input", func(t *testing.T) {
+		lx := textlexer.NewFromString("ab", textlexer.WithTextTransform(func(text []rune) []rune {
+			return []rune("x")
+		}))
+		require.NoError(t, lx.AddClassifyingRule("WORD", rules.Word, func(text []rune) textlexer.LexemeType {
+			if string(text) == "ab" {
+				return "AB"
+			}
+			return ""
+		}))
+
+		assert.Equal(t, []string{`AB("x")@0+2`}, lexAll(lx))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		lx := textlexer.NewFromString("abc", textlexer.WithTextTransform(func(text []rune) []rune {
+			return append(text, '!')
+		}))
+		lx.MustAddRule("WORD", rules.Word)
+
+		lex, err := lx.Next()
+		require.NoError(t, err)
+
+		data, err := json.Marshal(lex)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"type":"WORD","text":"abc!","offset":0,"len":4,"line":0,"col":0}`, string(data))
+
+		var out textlexer.Lexeme
+		require.NoError(t, json.Unmarshal(data, &out))
+		assert.Equal(t, "abc!", out.Text())
+		assert.Equal(t, 4, out.Len())
+	})
+}